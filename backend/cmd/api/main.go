@@ -2,10 +2,12 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -46,20 +48,57 @@ func main() {
 	}
 
 	// Initialize services
-	authService := services.NewAuthService(cfg.JWTSecret)
-	fileService := services.NewFileService(database, redisClient)
+	googleVerifier := services.NewGoogleVerifier(cfg.GoogleJWKSURL, strings.Split(cfg.GoogleClientIDs, ","))
+	authService := services.NewAuthService(cfg.JWTSecret, cfg.AccessTokenTTL, googleVerifier)
+	sessionService := services.NewSessionService(database, redisClient, authService, cfg.RefreshTokenTTL)
+	fileService := services.NewFileService(database, redisClient, cfg.FreeCloudSyncDevices)
 	duplicateService := services.NewDuplicateService(database)
 	duplicateDetector := services.NewDuplicateDetector(database)
+	syncService := services.NewSyncService(database, fileService)
+	uploadSessionService := services.NewUploadSessionService(database, fileService)
+	deviceService := services.NewDeviceService(database, fileService)
+	actionService := services.NewActionService(database, redisClient, fileService)
+	cleanupService := services.NewCleanupService(database, duplicateDetector, fileService, actionService)
+	reportService := services.NewReportService(database)
+	trashService := services.NewTrashService(database, fileService, actionService, cfg.TrashRetention)
+	exportService := services.NewExportService(database, duplicateService, reportService)
+	safetyService := services.NewSafetyService(database)
+	policyService := services.NewPolicyService(database, cleanupService)
+	scanService := services.NewScanService(database, actionService)
+	notifier, err := newNotifier(cfg, logger)
+	if err != nil {
+		logger.Fatal("Failed to initialize notifier", zap.Error(err))
+	}
+	notificationService := services.NewNotificationService(database, notifier)
+	mailer, err := services.NewSMTPMailer(cfg.SMTPAddr, cfg.SMTPUsername, cfg.SMTPPassword, cfg.MailFrom)
+	if err != nil {
+		logger.Fatal("Failed to initialize mailer", zap.Error(err))
+	}
+	digestService := services.NewDigestService(database, mailer, cfg.PublicURL)
 
 	// Initialize handlers
-	authHandler := handlers.NewAuthHandler(authService)
-	fileHandler := handlers.NewFileHandler(fileService)
-	duplicateHandler := handlers.NewDuplicateHandler(duplicateService)
+	authHandler := handlers.NewAuthHandler(database, authService, sessionService)
+	fileHandler := handlers.NewFileHandler(fileService, cfg.MaxManifestBytes)
+	duplicateHandler := handlers.NewDuplicateHandler(duplicateService, cleanupService)
 	duplicateAdvancedHandler := handlers.NewDuplicateAdvancedHandler(duplicateDetector)
 	subscriptionHandler := handlers.NewSubscriptionHandler(db)
+	syncHandler := handlers.NewSyncHandler(syncService, cfg.MaxManifestBytes)
+	uploadSessionHandler := handlers.NewUploadSessionHandler(uploadSessionService, cfg.MaxManifestBytes)
+	deviceHandler := handlers.NewDeviceHandler(deviceService)
+	cleanupHandler := handlers.NewCleanupHandler(cleanupService)
+	reportHandler := handlers.NewReportHandler(reportService, exportService)
+	trashHandler := handlers.NewTrashHandler(trashService)
+	actionHandler := handlers.NewActionHandler(actionService)
+	safetyHandler := handlers.NewSafetyHandler(safetyService)
+	policyHandler := handlers.NewPolicyHandler(policyService)
+	scanHandler := handlers.NewScanHandler(scanService)
+	notificationHandler := handlers.NewNotificationHandler(notificationService)
+	digestHandler := handlers.NewDigestHandler(digestService)
+
+	idempotency := middleware.IdempotencyMiddleware(redisClient, cfg.IdempotencyTTL, cfg.MaxManifestBytes)
 
 	// Setup router
-	router := setupRouter(cfg, logger, authService, authHandler, fileHandler, duplicateHandler, duplicateAdvancedHandler, subscriptionHandler)
+	router := setupRouter(cfg, logger, authService, sessionService, idempotency, authHandler, fileHandler, duplicateHandler, duplicateAdvancedHandler, subscriptionHandler, syncHandler, uploadSessionHandler, deviceHandler, cleanupHandler, reportHandler, trashHandler, actionHandler, safetyHandler, policyHandler, scanHandler, notificationHandler, digestHandler)
 
 	// Start server
 	srv := &http.Server{
@@ -76,11 +115,24 @@ func main() {
 
 	logger.Info("Server started", zap.String("port", cfg.Port))
 
+	// Purge expired trash and refresh tokens, record storage snapshots, run due cleanup
+	// policies, request scheduled scans and send notifications and digests in the background
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	go runTrashPurge(jobsCtx, trashService, cfg.TrashPurgeInterval, logger)
+	go runSnapshotRecorder(jobsCtx, fileService, cfg.SnapshotInterval, logger)
+	go runRefreshTokenPurge(jobsCtx, sessionService, time.Hour, logger)
+	go runCleanupPolicies(jobsCtx, policyService, cfg.PolicyEvalInterval, logger)
+	scanLease := services.NewLease(redisClient, "scan-scheduler", 3*cfg.ScanSchedulerInterval)
+	go runScanScheduler(jobsCtx, scanService, scanLease, cfg.ScanSchedulerInterval, logger)
+	go runNotificationDispatcher(jobsCtx, notificationService, cfg.NotificationDispatchInterval, logger)
+	go runDigestSender(jobsCtx, digestService, cfg.DigestInterval, logger)
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
 	logger.Info("Shutting down server...")
+	stopJobs()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
@@ -92,7 +144,7 @@ func main() {
 	logger.Info("Server exited")
 }
 
-func setupRouter(cfg *config.Config, logger *zap.Logger, authService *services.AuthService, authHandler *handlers.AuthHandler, fileHandler *handlers.FileHandler, duplicateHandler *handlers.DuplicateHandler, duplicateAdvancedHandler *handlers.DuplicateAdvancedHandler, subscriptionHandler *handlers.SubscriptionHandler) *gin.Engine {
+func setupRouter(cfg *config.Config, logger *zap.Logger, authService *services.AuthService, sessionService *services.SessionService, idempotency gin.HandlerFunc, authHandler *handlers.AuthHandler, fileHandler *handlers.FileHandler, duplicateHandler *handlers.DuplicateHandler, duplicateAdvancedHandler *handlers.DuplicateAdvancedHandler, subscriptionHandler *handlers.SubscriptionHandler, syncHandler *handlers.SyncHandler, uploadSessionHandler *handlers.UploadSessionHandler, deviceHandler *handlers.DeviceHandler, cleanupHandler *handlers.CleanupHandler, reportHandler *handlers.ReportHandler, trashHandler *handlers.TrashHandler, actionHandler *handlers.ActionHandler, safetyHandler *handlers.SafetyHandler, policyHandler *handlers.PolicyHandler, scanHandler *handlers.ScanHandler, notificationHandler *handlers.NotificationHandler, digestHandler *handlers.DigestHandler) *gin.Engine {
 	if cfg.Environment == "production" {
 		gin.SetMode(gin.ReleaseMode)
 	}
@@ -113,16 +165,24 @@ func setupRouter(cfg *config.Config, logger *zap.Logger, authService *services.A
 	// API routes
 	api := router.Group("/api/v1")
 
-	// Auth routes (no auth required)
+	requireAuth := middleware.AuthMiddleware(authService, sessionService)
+
+	// Auth routes (no auth required, except to log out)
 	auth := api.Group("/auth")
 	{
 		auth.POST("/login", authHandler.Login)
-		auth.POST("/logout", authHandler.Logout)
+		auth.POST("/refresh", authHandler.Refresh)
+		auth.POST("/logout", requireAuth, authHandler.Logout)
 	}
 
+	// Digest unsubscribe links (no auth required; the token identifies the user).
+	// GET only shows a confirmation page; POST unsubscribes.
+	api.GET("/digest/unsubscribe", digestHandler.UnsubscribePage)
+	api.POST("/digest/unsubscribe", digestHandler.Unsubscribe)
+
 	// Protected routes
 	protected := api.Group("/")
-	protected.Use(middleware.AuthMiddleware(authService))
+	protected.Use(requireAuth)
 	{
 		// User profile
 		protected.GET("/profile", authHandler.Profile)
@@ -130,17 +190,45 @@ func setupRouter(cfg *config.Config, logger *zap.Logger, authService *services.A
 		// File operations
 		files := protected.Group("/files")
 		{
-			files.POST("/metadata", fileHandler.UploadMetadata)
+			files.POST("/metadata", idempotency, fileHandler.UploadMetadata)
 			files.GET("/", fileHandler.GetFiles)
 			files.GET("/stats", fileHandler.GetStats)
+			files.GET("/stats/categories", fileHandler.GetStorageBreakdown)
+			files.GET("/search", fileHandler.SearchFiles)
+			files.GET("/tree", fileHandler.GetFolderTree)
+			files.GET("/:id/history", fileHandler.GetFileHistory)
+			files.POST("/:id/move", idempotency, actionHandler.MoveFile)
+			files.POST("/sync", syncHandler.SyncManifest)
+			files.GET("/sync/:device_id", syncHandler.GetSyncState)
+
+			// Resumable manifest uploads
+			files.POST("/sessions", uploadSessionHandler.OpenSession)
+			files.GET("/sessions/:session_id", uploadSessionHandler.GetSession)
+			files.PUT("/sessions/:session_id/chunks/:index", uploadSessionHandler.PutChunk)
+			files.POST("/sessions/:session_id/commit", uploadSessionHandler.CommitSession)
+			files.DELETE("/sessions/:session_id", uploadSessionHandler.AbortSession)
 		}
 
+		// Device registry
+		devices := protected.Group("/devices")
+		{
+			devices.GET("/", deviceHandler.ListDevices)
+			devices.POST("/", deviceHandler.RegisterDevice)
+			devices.PATCH("/:device_id", deviceHandler.RenameDevice)
+			devices.DELETE("/:device_id", idempotency, deviceHandler.RemoveDevice)
+			devices.GET("/:device_id/actions", actionHandler.PollActions)
+			devices.POST("/:device_id/actions/ack", idempotency, actionHandler.AcknowledgeActions)
+		}
+
+		// Storage history
+		protected.GET("/stats/history", fileHandler.GetStatsHistory)
+
 		// Duplicate operations
 		duplicates := protected.Group("/duplicates")
 		{
 			duplicates.GET("/groups", duplicateHandler.GetDuplicateGroups)
 			duplicates.GET("/groups/:sha256/files", duplicateHandler.GetDuplicateGroupFiles)
-			duplicates.DELETE("/files", duplicateHandler.DeleteDuplicateFiles)
+			duplicates.DELETE("/files", idempotency, duplicateHandler.DeleteDuplicateFiles)
 			duplicates.GET("/analyze", duplicateHandler.AnalyzeDuplicates)
 			
 			// Advanced duplicate detection
@@ -149,13 +237,87 @@ func setupRouter(cfg *config.Config, logger *zap.Logger, authService *services.A
 			duplicates.GET("/compare-strategies", duplicateAdvancedHandler.CompareDuplicateStrategies)
 		}
 
+		// Cleanup plans
+		plans := protected.Group("/plans")
+		{
+			plans.POST("/", cleanupHandler.CreatePlan)
+			plans.GET("/", cleanupHandler.ListPlans)
+			plans.GET("/:plan_id", cleanupHandler.GetPlan)
+			plans.POST("/:plan_id/commit", idempotency, cleanupHandler.CommitPlan)
+			plans.POST("/:plan_id/results", idempotency, cleanupHandler.ReportResults)
+			plans.DELETE("/:plan_id", cleanupHandler.DeletePlan)
+		}
+
+		// Trash
+		trash := protected.Group("/trash")
+		{
+			trash.GET("/", trashHandler.ListTrash)
+			trash.POST("/restore", idempotency, trashHandler.RestoreFiles)
+			trash.DELETE("/", trashHandler.EmptyTrash)
+		}
+
+		// Cleanup reports
+		reports := protected.Group("/reports")
+		{
+			reports.GET("/", reportHandler.ListReports)
+			reports.GET("/summary", reportHandler.GetSavingsSummary)
+			reports.GET("/export", reportHandler.ExportReport)
+			reports.GET("/:id", reportHandler.GetReport)
+		}
+
+		// Cleanup policies
+		policies := protected.Group("/policies")
+		{
+			policies.GET("/", policyHandler.ListPolicies)
+			policies.POST("/", policyHandler.CreatePolicy)
+			policies.POST("/dry-run", policyHandler.DryRunDraft)
+			policies.GET("/:policy_id", policyHandler.GetPolicy)
+			policies.PUT("/:policy_id", policyHandler.UpdatePolicy)
+			policies.DELETE("/:policy_id", policyHandler.DeletePolicy)
+			policies.POST("/:policy_id/dry-run", policyHandler.DryRun)
+			policies.POST("/:policy_id/run", idempotency, policyHandler.RunPolicy)
+		}
+
+		// Scheduled scans
+		scans := protected.Group("/scans")
+		{
+			scans.GET("/schedule", scanHandler.GetSchedule)
+			scans.PUT("/schedule", scanHandler.UpdateSchedule)
+			scans.DELETE("/schedule", scanHandler.DeleteSchedule)
+			scans.GET("/runs", scanHandler.ListRuns)
+		}
+
+		// Cleanup safety settings
+		safety := protected.Group("/settings/safety")
+		{
+			safety.GET("/", safetyHandler.GetSettings)
+			safety.PUT("/", safetyHandler.UpdateSettings)
+			safety.GET("/overrides", safetyHandler.ListOverrides)
+		}
+
+		// Notifications
+		protected.GET("/notifications", notificationHandler.ListNotifications)
+		notificationSettings := protected.Group("/settings/notifications")
+		{
+			notificationSettings.GET("/", notificationHandler.GetSettings)
+			notificationSettings.PUT("/", notificationHandler.UpdateSettings)
+		}
+
+		// Weekly digest
+		protected.GET("/digest/preview", digestHandler.Preview)
+		digest := protected.Group("/settings/digest")
+		{
+			digest.GET("/", digestHandler.GetSettings)
+			digest.PUT("/", digestHandler.UpdateSettings)
+		}
+
 		// Large files
 		protected.GET("/large-files", duplicateHandler.GetLargeFiles)
 		
 		// Subscription operations
 		subscriptions := protected.Group("/subscriptions")
 		{
-			subscriptions.POST("/verify", subscriptionHandler.VerifyPurchase)
+			subscriptions.POST("/verify", idempotency, subscriptionHandler.VerifyPurchase)
 			subscriptions.GET("/status", subscriptionHandler.GetSubscription)
 			subscriptions.DELETE("/cancel", subscriptionHandler.CancelSubscription)
 		}
@@ -163,3 +325,190 @@ func setupRouter(cfg *config.Config, logger *zap.Logger, authService *services.A
 
 	return router
 }
+
+// runTrashPurge permanently deletes files whose trash retention has expired, once per interval
+func runTrashPurge(ctx context.Context, trashService *services.TrashService, interval time.Duration, logger *zap.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := trashService.PurgeExpired(ctx)
+			if err != nil {
+				logger.Error("Failed to purge trash", zap.Error(err))
+				continue
+			}
+			if purged > 0 {
+				logger.Info("Purged expired trash", zap.Int64("files", purged))
+			}
+		}
+	}
+}
+
+// runCleanupPolicies runs the cleanup policies that are due, once per interval
+func runCleanupPolicies(ctx context.Context, policyService *services.PolicyService, interval time.Duration, logger *zap.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ran, err := policyService.RunDue(ctx)
+			if err != nil {
+				logger.Error("Failed to run cleanup policies", zap.Error(err))
+				continue
+			}
+			if ran > 0 {
+				logger.Info("Ran cleanup policies", zap.Int("policies", ran))
+			}
+		}
+	}
+}
+
+// runScanScheduler requests due scans once per interval while this replica holds the
+// scheduler lease, and gives the lease up on shutdown
+func runScanScheduler(ctx context.Context, scanService *services.ScanService, lease *services.Lease, interval time.Duration, logger *zap.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := lease.Release(context.Background()); err != nil {
+				logger.Error("Failed to release scan scheduler lease", zap.Error(err))
+			}
+			return
+		case <-ticker.C:
+			held, err := lease.Acquire(ctx)
+			if err != nil {
+				logger.Error("Failed to acquire scan scheduler lease", zap.Error(err))
+				continue
+			}
+			if !held {
+				continue
+			}
+
+			fired, err := scanService.RunDue(ctx)
+			if err != nil {
+				logger.Error("Failed to request scheduled scans", zap.Error(err))
+				continue
+			}
+			if fired > 0 {
+				logger.Info("Requested scheduled scans", zap.Int("schedules", fired))
+			}
+		}
+	}
+}
+
+// runSnapshotRecorder records the storage snapshots of users whose files changed, once per interval
+func runSnapshotRecorder(ctx context.Context, fileService *services.FileService, interval time.Duration, logger *zap.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			recorded, err := fileService.RecordPendingSnapshots(ctx)
+			if err != nil {
+				logger.Error("Failed to record storage snapshots", zap.Error(err))
+			}
+			if recorded > 0 {
+				logger.Info("Recorded storage snapshots", zap.Int("users", recorded))
+			}
+		}
+	}
+}
+
+// runRefreshTokenPurge deletes expired refresh tokens, once per interval
+func runRefreshTokenPurge(ctx context.Context, sessionService *services.SessionService, interval time.Duration, logger *zap.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := sessionService.PurgeExpired(ctx)
+			if err != nil {
+				logger.Error("Failed to purge refresh tokens", zap.Error(err))
+				continue
+			}
+			if purged > 0 {
+				logger.Info("Purged expired refresh tokens", zap.Int64("tokens", purged))
+			}
+		}
+	}
+}
+
+// runNotificationDispatcher sends due notifications once per interval and, once an hour,
+// queues warnings for subscriptions about to expire
+func runNotificationDispatcher(ctx context.Context, notificationService *services.NotificationService, interval time.Duration, logger *zap.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	expiryTicker := time.NewTicker(time.Hour)
+	defer expiryTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-expiryTicker.C:
+			if _, err := notificationService.EnqueueExpiringSubscriptions(ctx, time.Now()); err != nil {
+				logger.Error("Failed to queue subscription expiry notifications", zap.Error(err))
+			}
+		case <-ticker.C:
+			sent, err := notificationService.Dispatch(ctx)
+			if err != nil {
+				logger.Error("Failed to dispatch notifications", zap.Error(err))
+				continue
+			}
+			if sent > 0 {
+				logger.Info("Dispatched notifications", zap.Int("notifications", sent))
+			}
+		}
+	}
+}
+
+// runDigestSender emails the weekly digests that are due, once per interval
+func runDigestSender(ctx context.Context, digestService *services.DigestService, interval time.Duration, logger *zap.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			sent, err := digestService.SendDue(ctx)
+			if err != nil {
+				logger.Error("Failed to send digests", zap.Error(err))
+				continue
+			}
+			if sent > 0 {
+				logger.Info("Sent weekly digests", zap.Int("digests", sent))
+			}
+		}
+	}
+}
+
+// newNotifier builds the push transport selected by NOTIFIER
+func newNotifier(cfg *config.Config, logger *zap.Logger) (services.Notifier, error) {
+	switch cfg.Notifier {
+	case "fcm":
+		return services.NewFCMNotifier(cfg.FCMCredentialsFile)
+	case "file":
+		return services.NewFileNotifier(cfg.NotifierFile), nil
+	case "log", "":
+		return services.NewLogNotifier(logger), nil
+	default:
+		return nil, fmt.Errorf("unknown notifier %q", cfg.Notifier)
+	}
+}
@@ -1,15 +1,87 @@
 package db
 
 import (
+	"fmt"
+
 	"github.com/purespace/backend/internal/models"
 	"gorm.io/gorm"
 )
 
 func autoMigrate(db *gorm.DB) error {
-	return db.AutoMigrate(
+	if err := dedupeFiles(db); err != nil {
+		return err
+	}
+
+	hadDevices := db.Migrator().HasTable(&models.Device{})
+
+	err := db.AutoMigrate(
 		&models.User{},
+		&models.RefreshToken{},
 		&models.File{},
+		&models.CleanupPlan{},
+		&models.CleanupPlanItem{},
 		&models.Report{},
 		&models.Subscription{},
+		&models.DeviceSyncState{},
+		&models.UploadSession{},
+		&models.UploadChunk{},
+		&models.FileEvent{},
+		&models.StorageSnapshot{},
+		&models.Device{},
+		&models.DeviceAction{},
+		&models.SafetySettings{},
+		&models.SafetyOverride{},
+		&models.CleanupPolicy{},
+		&models.ScanSchedule{},
+		&models.ScanRun{},
+		&models.Notification{},
+		&models.NotificationSettings{},
+		&models.DigestSettings{},
 	)
+	if err != nil {
+		return err
+	}
+
+	if !hadDevices {
+		return backfillDevices(db)
+	}
+	return nil
+}
+
+// backfillDevices registers every device that already has files, so the free-tier
+// device limit does not lock users out of devices they synced before the registry existed
+func backfillDevices(db *gorm.DB) error {
+	err := db.Exec(`
+		INSERT INTO devices (user_id, device_id, name, created_at, updated_at)
+		SELECT DISTINCT user_id, device_id, device_id, NOW(), NOW()
+		FROM files
+		ON CONFLICT DO NOTHING
+	`).Error
+	if err != nil {
+		return fmt.Errorf("failed to backfill devices: %w", err)
+	}
+
+	return nil
+}
+
+// dedupeFiles keeps only the newest row per (user_id, device_id, path_tail)
+// so the natural-key unique index can be created on existing data
+func dedupeFiles(db *gorm.DB) error {
+	if !db.Migrator().HasTable(&models.File{}) || db.Migrator().HasIndex(&models.File{}, "idx_files_natural_key") {
+		return nil
+	}
+
+	err := db.Exec(`
+		DELETE FROM files a
+		USING files b
+		WHERE a.user_id = b.user_id
+			AND a.device_id = b.device_id
+			AND a.path_tail = b.path_tail
+			AND a.id < b.id
+	`).Error
+	if err != nil {
+		return fmt.Errorf("failed to dedupe files: %w", err)
+	}
+
+	return nil
 }
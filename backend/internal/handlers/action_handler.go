@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/purespace/backend/internal/services"
+)
+
+type ActionHandler struct {
+	actionService *services.ActionService
+}
+
+func NewActionHandler(actionService *services.ActionService) *ActionHandler {
+	return &ActionHandler{
+		actionService: actionService,
+	}
+}
+
+// PollActions returns the device's queued actions, waiting up to ?wait= seconds for one
+func (h *ActionHandler) PollActions(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	uid, ok := userID.(uuid.UUID)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var wait time.Duration
+	if raw := c.Query("wait"); raw != "" {
+		seconds, err := strconv.Atoi(raw)
+		if err != nil || seconds < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid wait"})
+			return
+		}
+		wait = time.Duration(seconds) * time.Second
+	}
+
+	actions, err := h.actionService.PollActions(c.Request.Context(), uid, c.Param("device_id"), wait)
+	if errors.Is(err, services.ErrDeviceNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get device actions", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"actions": actions})
+}
+
+// AcknowledgeActions records the outcome of actions the device carried out
+func (h *ActionHandler) AcknowledgeActions(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	uid, ok := userID.(uuid.UUID)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req services.AckRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format", "details": err.Error()})
+		return
+	}
+
+	actions, err := h.actionService.Acknowledge(c.Request.Context(), uid, c.Param("device_id"), &req)
+	if err != nil {
+		c.JSON(actionErrorStatus(err), gin.H{"error": "Failed to acknowledge actions", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"actions": actions})
+}
+
+// MoveFile queues a move of the file on its device
+func (h *ActionHandler) MoveFile(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	uid, ok := userID.(uuid.UUID)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	fileID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file ID"})
+		return
+	}
+
+	var req services.MoveFileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format", "details": err.Error()})
+		return
+	}
+
+	action, err := h.actionService.EnqueueMove(c.Request.Context(), uid, uint(fileID), req.NewPath)
+	if err != nil {
+		c.JSON(actionErrorStatus(err), gin.H{"error": "Failed to queue move", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, action)
+}
+
+func actionErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrActionNotFound), errors.Is(err, services.ErrFileNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrActionPending):
+		return http.StatusConflict
+	case errors.Is(err, services.ErrInvalidMove):
+		return http.StatusBadRequest
+	default:
+		return cleanupErrorStatus(err)
+	}
+}
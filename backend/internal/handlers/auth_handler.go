@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -10,24 +11,35 @@ import (
 )
 
 type AuthHandler struct {
-	db          *gorm.DB
-	authService *services.AuthService
+	db             *gorm.DB
+	authService    *services.AuthService
+	sessionService *services.SessionService
 }
 
-func NewAuthHandler(db *gorm.DB, authService *services.AuthService) *AuthHandler {
+func NewAuthHandler(db *gorm.DB, authService *services.AuthService, sessionService *services.SessionService) *AuthHandler {
 	return &AuthHandler{
-		db:          db,
-		authService: authService,
+		db:             db,
+		authService:    authService,
+		sessionService: sessionService,
 	}
 }
 
 type LoginRequest struct {
 	IDToken string `json:"id_token" binding:"required"`
+	Nonce   string `json:"nonce"` // The nonce passed to Google Sign-In, if any
 }
 
 type LoginResponse struct {
-	Token string      `json:"token"`
-	User  models.User `json:"user"`
+	*services.TokenPair
+	User models.User `json:"user"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
 }
 
 // Login handles Google OAuth login
@@ -39,7 +51,7 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	}
 
 	// Verify Google token
-	tokenInfo, err := h.authService.VerifyGoogleToken(c.Request.Context(), req.IDToken)
+	tokenInfo, err := h.authService.VerifyGoogleToken(c.Request.Context(), req.IDToken, req.Nonce)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid Google token"})
 		return
@@ -65,19 +77,40 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
-	// Generate JWT
-	token, err := h.authService.GenerateJWT(user.ID, user.Email)
+	// Start a session
+	pair, err := h.sessionService.Issue(c.Request.Context(), user.ID, user.Email)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
 	c.JSON(http.StatusOK, LoginResponse{
-		Token: token,
-		User:  user,
+		TokenPair: pair,
+		User:      user,
 	})
 }
 
+// Refresh exchanges a refresh token for a new access and refresh token
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format", "details": err.Error()})
+		return
+	}
+
+	pair, err := h.sessionService.Refresh(c.Request.Context(), req.RefreshToken)
+	if errors.Is(err, services.ErrInvalidRefreshToken) || errors.Is(err, services.ErrRefreshTokenReused) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token", "details": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, pair)
+}
+
 // Profile returns the current user's profile
 func (h *AuthHandler) Profile(c *gin.Context) {
 	userID, exists := c.Get("user_id")
@@ -95,10 +128,38 @@ func (h *AuthHandler) Profile(c *gin.Context) {
 	c.JSON(http.StatusOK, user)
 }
 
-// Logout handles user logout (token invalidation would be handled client-side)
+// Logout revokes the access token and, if given, the session's refresh tokens
 func (h *AuthHandler) Logout(c *gin.Context) {
-	// In a stateless JWT system, logout is typically handled client-side
-	// by removing the token. For enhanced security, you could maintain
-	// a blacklist of tokens in Redis.
+	value, exists := c.Get("token_claims")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	claims, ok := value.(*services.Claims)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid token claims"})
+		return
+	}
+
+	// The body is optional; without a refresh token only the access token is revoked
+	var req LogoutRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format", "details": err.Error()})
+			return
+		}
+	}
+
+	err := h.sessionService.Logout(c.Request.Context(), claims, req.RefreshToken)
+	if errors.Is(err, services.ErrInvalidRefreshToken) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out", "details": err.Error()})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
 }
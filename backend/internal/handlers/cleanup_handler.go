@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/purespace/backend/internal/services"
+)
+
+type CleanupHandler struct {
+	cleanupService *services.CleanupService
+}
+
+func NewCleanupHandler(cleanupService *services.CleanupService) *CleanupHandler {
+	return &CleanupHandler{
+		cleanupService: cleanupService,
+	}
+}
+
+// CreatePlan builds a draft cleanup plan from duplicate clusters or file IDs
+func (h *CleanupHandler) CreatePlan(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	uid, ok := userID.(uuid.UUID)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req services.CreatePlanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format", "details": err.Error()})
+		return
+	}
+
+	plan, err := h.cleanupService.CreatePlan(c.Request.Context(), uid, &req)
+	if err != nil {
+		c.JSON(cleanupErrorStatus(err), cleanupErrorBody("Failed to create cleanup plan", err))
+		return
+	}
+
+	c.JSON(http.StatusCreated, plan)
+}
+
+// ListPlans returns the user's cleanup plans
+func (h *CleanupHandler) ListPlans(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	uid, ok := userID.(uuid.UUID)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	plans, err := h.cleanupService.ListPlans(c.Request.Context(), uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list cleanup plans", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"plans": plans})
+}
+
+// GetPlan returns a plan with its items for review
+func (h *CleanupHandler) GetPlan(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	uid, ok := userID.(uuid.UUID)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	planID, err := uuid.Parse(c.Param("plan_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid plan ID"})
+		return
+	}
+
+	plan, err := h.cleanupService.GetPlan(c.Request.Context(), uid, planID)
+	if err != nil {
+		c.JSON(cleanupErrorStatus(err), gin.H{"error": "Failed to get cleanup plan", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, plan)
+}
+
+// CommitPlan approves a draft plan for execution on the device
+func (h *CleanupHandler) CommitPlan(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	uid, ok := userID.(uuid.UUID)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	planID, err := uuid.Parse(c.Param("plan_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid plan ID"})
+		return
+	}
+
+	plan, err := h.cleanupService.CommitPlan(c.Request.Context(), uid, planID)
+	if err != nil {
+		c.JSON(cleanupErrorStatus(err), cleanupErrorBody("Failed to commit cleanup plan", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, plan)
+}
+
+// ReportResults records the per-file outcome of a committed plan
+func (h *CleanupHandler) ReportResults(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	uid, ok := userID.(uuid.UUID)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	planID, err := uuid.Parse(c.Param("plan_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid plan ID"})
+		return
+	}
+
+	var req services.PlanResultsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format", "details": err.Error()})
+		return
+	}
+
+	plan, err := h.cleanupService.ReportResults(c.Request.Context(), uid, planID, &req)
+	if err != nil {
+		c.JSON(cleanupErrorStatus(err), gin.H{"error": "Failed to record cleanup results", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, plan)
+}
+
+// DeletePlan discards a draft plan
+func (h *CleanupHandler) DeletePlan(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	uid, ok := userID.(uuid.UUID)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	planID, err := uuid.Parse(c.Param("plan_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid plan ID"})
+		return
+	}
+
+	if err := h.cleanupService.DeletePlan(c.Request.Context(), uid, planID); err != nil {
+		c.JSON(cleanupErrorStatus(err), gin.H{"error": "Failed to delete cleanup plan", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Cleanup plan deleted"})
+}
+
+func cleanupErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrPlanNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrPlanState):
+		return http.StatusConflict
+	case errors.Is(err, services.ErrEmptyPlan), errors.Is(err, services.ErrClusterNotFound),
+		errors.Is(err, services.ErrFileNotFound), errors.Is(err, services.ErrPlanItemNotFound):
+		return http.StatusBadRequest
+	case errors.Is(err, services.ErrSafetyViolation):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// cleanupErrorBody lists the offending files when a cleanup breaks the safety rules
+func cleanupErrorBody(message string, err error) gin.H {
+	body := gin.H{"error": message, "details": err.Error()}
+
+	var safetyErr *services.SafetyError
+	if errors.As(err, &safetyErr) {
+		body["violations"] = safetyErr.Violations
+	}
+
+	return body
+}
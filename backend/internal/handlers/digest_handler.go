@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/purespace/backend/internal/services"
+)
+
+type DigestHandler struct {
+	digestService *services.DigestService
+}
+
+func NewDigestHandler(digestService *services.DigestService) *DigestHandler {
+	return &DigestHandler{
+		digestService: digestService,
+	}
+}
+
+// GetSettings returns the user's weekly digest settings
+func (h *DigestHandler) GetSettings(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	uid, ok := userID.(uuid.UUID)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	settings, err := h.digestService.GetSettings(c.Request.Context(), uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get digest settings", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
+
+// UpdateSettings turns the weekly digest on or off
+func (h *DigestHandler) UpdateSettings(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	uid, ok := userID.(uuid.UUID)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req services.DigestSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format", "details": err.Error()})
+		return
+	}
+
+	settings, err := h.digestService.UpdateSettings(c.Request.Context(), uid, &req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update digest settings", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
+
+// Preview returns the digest the user would receive now
+func (h *DigestHandler) Preview(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	uid, ok := userID.(uuid.UUID)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	digest, err := h.digestService.Preview(c.Request.Context(), uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build digest", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, digest)
+}
+
+// unsubscribePage asks the user to confirm. Mail scanners and link previews follow
+// GET links, so only the POST from its form (or a one-click client) unsubscribes.
+var unsubscribePage = template.Must(template.New("unsubscribe").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Unsubscribe from the PureSpace digest</title></head>
+<body style="font-family: sans-serif;">
+  <p>Stop receiving the weekly PureSpace digest?</p>
+  <form method="post" action="?token={{.}}">
+    <button type="submit">Unsubscribe</button>
+  </form>
+</body>
+</html>
+`))
+
+// UnsubscribePage renders the confirmation page the link in a digest email opens
+func (h *DigestHandler) UnsubscribePage(c *gin.Context) {
+	var page bytes.Buffer
+	if err := unsubscribePage.Execute(&page, c.Query("token")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render page", "details": err.Error()})
+		return
+	}
+
+	c.Data(http.StatusOK, "text/html; charset=utf-8", page.Bytes())
+}
+
+// Unsubscribe turns the digest off with the token from a digest email. It needs no
+// session, and answers the confirmation form and RFC 8058 one-click POSTs.
+func (h *DigestHandler) Unsubscribe(c *gin.Context) {
+	err := h.digestService.Unsubscribe(c.Request.Context(), c.Query("token"))
+	if errors.Is(err, services.ErrUnsubscribeTokenNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown unsubscribe link"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unsubscribe", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Unsubscribed from the weekly digest"})
+}
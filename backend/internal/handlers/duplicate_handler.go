@@ -11,11 +11,13 @@ import (
 
 type DuplicateHandler struct {
 	duplicateService *services.DuplicateService
+	cleanupService   *services.CleanupService
 }
 
-func NewDuplicateHandler(duplicateService *services.DuplicateService) *DuplicateHandler {
+func NewDuplicateHandler(duplicateService *services.DuplicateService, cleanupService *services.CleanupService) *DuplicateHandler {
 	return &DuplicateHandler{
 		duplicateService: duplicateService,
+		cleanupService:   cleanupService,
 	}
 }
 
@@ -91,10 +93,13 @@ func (h *DuplicateHandler) GetDuplicateGroupFiles(c *gin.Context) {
 }
 
 type DeleteDuplicatesRequest struct {
-	FileIDs []uint `json:"file_ids" binding:"required"`
+	FileIDs        []uint `json:"file_ids" binding:"required"`
+	Override       bool   `json:"override"`
+	OverrideReason string `json:"override_reason" binding:"required_if=Override true"`
 }
 
-// DeleteDuplicateFiles deletes specified duplicate files
+// DeleteDuplicateFiles queues the deletion of specified duplicate files on their devices.
+// The files are removed once the devices acknowledge; the returned plan tracks progress.
 func (h *DuplicateHandler) DeleteDuplicateFiles(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
@@ -114,12 +119,17 @@ func (h *DuplicateHandler) DeleteDuplicateFiles(c *gin.Context) {
 		return
 	}
 
-	if err := h.duplicateService.DeleteDuplicateFiles(c.Request.Context(), uid, req.FileIDs); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete files", "details": err.Error()})
+	plan, err := h.cleanupService.DeleteFiles(c.Request.Context(), uid, &services.CreatePlanRequest{
+		FileIDs:        req.FileIDs,
+		Override:       req.Override,
+		OverrideReason: req.OverrideReason,
+	})
+	if err != nil {
+		c.JSON(cleanupErrorStatus(err), cleanupErrorBody("Failed to delete files", err))
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Files deleted successfully"})
+	c.JSON(http.StatusAccepted, gin.H{"message": "Deletion queued on the devices", "plan": plan})
 }
 
 // GetLargeFiles returns large files above a size threshold
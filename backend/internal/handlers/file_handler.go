@@ -1,8 +1,10 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -10,12 +12,14 @@ import (
 )
 
 type FileHandler struct {
-	fileService *services.FileService
+	fileService      *services.FileService
+	maxManifestBytes int64
 }
 
-func NewFileHandler(fileService *services.FileService) *FileHandler {
+func NewFileHandler(fileService *services.FileService, maxManifestBytes int64) *FileHandler {
 	return &FileHandler{
-		fileService: fileService,
+		fileService:      fileService,
+		maxManifestBytes: maxManifestBytes,
 	}
 }
 
@@ -34,17 +38,35 @@ func (h *FileHandler) UploadMetadata(c *gin.Context) {
 	}
 
 	var req services.UploadMetadataRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format", "details": err.Error()})
+	if err := bindManifest(c, h.maxManifestBytes, &req); err != nil {
+		c.JSON(manifestErrorStatus(err), gin.H{"error": "Invalid request format", "details": err.Error()})
 		return
 	}
 
-	if err := h.fileService.UploadMetadata(c.Request.Context(), uid, &req); err != nil {
+	if c.Query("strict") == "true" {
+		req.Strict = true
+	}
+
+	result, err := h.fileService.UploadMetadata(c.Request.Context(), uid, &req)
+	if errors.Is(err, services.ErrValidationFailed) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Metadata rejected", "details": err.Error(), "result": result})
+		return
+	}
+	if errors.Is(err, services.ErrDeviceLimitReached) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Device limit reached, upgrade to sync more devices", "details": err.Error()})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload metadata", "details": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Metadata uploaded successfully"})
+	message := "Metadata uploaded successfully"
+	if result.Rejected > 0 {
+		message = "Metadata uploaded with rejected files"
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": message, "result": result})
 }
 
 // GetFiles returns all files for the authenticated user
@@ -92,3 +114,176 @@ func (h *FileHandler) GetStats(c *gin.Context) {
 
 	c.JSON(http.StatusOK, stats)
 }
+
+// GetFileHistory returns a file's moves, content changes and first/last-seen times
+func (h *FileHandler) GetFileHistory(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	uid, ok := userID.(uuid.UUID)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	fileID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file ID"})
+		return
+	}
+
+	history, err := h.fileService.GetFileHistory(c.Request.Context(), uid, uint(fileID))
+	if errors.Is(err, services.ErrFileNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get file history", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, history)
+}
+
+// SearchFiles runs a search query such as "size>100MB mime:video/* older:1y" over the user's files
+func (h *FileHandler) SearchFiles(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	uid, ok := userID.(uuid.UUID)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	page, _ := strconv.Atoi(c.Query("page"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+
+	result, err := h.fileService.SearchFiles(c.Request.Context(), uid, c.Query("q"), page, pageSize)
+	if errors.Is(err, services.ErrInvalidSearchQuery) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid search query", "details": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search files", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// GetFolderTree returns the folder tree below ?path= with byte totals per folder
+func (h *FileHandler) GetFolderTree(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	uid, ok := userID.(uuid.UUID)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	depth, _ := strconv.Atoi(c.Query("depth"))
+
+	tree, err := h.fileService.GetFolderTree(c.Request.Context(), uid, c.Query("path"), c.Query("device_id"), depth)
+	if errors.Is(err, services.ErrInvalidFolderPath) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid path", "details": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get folder tree", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, tree)
+}
+
+// GetStorageBreakdown returns storage by media category, combined and per device
+func (h *FileHandler) GetStorageBreakdown(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	uid, ok := userID.(uuid.UUID)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	breakdown, err := h.fileService.GetStorageBreakdown(c.Request.Context(), uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get storage breakdown", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, breakdown)
+}
+
+// GetStatsHistory returns daily storage snapshots as a time series
+func (h *FileHandler) GetStatsHistory(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	uid, ok := userID.(uuid.UUID)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	// Defaults to the last 30 days
+	to := time.Now()
+	if toStr := c.Query("to"); toStr != "" {
+		t, err := time.Parse("2006-01-02", toStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to date, expected YYYY-MM-DD"})
+			return
+		}
+		to = t
+	}
+	from := to.AddDate(0, 0, -30)
+	if fromStr := c.Query("from"); fromStr != "" {
+		t, err := time.Parse("2006-01-02", fromStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from date, expected YYYY-MM-DD"})
+			return
+		}
+		from = t
+	}
+	if from.After(to) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must not be after to"})
+		return
+	}
+
+	granularity := c.DefaultQuery("granularity", services.GranularityDay)
+	deviceID := c.Query("device_id")
+
+	points, err := h.fileService.GetStatsHistory(c.Request.Context(), uid, deviceID, from, to, granularity)
+	if errors.Is(err, services.ErrInvalidGranularity) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get stats history", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"device_id":   deviceID,
+		"granularity": granularity,
+		"points":      points,
+	})
+}
@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/klauspost/compress/zstd"
+	"github.com/purespace/backend/internal/services"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+var (
+	errManifestTooLarge     = errors.New("manifest exceeds the maximum decompressed size")
+	errUnsupportedEncoding  = errors.New("unsupported content encoding")
+	errUnsupportedMediaType = errors.New("unsupported content type")
+)
+
+// compactManifest is the MessagePack manifest encoding. Paths are split into a
+// shared directory table and a file name, and hashes are sent as raw 32-byte values.
+type compactManifest struct {
+	DeviceID       string        `msgpack:"d"`
+	BaseGeneration int64         `msgpack:"g,omitempty"`
+	Full           bool          `msgpack:"full,omitempty"`
+	Digest         []byte        `msgpack:"dg,omitempty"`
+	Dirs           []string      `msgpack:"p"`
+	Files          []compactFile `msgpack:"f"` // Files for uploads, adds for syncs
+	Updates        []compactFile `msgpack:"u,omitempty"`
+	Removes        []compactPath `msgpack:"r,omitempty"`
+}
+
+type compactPath struct {
+	Dir  int    `msgpack:"p"`
+	Name string `msgpack:"n"`
+}
+
+type compactFile struct {
+	compactPath
+	Hash        []byte `msgpack:"h"`
+	Size        int64  `msgpack:"s"`
+	Mime        string `msgpack:"m,omitempty"`
+	ModifiedAt  *int64 `msgpack:"mt,omitempty"` // Unix milliseconds
+	CapturedAt  *int64 `msgpack:"ct,omitempty"` // Unix milliseconds
+	CameraModel string `msgpack:"cm,omitempty"`
+	HasGPS      *bool  `msgpack:"gps,omitempty"`
+	Width       *int   `msgpack:"w,omitempty"`
+	Height      *int   `msgpack:"ht,omitempty"`
+	DurationMs  *int64 `msgpack:"du,omitempty"`
+	Bitrate     *int64 `msgpack:"br,omitempty"`
+	Orientation *int   `msgpack:"o,omitempty"`
+}
+
+// bindManifest decodes a manifest request body into obj. It accepts JSON or the compact
+// MessagePack encoding, optionally compressed with gzip or zstd, and refuses bodies
+// that decompress to more than maxBytes.
+func bindManifest(c *gin.Context, maxBytes int64, obj interface{}) error {
+	body, err := readManifestBody(c, maxBytes)
+	if err != nil {
+		return err
+	}
+
+	contentType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	switch contentType {
+	case "", "application/json":
+		if err := json.Unmarshal(body, obj); err != nil {
+			return err
+		}
+	case "application/msgpack", "application/x-msgpack", "application/vnd.msgpack":
+		var compact compactManifest
+		if err := msgpack.Unmarshal(body, &compact); err != nil {
+			return err
+		}
+		if err := compact.decodeInto(obj); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("%w: %s", errUnsupportedMediaType, contentType)
+	}
+
+	return binding.Validator.ValidateStruct(obj)
+}
+
+func readManifestBody(c *gin.Context, maxBytes int64) ([]byte, error) {
+	var reader io.Reader = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes)
+
+	switch strings.ToLower(strings.TrimSpace(c.GetHeader("Content-Encoding"))) {
+	case "", "identity":
+	case "gzip":
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		reader = gz
+	case "zstd":
+		zr, err := zstd.NewReader(reader, zstd.WithDecoderMaxMemory(uint64(maxBytes)))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		reader = zr
+	default:
+		return nil, errUnsupportedEncoding
+	}
+
+	body, err := io.ReadAll(io.LimitReader(reader, maxBytes+1))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return nil, errManifestTooLarge
+		}
+		return nil, err
+	}
+	if int64(len(body)) > maxBytes {
+		return nil, errManifestTooLarge
+	}
+
+	return body, nil
+}
+
+// manifestErrorStatus maps a bindManifest error to an HTTP status code
+func manifestErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errManifestTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, errUnsupportedEncoding), errors.Is(err, errUnsupportedMediaType):
+		return http.StatusUnsupportedMediaType
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+func (m *compactManifest) decodeInto(obj interface{}) error {
+	switch req := obj.(type) {
+	case *services.UploadMetadataRequest:
+		files, err := m.fileItems(m.Files)
+		if err != nil {
+			return err
+		}
+		req.DeviceID = m.DeviceID
+		req.Files = files
+	case *services.UploadChunkRequest:
+		files, err := m.fileItems(m.Files)
+		if err != nil {
+			return err
+		}
+		req.Files = files
+	case *services.SyncRequest:
+		adds, err := m.fileItems(m.Files)
+		if err != nil {
+			return err
+		}
+		updates, err := m.fileItems(m.Updates)
+		if err != nil {
+			return err
+		}
+		removes := make([]string, 0, len(m.Removes))
+		for _, p := range m.Removes {
+			path, err := m.path(p)
+			if err != nil {
+				return err
+			}
+			removes = append(removes, path)
+		}
+		req.DeviceID = m.DeviceID
+		req.BaseGeneration = m.BaseGeneration
+		req.Full = m.Full
+		req.Adds = adds
+		req.Updates = updates
+		req.Removes = removes
+		if len(m.Digest) > 0 {
+			req.ManifestDigest = hex.EncodeToString(m.Digest)
+		}
+	default:
+		return fmt.Errorf("%w: no compact encoding for %T", errUnsupportedMediaType, obj)
+	}
+
+	return nil
+}
+
+func (m *compactManifest) path(p compactPath) (string, error) {
+	if p.Dir < 0 || p.Dir >= len(m.Dirs) {
+		return "", fmt.Errorf("directory index %d out of range", p.Dir)
+	}
+
+	var buf bytes.Buffer
+	if dir := m.Dirs[p.Dir]; dir != "" {
+		buf.WriteString(strings.TrimSuffix(dir, "/"))
+		buf.WriteByte('/')
+	}
+	buf.WriteString(p.Name)
+	return buf.String(), nil
+}
+
+func (m *compactManifest) fileItems(files []compactFile) ([]services.FileItem, error) {
+	items := make([]services.FileItem, 0, len(files))
+	for _, f := range files {
+		path, err := m.path(f.compactPath)
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, services.FileItem{
+			SHA256:      hex.EncodeToString(f.Hash),
+			Size:        f.Size,
+			Mime:        f.Mime,
+			PathTail:    path,
+			ModifiedAt:  unixMilli(f.ModifiedAt),
+			CapturedAt:  unixMilli(f.CapturedAt),
+			CameraModel: f.CameraModel,
+			HasGPS:      f.HasGPS,
+			Width:       f.Width,
+			Height:      f.Height,
+			DurationMs:  f.DurationMs,
+			Bitrate:     f.Bitrate,
+			Orientation: f.Orientation,
+		})
+	}
+	return items, nil
+}
+
+func unixMilli(ms *int64) *time.Time {
+	if ms == nil {
+		return nil
+	}
+	t := time.UnixMilli(*ms).UTC()
+	return &t
+}
@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/purespace/backend/internal/services"
+)
+
+type PolicyHandler struct {
+	policyService *services.PolicyService
+}
+
+func NewPolicyHandler(policyService *services.PolicyService) *PolicyHandler {
+	return &PolicyHandler{
+		policyService: policyService,
+	}
+}
+
+// ListPolicies returns the user's cleanup policies
+func (h *PolicyHandler) ListPolicies(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	uid, ok := userID.(uuid.UUID)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	policies, err := h.policyService.ListPolicies(c.Request.Context(), uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list cleanup policies", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"policies": policies})
+}
+
+// CreatePolicy saves a new cleanup policy
+func (h *PolicyHandler) CreatePolicy(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	uid, ok := userID.(uuid.UUID)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req services.PolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format", "details": err.Error()})
+		return
+	}
+
+	policy, err := h.policyService.CreatePolicy(c.Request.Context(), uid, &req)
+	if err != nil {
+		c.JSON(policyErrorStatus(err), gin.H{"error": "Failed to create cleanup policy", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, policy)
+}
+
+// DryRunDraft reports what an unsaved policy would remove
+func (h *PolicyHandler) DryRunDraft(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	uid, ok := userID.(uuid.UUID)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req services.PolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format", "details": err.Error()})
+		return
+	}
+
+	report, err := h.policyService.DryRunDraft(c.Request.Context(), uid, &req)
+	if err != nil {
+		c.JSON(policyErrorStatus(err), gin.H{"error": "Failed to evaluate cleanup policy", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// GetPolicy returns a cleanup policy with the outcome of its last run
+func (h *PolicyHandler) GetPolicy(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	uid, ok := userID.(uuid.UUID)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	policyID, err := uuid.Parse(c.Param("policy_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid policy ID"})
+		return
+	}
+
+	policy, err := h.policyService.GetPolicy(c.Request.Context(), uid, policyID)
+	if err != nil {
+		c.JSON(policyErrorStatus(err), gin.H{"error": "Failed to get cleanup policy", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, policy)
+}
+
+// UpdatePolicy replaces a cleanup policy's rule and schedule
+func (h *PolicyHandler) UpdatePolicy(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	uid, ok := userID.(uuid.UUID)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	policyID, err := uuid.Parse(c.Param("policy_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid policy ID"})
+		return
+	}
+
+	var req services.PolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format", "details": err.Error()})
+		return
+	}
+
+	policy, err := h.policyService.UpdatePolicy(c.Request.Context(), uid, policyID, &req)
+	if err != nil {
+		c.JSON(policyErrorStatus(err), gin.H{"error": "Failed to update cleanup policy", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, policy)
+}
+
+// DeletePolicy removes a cleanup policy
+func (h *PolicyHandler) DeletePolicy(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	uid, ok := userID.(uuid.UUID)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	policyID, err := uuid.Parse(c.Param("policy_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid policy ID"})
+		return
+	}
+
+	if err := h.policyService.DeletePolicy(c.Request.Context(), uid, policyID); err != nil {
+		c.JSON(policyErrorStatus(err), gin.H{"error": "Failed to delete cleanup policy", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Cleanup policy deleted"})
+}
+
+// DryRun reports what a saved policy would remove if it ran now
+func (h *PolicyHandler) DryRun(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	uid, ok := userID.(uuid.UUID)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	policyID, err := uuid.Parse(c.Param("policy_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid policy ID"})
+		return
+	}
+
+	report, err := h.policyService.DryRun(c.Request.Context(), uid, policyID)
+	if err != nil {
+		c.JSON(policyErrorStatus(err), gin.H{"error": "Failed to evaluate cleanup policy", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// RunPolicy runs a policy now and returns its report and plan
+func (h *PolicyHandler) RunPolicy(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	uid, ok := userID.(uuid.UUID)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	policyID, err := uuid.Parse(c.Param("policy_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid policy ID"})
+		return
+	}
+
+	run, err := h.policyService.RunPolicy(c.Request.Context(), uid, policyID)
+	if err != nil {
+		c.JSON(policyErrorStatus(err), cleanupErrorBody("Failed to run cleanup policy", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, run)
+}
+
+func policyErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrPolicyNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrInvalidPolicy):
+		return http.StatusBadRequest
+	case errors.Is(err, services.ErrPremiumRequired):
+		return http.StatusForbidden
+	default:
+		return cleanupErrorStatus(err)
+	}
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/purespace/backend/internal/services"
+)
+
+type ReportHandler struct {
+	reportService *services.ReportService
+	exportService *services.ExportService
+}
+
+func NewReportHandler(reportService *services.ReportService, exportService *services.ExportService) *ReportHandler {
+	return &ReportHandler{
+		reportService: reportService,
+		exportService: exportService,
+	}
+}
+
+// ListReports returns the user's cleanup reports, newest first
+func (h *ReportHandler) ListReports(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	uid, ok := userID.(uuid.UUID)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	limit := 0
+	if raw := c.Query("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		limit = parsed
+	}
+
+	reports, err := h.reportService.ListReports(c.Request.Context(), uid, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list reports", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"reports": reports})
+}
+
+// GetReport returns a report with the files it deleted
+func (h *ReportHandler) GetReport(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	uid, ok := userID.(uuid.UUID)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	reportID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid report ID"})
+		return
+	}
+
+	report, err := h.reportService.GetReport(c.Request.Context(), uid, uint(reportID))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrReportNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": "Failed to get report", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// GetSavingsSummary returns lifetime savings across all reports
+func (h *ReportHandler) GetSavingsSummary(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	uid, ok := userID.(uuid.UUID)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	summary, err := h.reportService.GetSavingsSummary(c.Request.Context(), uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get savings summary", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, summary)
+}
+
+// ExportReport streams duplicates, large files or cleanup history as CSV, JSON or PDF
+func (h *ReportHandler) ExportReport(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	uid, ok := userID.(uuid.UUID)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	req := services.ExportRequest{
+		Format: c.DefaultQuery("format", services.ExportCSV),
+		Scope:  c.Query("scope"),
+	}
+	if raw := c.Query("min_size"); raw != "" {
+		minSize, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || minSize <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid min_size"})
+			return
+		}
+		req.MinSize = minSize
+	}
+
+	table, err := h.exportService.BuildExport(c.Request.Context(), uid, &req)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, services.ErrInvalidExport):
+			status = http.StatusBadRequest
+		case errors.Is(err, services.ErrPremiumRequired):
+			status = http.StatusForbidden
+		}
+		c.JSON(status, gin.H{"error": "Failed to export report", "details": err.Error()})
+		return
+	}
+
+	c.Header("Content-Type", req.ContentType())
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, req.Filename(table.GeneratedAt)))
+	c.Status(http.StatusOK)
+
+	// Once the body has started a write error can no longer change the status, so it is only recorded
+	if err := services.WriteExport(c.Writer, req.Format, table); err != nil {
+		_ = c.Error(err)
+	}
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/purespace/backend/internal/services"
+)
+
+type ScanHandler struct {
+	scanService *services.ScanService
+}
+
+func NewScanHandler(scanService *services.ScanService) *ScanHandler {
+	return &ScanHandler{
+		scanService: scanService,
+	}
+}
+
+// GetSchedule returns the user's automatic scan schedule
+func (h *ScanHandler) GetSchedule(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	uid, ok := userID.(uuid.UUID)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	schedule, err := h.scanService.GetSchedule(c.Request.Context(), uid)
+	if err != nil {
+		c.JSON(scanErrorStatus(err), gin.H{"error": "Failed to get scan schedule", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, schedule)
+}
+
+// UpdateSchedule sets the user's automatic scan schedule
+func (h *ScanHandler) UpdateSchedule(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	uid, ok := userID.(uuid.UUID)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req services.ScanScheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format", "details": err.Error()})
+		return
+	}
+
+	schedule, err := h.scanService.UpdateSchedule(c.Request.Context(), uid, &req)
+	if err != nil {
+		c.JSON(scanErrorStatus(err), gin.H{"error": "Failed to update scan schedule", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, schedule)
+}
+
+// DeleteSchedule turns automatic scans off
+func (h *ScanHandler) DeleteSchedule(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	uid, ok := userID.(uuid.UUID)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if err := h.scanService.DeleteSchedule(c.Request.Context(), uid); err != nil {
+		c.JSON(scanErrorStatus(err), gin.H{"error": "Failed to delete scan schedule", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Scan schedule deleted"})
+}
+
+// ListRuns returns the scans requested from the user's devices, newest first
+func (h *ScanHandler) ListRuns(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	uid, ok := userID.(uuid.UUID)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.Query("limit"))
+
+	runs, err := h.scanService.ListRuns(c.Request.Context(), uid, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list scans", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"runs": runs})
+}
+
+func scanErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrScanScheduleNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrInvalidScanSchedule):
+		return http.StatusBadRequest
+	case errors.Is(err, services.ErrPremiumRequired):
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/purespace/backend/internal/services"
+)
+
+type SyncHandler struct {
+	syncService      *services.SyncService
+	maxManifestBytes int64
+}
+
+func NewSyncHandler(syncService *services.SyncService, maxManifestBytes int64) *SyncHandler {
+	return &SyncHandler{
+		syncService:      syncService,
+		maxManifestBytes: maxManifestBytes,
+	}
+}
+
+// SyncManifest applies a device manifest delta since the last acknowledged generation
+func (h *SyncHandler) SyncManifest(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	uid, ok := userID.(uuid.UUID)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req services.SyncRequest
+	if err := bindManifest(c, h.maxManifestBytes, &req); err != nil {
+		c.JSON(manifestErrorStatus(err), gin.H{"error": "Invalid request format", "details": err.Error()})
+		return
+	}
+
+	result, err := h.syncService.Sync(c.Request.Context(), uid, &req)
+	if errors.Is(err, services.ErrResyncRequired) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":           "Base generation is stale, full resync required",
+			"resync_required": true,
+			"generation":      result.Generation,
+			"manifest_digest": result.ManifestDigest,
+		})
+		return
+	}
+	if errors.Is(err, services.ErrDeviceLimitReached) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Device limit reached, upgrade to sync more devices", "details": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sync manifest", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// GetSyncState returns the generation and manifest digest the server holds for a device
+func (h *SyncHandler) GetSyncState(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	uid, ok := userID.(uuid.UUID)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	deviceID := c.Param("device_id")
+	if deviceID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Device ID is required"})
+		return
+	}
+
+	state, err := h.syncService.GetSyncState(c.Request.Context(), uid, deviceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get sync state", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, state)
+}
@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/purespace/backend/internal/services"
+)
+
+type UploadSessionHandler struct {
+	uploadSessionService *services.UploadSessionService
+	maxManifestBytes     int64
+}
+
+func NewUploadSessionHandler(uploadSessionService *services.UploadSessionService, maxManifestBytes int64) *UploadSessionHandler {
+	return &UploadSessionHandler{
+		uploadSessionService: uploadSessionService,
+		maxManifestBytes:     maxManifestBytes,
+	}
+}
+
+// OpenSession starts a resumable manifest upload
+func (h *UploadSessionHandler) OpenSession(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	uid, ok := userID.(uuid.UUID)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req services.OpenUploadSessionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format", "details": err.Error()})
+		return
+	}
+
+	session, err := h.uploadSessionService.Open(c.Request.Context(), uid, &req)
+	if err != nil {
+		c.JSON(uploadSessionErrorStatus(err), gin.H{"error": "Failed to open upload session", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, session)
+}
+
+// PutChunk stores one numbered chunk of a session
+func (h *UploadSessionHandler) PutChunk(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	uid, ok := userID.(uuid.UUID)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	index, err := strconv.Atoi(c.Param("index"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chunk index"})
+		return
+	}
+
+	var req services.UploadChunkRequest
+	if err := bindManifest(c, h.maxManifestBytes, &req); err != nil {
+		c.JSON(manifestErrorStatus(err), gin.H{"error": "Invalid request format", "details": err.Error()})
+		return
+	}
+
+	if err := h.uploadSessionService.PutChunk(c.Request.Context(), uid, sessionID, index, &req); err != nil {
+		c.JSON(uploadSessionErrorStatus(err), gin.H{"error": "Failed to store chunk", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"index": index, "item_count": len(req.Files)})
+}
+
+// GetSession reports which chunks have been received
+func (h *UploadSessionHandler) GetSession(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	uid, ok := userID.(uuid.UUID)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	status, err := h.uploadSessionService.Status(c.Request.Context(), uid, sessionID)
+	if err != nil {
+		c.JSON(uploadSessionErrorStatus(err), gin.H{"error": "Failed to get upload session", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+// CommitSession applies all chunks of a session atomically
+func (h *UploadSessionHandler) CommitSession(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	uid, ok := userID.(uuid.UUID)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	result, err := h.uploadSessionService.Commit(c.Request.Context(), uid, sessionID)
+	if err != nil {
+		c.JSON(uploadSessionErrorStatus(err), gin.H{"error": "Failed to commit upload session", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// AbortSession discards an open session
+func (h *UploadSessionHandler) AbortSession(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	uid, ok := userID.(uuid.UUID)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	if err := h.uploadSessionService.Abort(c.Request.Context(), uid, sessionID); err != nil {
+		c.JSON(uploadSessionErrorStatus(err), gin.H{"error": "Failed to abort upload session", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Upload session aborted"})
+}
+
+func uploadSessionErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrUploadSessionNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrUploadSessionClosed), errors.Is(err, services.ErrUploadSessionIncomplete):
+		return http.StatusConflict
+	case errors.Is(err, services.ErrChunkOutOfRange), errors.Is(err, services.ErrInvalidUploadSession):
+		return http.StatusBadRequest
+	case errors.Is(err, services.ErrDeviceLimitReached):
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
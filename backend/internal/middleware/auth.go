@@ -7,7 +7,7 @@ import (
 	"github.com/purespace/backend/internal/services"
 )
 
-func AuthMiddleware(authService *services.AuthService) gin.HandlerFunc {
+func AuthMiddleware(authService *services.AuthService, sessionService *services.SessionService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -24,15 +24,29 @@ func AuthMiddleware(authService *services.AuthService) gin.HandlerFunc {
 		}
 
 		claims, err := authService.ValidateJWT(token)
-		if err != nil {
+		if err != nil || claims.ID == "" {
+			// Tokens without a jti cannot be revoked and are no longer issued
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
 			c.Abort()
 			return
 		}
 
+		revoked, err := sessionService.IsRevoked(c.Request.Context(), claims.ID)
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to check token", "details": err.Error()})
+			c.Abort()
+			return
+		}
+		if revoked {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
+			c.Abort()
+			return
+		}
+
 		// Set user information in context
 		c.Set("user_id", claims.UserID)
 		c.Set("user_email", claims.Email)
+		c.Set("token_claims", claims)
 
 		c.Next()
 	}
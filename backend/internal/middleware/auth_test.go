@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/purespace/backend/internal/services"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthMiddleware_RejectsRevokedToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	authService := services.NewAuthService("test-secret-key", 15*time.Minute, nil)
+	// Logout without a refresh token only touches the denylist, so no database is needed
+	sessionService := services.NewSessionService(nil, rdb, authService, time.Hour)
+
+	router := gin.New()
+	router.GET("/me", AuthMiddleware(authService, sessionService), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	get := func(token string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/me", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	token, err := authService.GenerateJWT(uuid.New(), "test@example.com")
+	require.NoError(t, err)
+	other, err := authService.GenerateJWT(uuid.New(), "other@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, get(token))
+
+	claims, err := authService.ValidateJWT(token)
+	require.NoError(t, err)
+	require.NoError(t, sessionService.Logout(context.Background(), claims, ""))
+
+	// The token is still signed and unexpired, but its jti is on the denylist
+	assert.Equal(t, http.StatusUnauthorized, get(token))
+	assert.Equal(t, http.StatusOK, get(other))
+}
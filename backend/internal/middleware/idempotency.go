@@ -0,0 +1,211 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	idempotencyHeader    = "Idempotency-Key"
+	maxIdempotencyKeyLen = 255
+	// How long a key stays locked if its first request stops renewing the lock, e.g. because
+	// the process died. A running request renews it every third of this.
+	idempotencyLockTTL = time.Minute
+)
+
+type idempotencyRecord struct {
+	Fingerprint string `json:"fingerprint"`
+	Completed   bool   `json:"completed"`
+	Status      int    `json:"status,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Body        []byte `json:"body,omitempty"`
+}
+
+type bodyCaptureWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bodyCaptureWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *bodyCaptureWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// IdempotencyMiddleware makes a route safe to retry. The first request carrying an
+// Idempotency-Key runs normally and its response is stored for ttl; retries with the
+// same key and body get the stored response, and a reused key with a different body
+// is rejected. Bodies larger than maxBodyBytes are rejected before they are buffered.
+// Must run after AuthMiddleware, since keys are scoped per user.
+func IdempotencyMiddleware(redisClient *redis.Client, ttl time.Duration, maxBodyBytes int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(idempotencyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+
+		if len(key) > maxIdempotencyKeyLen {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key is too long"})
+			c.Abort()
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxBodyBytes))
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body is too large"})
+			} else {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+			}
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		ctx := c.Request.Context()
+		userID, _ := c.Get("user_id")
+		cacheKey := fmt.Sprintf("idempotency:%v:%s", userID, key)
+		fingerprint := requestFingerprint(c.Request, body)
+
+		lock, _ := json.Marshal(idempotencyRecord{Fingerprint: fingerprint})
+		acquired, err := redisClient.SetNX(ctx, cacheKey, lock, idempotencyLockTTL).Result()
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Idempotency store unavailable"})
+			c.Abort()
+			return
+		}
+
+		if !acquired {
+			replayIdempotentResponse(c, redisClient, cacheKey, fingerprint)
+			return
+		}
+
+		writer := &bodyCaptureWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+
+		// The request context is cancelled when the client gives up, which is exactly when
+		// its retry needs the response stored, so the lock and the record outlive it
+		storeCtx := context.WithoutCancel(ctx)
+
+		stopRenewing := renewIdempotencyLock(storeCtx, redisClient, cacheKey)
+		c.Next()
+		stopRenewing()
+
+		// Server errors are not stored so the client can retry them
+		if writer.Status() >= http.StatusInternalServerError {
+			redisClient.Del(storeCtx, cacheKey)
+			return
+		}
+
+		record, err := json.Marshal(idempotencyRecord{
+			Fingerprint: fingerprint,
+			Completed:   true,
+			Status:      writer.Status(),
+			ContentType: writer.Header().Get("Content-Type"),
+			Body:        writer.body.Bytes(),
+		})
+		if err != nil {
+			redisClient.Del(storeCtx, cacheKey)
+			return
+		}
+
+		redisClient.Set(storeCtx, cacheKey, record, ttl)
+	}
+}
+
+func replayIdempotentResponse(c *gin.Context, redisClient *redis.Client, cacheKey, fingerprint string) {
+	stored, err := redisClient.Get(c.Request.Context(), cacheKey).Bytes()
+	if err == redis.Nil {
+		// The first request failed and released the key between our calls
+		c.JSON(http.StatusConflict, gin.H{"error": "Request with this Idempotency-Key was not completed, retry"})
+		c.Abort()
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Idempotency store unavailable"})
+		c.Abort()
+		return
+	}
+
+	var record idempotencyRecord
+	if err := json.Unmarshal(stored, &record); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Corrupt idempotency record"})
+		c.Abort()
+		return
+	}
+
+	switch {
+	case record.Fingerprint != fingerprint:
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key was already used with a different request"})
+	case !record.Completed:
+		c.JSON(http.StatusConflict, gin.H{"error": "Request with this Idempotency-Key is still in progress"})
+	default:
+		c.Header("Idempotent-Replayed", "true")
+		c.Data(record.Status, record.ContentType, record.Body)
+	}
+	c.Abort()
+}
+
+// renewIdempotencyLock keeps the key locked while its request runs, however long the
+// handler takes. The returned function stops the renewal and waits for it to finish, so
+// a late renewal cannot shorten the TTL of the stored response.
+func renewIdempotencyLock(ctx context.Context, redisClient *redis.Client, cacheKey string) func() {
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(idempotencyLockTTL / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				redisClient.Expire(ctx, cacheKey, idempotencyLockTTL)
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+// requestFingerprint identifies a request by everything that affects how its body is
+// read, so the same bytes sent with another encoding or media type are not a replay
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	for _, part := range []string{
+		r.Method,
+		r.URL.RequestURI(),
+		r.Header.Get("Content-Type"),
+		r.Header.Get("Content-Encoding"),
+	} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotencyMiddleware_StoresResponseAfterClientTimeout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+
+	runs := 0
+	var clientGaveUp context.CancelFunc
+	router := gin.New()
+	router.POST("/files/delete", IdempotencyMiddleware(rdb, time.Hour, 1<<20), func(c *gin.Context) {
+		runs++
+		if clientGaveUp != nil {
+			// The client times out while the mutation runs, but the mutation still completes
+			clientGaveUp()
+		}
+		c.JSON(http.StatusOK, gin.H{"deleted": runs})
+	})
+
+	send := func(ctx context.Context) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/files/delete", strings.NewReader(`{"file_ids":[1]}`)).WithContext(ctx)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(idempotencyHeader, "delete-1")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	clientGaveUp = cancel
+	send(ctx)
+	clientGaveUp = nil
+
+	retry := send(context.Background())
+	assert.Equal(t, 1, runs)
+	assert.Equal(t, http.StatusOK, retry.Code)
+	assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+	assert.JSONEq(t, `{"deleted":1}`, retry.Body.String())
+}
@@ -1,6 +1,9 @@
 package models
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
@@ -16,31 +19,514 @@ type User struct {
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
+// RefreshToken is an opaque refresh token, stored as its SHA-256 hash. Each refresh rotates
+// it: the token is marked rotated and a new one joins its family. Presenting a rotated token
+// again means it leaked, and the whole family is revoked.
+type RefreshToken struct {
+	ID              uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserID          uuid.UUID  `json:"user_id" gorm:"type:uuid;not null;index"`
+	FamilyID        uuid.UUID  `json:"family_id" gorm:"type:uuid;not null;index"` // Shared by all rotations of one login
+	TokenHash       string     `json:"-" gorm:"type:char(64);not null;uniqueIndex"`
+	AccessTokenID   string     `json:"-" gorm:"not null"` // jti of the access token issued with it
+	AccessExpiresAt time.Time  `json:"-" gorm:"not null"`
+	ExpiresAt       time.Time  `json:"expires_at" gorm:"not null;index"`
+	RotatedAt       *time.Time `json:"rotated_at,omitempty"`
+	RevokedAt       *time.Time `json:"revoked_at,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+
+	// Relationships
+	User User `json:"-" gorm:"foreignKey:UserID"`
+}
+
 // File represents a file metadata entry
 type File struct {
 	ID       uint      `json:"id" gorm:"primaryKey"`
-	UserID   uuid.UUID `json:"user_id" gorm:"type:uuid;not null;index"`
-	DeviceID string    `json:"device_id" gorm:"not null;index"`
-	PathTail string    `json:"path_tail" gorm:"not null"`
+	UserID   uuid.UUID `json:"user_id" gorm:"type:uuid;not null;index;uniqueIndex:idx_files_natural_key,priority:1"`
+	DeviceID string    `json:"device_id" gorm:"not null;index;uniqueIndex:idx_files_natural_key,priority:2"`
+	PathTail string    `json:"path_tail" gorm:"not null;uniqueIndex:idx_files_natural_key,priority:3"`
 	Mime     string    `json:"mime"`
 	Size     int64     `json:"size" gorm:"not null"`
 	SHA256   string    `json:"sha256" gorm:"type:char(64);not null;index"`
+
+	// Optional media metadata reported by the device
+	ModifiedAt  *time.Time `json:"modified_at,omitempty" gorm:"index"`
+	CapturedAt  *time.Time `json:"captured_at,omitempty" gorm:"index"`
+	CameraModel string     `json:"camera_model,omitempty" gorm:"index"`
+	HasGPS      *bool      `json:"has_gps,omitempty"`
+	Width       *int       `json:"width,omitempty"`
+	Height      *int       `json:"height,omitempty"`
+	DurationMs  *int64     `json:"duration_ms,omitempty"`
+	Bitrate     *int64     `json:"bitrate,omitempty"`     // bits per second
+	Orientation *int       `json:"orientation,omitempty"` // EXIF orientation, 1-8
+
+	FirstSeenAt time.Time `json:"first_seen_at" gorm:"not null;default:CURRENT_TIMESTAMP"`
+	LastSeenAt  time.Time `json:"last_seen_at" gorm:"not null;default:CURRENT_TIMESTAMP"`
 	
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"deleted_at,omitempty" gorm:"index"` // Set while the file is in the trash
+	
+	// Relationships
+	User User `json:"-" gorm:"foreignKey:UserID"`
+}
+
+// FileEvent records a change to a file row: first seen, moved, content changed or removed
+type FileEvent struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	FileID    uint      `json:"file_id" gorm:"not null;index"`
+	UserID    uuid.UUID `json:"user_id" gorm:"type:uuid;not null;index"`
+	DeviceID  string    `json:"device_id" gorm:"not null"`
+	Type      string    `json:"type" gorm:"not null"` // first_seen, moved, content_changed, removed, restored
+	OldPath   string    `json:"old_path,omitempty"`
+	NewPath   string    `json:"new_path,omitempty"`
+	OldSHA256 string    `json:"old_sha256,omitempty" gorm:"size:64"`
+	NewSHA256 string    `json:"new_sha256,omitempty" gorm:"size:64"`
+	OldSize   int64     `json:"old_size,omitempty"`
+	NewSize   int64     `json:"new_size,omitempty"`
+
+	CreatedAt time.Time `json:"created_at" gorm:"index"`
+}
+
+// Device represents a phone or tablet registered to a user. Files reference it by DeviceID.
+type Device struct {
+	UserID       uuid.UUID     `json:"user_id" gorm:"type:uuid;primaryKey"`
+	DeviceID     string        `json:"device_id" gorm:"primaryKey"`
+	Name         string        `json:"name" gorm:"not null"`
+	Manufacturer string        `json:"manufacturer"`
+	Model        string        `json:"model"`
+	OSVersion    string        `json:"os_version"`
+	TotalBytes   int64         `json:"total_bytes" gorm:"not null;default:0"`
+	FreeBytes    int64         `json:"free_bytes" gorm:"not null;default:0"`
+	Volumes      DeviceVolumes `json:"volumes" gorm:"type:jsonb"`
+	PushToken    string        `json:"-"` // FCM registration token; cleared when FCM rejects it
+	LastSyncedAt *time.Time    `json:"last_synced_at"`
+
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
-	
+
+	// Relationships
+	User User `json:"-" gorm:"foreignKey:UserID"`
+}
+
+// DeviceVolume represents one storage volume of a device, such as internal storage or an SD card
+type DeviceVolume struct {
+	ID         string `json:"id"`
+	Label      string `json:"label"`
+	Primary    bool   `json:"primary"`
+	Removable  bool   `json:"removable"`
+	TotalBytes int64  `json:"total_bytes"`
+	FreeBytes  int64  `json:"free_bytes"`
+}
+
+// DeviceVolumes is stored as a JSON column
+type DeviceVolumes []DeviceVolume
+
+// Value implements driver.Valuer
+func (v DeviceVolumes) Value() (driver.Value, error) {
+	if v == nil {
+		return "[]", nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan implements sql.Scanner
+func (v *DeviceVolumes) Scan(value interface{}) error {
+	switch data := value.(type) {
+	case nil:
+		*v = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(data, v)
+	case string:
+		return json.Unmarshal([]byte(data), v)
+	default:
+		return fmt.Errorf("unsupported type for DeviceVolumes: %T", value)
+	}
+}
+
+// StringList is a list of strings stored as a JSON array
+type StringList []string
+
+// Value implements driver.Valuer
+func (l StringList) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+	b, err := json.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan implements sql.Scanner
+func (l *StringList) Scan(value interface{}) error {
+	switch data := value.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(data, l)
+	case string:
+		return json.Unmarshal([]byte(data), l)
+	default:
+		return fmt.Errorf("unsupported type for StringList: %T", value)
+	}
+}
+
+type SafetyViolations []SafetyViolation
+
+// Value implements driver.Valuer
+func (v SafetyViolations) Value() (driver.Value, error) {
+	if v == nil {
+		return "[]", nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan implements sql.Scanner
+func (v *SafetyViolations) Scan(value interface{}) error {
+	switch data := value.(type) {
+	case nil:
+		*v = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(data, v)
+	case string:
+		return json.Unmarshal([]byte(data), v)
+	default:
+		return fmt.Errorf("unsupported type for SafetyViolations: %T", value)
+	}
+}
+
+// StringMap is a string map stored as a JSON object
+type StringMap map[string]string
+
+// Value implements driver.Valuer
+func (m StringMap) Value() (driver.Value, error) {
+	if m == nil {
+		return "{}", nil
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan implements sql.Scanner
+func (m *StringMap) Scan(value interface{}) error {
+	switch data := value.(type) {
+	case nil:
+		*m = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(data, m)
+	case string:
+		return json.Unmarshal([]byte(data), m)
+	default:
+		return fmt.Errorf("unsupported type for StringMap: %T", value)
+	}
+}
+
+// DeviceSyncState tracks the manifest generation acknowledged for a device
+type DeviceSyncState struct {
+	UserID         uuid.UUID  `json:"user_id" gorm:"type:uuid;primaryKey"`
+	DeviceID       string     `json:"device_id" gorm:"primaryKey"`
+	Generation     int64      `json:"generation" gorm:"not null;default:0"`
+	ManifestDigest string     `json:"manifest_digest" gorm:"type:char(64)"`
+	FileCount      int64      `json:"file_count" gorm:"not null;default:0"`
+	LastSyncedAt   *time.Time `json:"last_synced_at"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// Relationships
+	User User `json:"-" gorm:"foreignKey:UserID"`
+}
+
+// StorageSnapshot records a user's storage aggregates for one day. The last sync of the
+// day wins. DeviceID is empty for the totals across all devices.
+type StorageSnapshot struct {
+	UserID         uuid.UUID `json:"user_id" gorm:"type:uuid;primaryKey"`
+	DeviceID       string    `json:"device_id" gorm:"primaryKey"`
+	Day            time.Time `json:"day" gorm:"type:date;primaryKey"`
+	FileCount      int64     `json:"file_count" gorm:"not null;default:0"`
+	TotalBytes     int64     `json:"total_bytes" gorm:"not null;default:0"`
+	DuplicateBytes int64     `json:"duplicate_bytes" gorm:"not null;default:0"`
+	ImageBytes     int64     `json:"image_bytes" gorm:"not null;default:0"`
+	VideoBytes     int64     `json:"video_bytes" gorm:"not null;default:0"`
+	AudioBytes     int64     `json:"audio_bytes" gorm:"not null;default:0"`
+	DocumentBytes  int64     `json:"document_bytes" gorm:"not null;default:0"`
+	ArchiveBytes   int64     `json:"archive_bytes" gorm:"not null;default:0"`
+	APKBytes       int64     `json:"apk_bytes" gorm:"not null;default:0"`
+	OtherBytes     int64     `json:"other_bytes" gorm:"not null;default:0"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// Relationships
+	User User `json:"-" gorm:"foreignKey:UserID"`
+}
+
+// UploadSession stages a large manifest in numbered chunks until it is committed
+type UploadSession struct {
+	ID          uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserID      uuid.UUID  `json:"user_id" gorm:"type:uuid;not null;index"`
+	DeviceID    string     `json:"device_id" gorm:"not null"`
+	Mode        string     `json:"mode" gorm:"not null"`         // replace, merge
+	Status      string     `json:"status" gorm:"not null;index"` // open, committed
+	TotalChunks int        `json:"total_chunks" gorm:"not null"`
+	ExpiresAt   time.Time  `json:"expires_at" gorm:"not null;index"`
+	CommittedAt *time.Time `json:"committed_at,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// Relationships
+	User User `json:"-" gorm:"foreignKey:UserID"`
+}
+
+// UploadChunk holds one numbered chunk of an upload session
+type UploadChunk struct {
+	SessionID  uuid.UUID `json:"session_id" gorm:"type:uuid;primaryKey"`
+	ChunkIndex int       `json:"index" gorm:"primaryKey;autoIncrement:false"`
+	ItemCount  int       `json:"item_count" gorm:"not null"`
+	Payload    []byte    `json:"-" gorm:"type:bytea;not null"` // JSON-encoded file items
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// Relationships
+	Session UploadSession `json:"-" gorm:"foreignKey:SessionID;constraint:OnDelete:CASCADE"`
+}
+
+// CleanupPlan is a reviewed set of files to delete. The server tracks it from draft
+// to completion, so it is the source of truth for what was cleaned.
+type CleanupPlan struct {
+	ID             uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserID         uuid.UUID  `json:"user_id" gorm:"type:uuid;not null;index"`
+	Status         string     `json:"status" gorm:"not null;index"` // draft, committed, executing, completed, partially_failed
+	ItemCount      int        `json:"item_count" gorm:"not null;default:0"`
+	ProjectedBytes int64      `json:"projected_bytes" gorm:"not null;default:0"`
+	ReportID       *uint      `json:"report_id,omitempty"`
+	Override       bool       `json:"override"` // Allowed to break the safety rules
+	OverrideReason string     `json:"override_reason,omitempty"`
+	PolicyID       *uuid.UUID `json:"policy_id,omitempty" gorm:"type:uuid;index"` // Set when a cleanup policy produced the plan
+	CommittedAt    *time.Time `json:"committed_at,omitempty"`
+	CompletedAt    *time.Time `json:"completed_at,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// Relationships
+	User  User              `json:"-" gorm:"foreignKey:UserID"`
+	Items []CleanupPlanItem `json:"items,omitempty" gorm:"foreignKey:PlanID;constraint:OnDelete:CASCADE"`
+}
+
+// CleanupPlanItem is one file of a cleanup plan, copied when the plan was created
+type CleanupPlanItem struct {
+	ID       uint      `json:"id" gorm:"primaryKey"`
+	PlanID   uuid.UUID `json:"plan_id" gorm:"type:uuid;not null;index"`
+	FileID   uint      `json:"file_id" gorm:"not null;index"`
+	DeviceID string    `json:"device_id" gorm:"not null"`
+	PathTail string    `json:"path_tail" gorm:"not null"`
+	SHA256   string    `json:"sha256" gorm:"type:char(64);not null"`
+	Size     int64     `json:"size" gorm:"not null"`
+	Mime     string    `json:"mime"`
+	Status   string    `json:"status" gorm:"not null"` // pending, deleted, failed, restored
+	Error    string    `json:"error,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SafetySettings holds the safeguards checked before a cleanup deletes files
+type SafetySettings struct {
+	UserID         uuid.UUID  `json:"-" gorm:"type:uuid;primaryKey"`
+	KeepCopyScope  string     `json:"keep_copy_scope" gorm:"not null;default:user"` // user, device
+	ProtectedPaths StringList `json:"protected_paths" gorm:"type:jsonb;not null;default:'[]'"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SafetyViolation describes files a cleanup may not delete under the safety rules
+type SafetyViolation struct {
+	Rule     string `json:"rule"` // last_copy, protected_path
+	SHA256   string `json:"sha256,omitempty"`
+	DeviceID string `json:"device_id,omitempty"` // Set for last_copy with the device scope
+	Pattern  string `json:"pattern,omitempty"`   // Set for protected_path
+	FileIDs  []uint `json:"file_ids"`
+}
+
+// SafetyOverride is the audit record of a cleanup committed despite safety violations
+type SafetyOverride struct {
+	ID         uint             `json:"id" gorm:"primaryKey"`
+	UserID     uuid.UUID        `json:"user_id" gorm:"type:uuid;not null;index"`
+	PlanID     uuid.UUID        `json:"plan_id" gorm:"type:uuid;not null;index"`
+	Reason     string           `json:"reason" gorm:"not null"`
+	Violations SafetyViolations `json:"violations" gorm:"type:jsonb;not null"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CleanupPolicy is a saved cleanup rule that is evaluated on a schedule and after syncs.
+// Each run produces a cleanup plan that is committed right away or left as a draft.
+type CleanupPolicy struct {
+	ID            uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserID        uuid.UUID  `json:"user_id" gorm:"type:uuid;not null;index"`
+	Name          string     `json:"name" gorm:"not null"`
+	Kind          string     `json:"kind" gorm:"not null"`     // duplicates, files
+	Query         string     `json:"query" gorm:"not null"`    // File search query selecting the files to remove
+	KeepPath      string     `json:"keep_path,omitempty"`      // duplicates: only remove copies while one under this glob remains
+	AllowLastCopy bool       `json:"allow_last_copy"`          // files: remove last copies under a safety override
+	Approval      string     `json:"approval" gorm:"not null"` // auto, confirm
+	IntervalHours int        `json:"interval_hours" gorm:"not null"`
+	RunAfterSync  bool       `json:"run_after_sync" gorm:"not null"`
+	Enabled       bool       `json:"enabled" gorm:"not null"`
+	NextRunAt     time.Time  `json:"next_run_at" gorm:"not null;index"`
+	LastRunAt     *time.Time `json:"last_run_at,omitempty"`
+	LastPlanID    *uuid.UUID `json:"last_plan_id,omitempty" gorm:"type:uuid"`
+	LastError     string     `json:"last_error,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// Relationships
+	User User `json:"-" gorm:"foreignKey:UserID"`
+}
+
+// DeviceAction is a command queued for a device, such as deleting or moving a file.
+// The device pulls it, carries it out and acknowledges it; only then is the file's
+// metadata changed.
+type DeviceAction struct {
+	ID          uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserID      uuid.UUID  `json:"user_id" gorm:"type:uuid;not null;index:idx_device_actions_queue,priority:1"`
+	DeviceID    string     `json:"device_id" gorm:"not null;index:idx_device_actions_queue,priority:2"`
+	Type        string     `json:"type" gorm:"not null"` // delete, move, scan, restore
+	FileID      uint       `json:"file_id" gorm:"not null;index"`
+	PathTail    string     `json:"path_tail" gorm:"not null"`
+	NewPath     string     `json:"new_path,omitempty"`                   // Move only
+	SHA256      string     `json:"sha256" gorm:"type:char(64);not null"` // Lets the device check the content before acting
+	Size        int64      `json:"size" gorm:"not null"`
+	PlanID      *uuid.UUID `json:"plan_id,omitempty" gorm:"type:uuid;index"`                         // Set for deletions that execute a cleanup plan
+	Status      string     `json:"status" gorm:"not null;index:idx_device_actions_queue,priority:3"` // pending, delivered, succeeded, failed, cancelled
+	Error       string     `json:"error,omitempty"`
+	Attempts    int        `json:"attempts" gorm:"not null;default:0"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+	AckedAt     *time.Time `json:"acked_at,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ScanSchedule is a user's schedule for automatic scans. When a scan is due, a scan
+// action is queued for each of the user's devices.
+type ScanSchedule struct {
+	UserID      uuid.UUID  `json:"user_id" gorm:"type:uuid;primaryKey"`
+	Frequency   string     `json:"frequency" gorm:"not null"` // daily, weekly, monthly, cron
+	TimeOfDay   string     `json:"time_of_day,omitempty"`     // HH:MM, for daily, weekly and monthly
+	Weekday     int        `json:"weekday"`                   // 0 (Sunday) to 6, for weekly
+	DayOfMonth  int        `json:"day_of_month"`              // 1 to 28, for monthly
+	CronExpr    string     `json:"cron_expr,omitempty"`       // Five-field cron expression, for cron
+	Timezone    string     `json:"timezone" gorm:"not null"`  // IANA name, e.g. Europe/Berlin
+	Enabled     bool       `json:"enabled" gorm:"not null"`
+	NextRunAt   time.Time  `json:"next_run_at" gorm:"not null;index"`
+	LastRunAt   *time.Time `json:"last_run_at,omitempty"`
+	MissedScans int        `json:"missed_scans" gorm:"not null;default:0"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
 	// Relationships
 	User User `json:"-" gorm:"foreignKey:UserID"`
 }
 
+// ScanRun is one scan requested from a device. It is missed when the device has not
+// acknowledged it by the time the next scan is due.
+type ScanRun struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	UserID      uuid.UUID  `json:"user_id" gorm:"type:uuid;not null;index:idx_scan_runs_device,priority:1"`
+	DeviceID    string     `json:"device_id" gorm:"not null;index:idx_scan_runs_device,priority:2"`
+	DueAt       time.Time  `json:"due_at" gorm:"not null"`
+	ActionID    uuid.UUID  `json:"action_id" gorm:"type:uuid;not null;uniqueIndex"`
+	Status      string     `json:"status" gorm:"not null"` // requested, completed, failed, missed
+	Error       string     `json:"error,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Notification is an entry of the notification outbox. It is written in the same
+// transaction as the change it reports, and the dispatcher pushes it to the user's devices.
+type Notification struct {
+	ID            uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserID        uuid.UUID  `json:"user_id" gorm:"type:uuid;not null;uniqueIndex:idx_notifications_dedup,priority:1"`
+	Kind          string     `json:"kind" gorm:"not null"`                                             // cleanup_completed, duplicates_found, subscription_expiring
+	DedupKey      string     `json:"-" gorm:"not null;uniqueIndex:idx_notifications_dedup,priority:2"` // A repeat of the same event is dropped
+	Title         string     `json:"title" gorm:"not null"`
+	Body          string     `json:"body" gorm:"not null"`
+	Data          StringMap  `json:"data" gorm:"type:jsonb"`
+	Status        string     `json:"status" gorm:"not null;index:idx_notifications_due,priority:1"` // pending, sent, failed, skipped
+	Attempts      int        `json:"attempts" gorm:"not null;default:0"`
+	NextAttemptAt time.Time  `json:"next_attempt_at" gorm:"not null;index:idx_notifications_due,priority:2"`
+	LastError     string     `json:"last_error,omitempty"`
+	DeliveredTo   StringList `json:"-" gorm:"type:jsonb"` // Device IDs already sent this notification
+	SentAt        *time.Time `json:"sent_at,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// NotificationSettings holds a user's push preferences. During quiet hours, given in the
+// user's timezone, notifications are held until the quiet hours end.
+type NotificationSettings struct {
+	UserID      uuid.UUID `json:"user_id" gorm:"type:uuid;primaryKey"`
+	PushEnabled bool      `json:"push_enabled" gorm:"not null"`
+	QuietStart  string    `json:"quiet_start,omitempty"` // HH:MM
+	QuietEnd    string    `json:"quiet_end,omitempty"`   // HH:MM; before QuietStart for overnight quiet hours
+	Timezone    string    `json:"timezone,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// DigestSettings holds a user's weekly digest email preferences. The unsubscribe token is
+// put in every digest, so the user can opt out without signing in.
+type DigestSettings struct {
+	UserID           uuid.UUID  `json:"user_id" gorm:"type:uuid;primaryKey"`
+	Enabled          bool       `json:"enabled" gorm:"not null"`
+	Weekday          int        `json:"weekday" gorm:"not null"` // 0 = Sunday
+	UnsubscribeToken string     `json:"-" gorm:"not null;uniqueIndex"`
+	NextSendAt       *time.Time `json:"next_send_at,omitempty" gorm:"index"`
+	LastSentAt       *time.Time `json:"last_sent_at,omitempty"`
+	Attempts         int        `json:"-" gorm:"not null;default:0"` // Failed sends of the current digest
+	LastError        string     `json:"last_error,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
 // Report represents a cleanup report
 type Report struct {
-	ID           uint      `json:"id" gorm:"primaryKey"`
-	UserID       uuid.UUID `json:"user_id" gorm:"type:uuid;not null;index"`
-	BytesSaved   int64     `json:"bytes_saved" gorm:"not null"`
-	ItemsDeleted int       `json:"items_deleted" gorm:"not null"`
-	StartedAt    time.Time `json:"started_at" gorm:"not null"`
-	CompletedAt  time.Time `json:"completed_at" gorm:"not null"`
+	ID           uint       `json:"id" gorm:"primaryKey"`
+	UserID       uuid.UUID  `json:"user_id" gorm:"type:uuid;not null;index"`
+	PlanID       *uuid.UUID `json:"plan_id,omitempty" gorm:"type:uuid;index"`
+	BytesSaved   int64      `json:"bytes_saved" gorm:"not null"`
+	ItemsDeleted int        `json:"items_deleted" gorm:"not null"`
+	StartedAt    time.Time  `json:"started_at" gorm:"not null"`
+	CompletedAt  time.Time  `json:"completed_at" gorm:"not null"`
 	
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
@@ -84,3 +570,27 @@ type Stats struct {
 	DuplicateBytes   int64 `json:"duplicate_bytes"`
 	PotentialSavings int64 `json:"potential_savings"`
 }
+
+// CategoryStats represents storage used by one media category
+type CategoryStats struct {
+	Category       string        `json:"category"`
+	Bytes          int64         `json:"bytes"`
+	FileCount      int64         `json:"file_count"`
+	DuplicateBytes int64         `json:"duplicate_bytes"`
+	LargestFiles   []FileSummary `json:"largest_files"`
+}
+
+// FileSummary identifies a file in aggregate views
+type FileSummary struct {
+	ID       uint   `json:"id"`
+	DeviceID string `json:"device_id"`
+	PathTail string `json:"path_tail"`
+	Size     int64  `json:"size"`
+	Mime     string `json:"mime"`
+}
+
+// StorageBreakdown represents storage by media category, combined and per device
+type StorageBreakdown struct {
+	Combined []CategoryStats            `json:"combined"`
+	Devices  map[string][]CategoryStats `json:"devices"`
+}
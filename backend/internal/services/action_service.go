@@ -0,0 +1,435 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/purespace/backend/internal/models"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrActionNotFound = errors.New("device action not found")
+	ErrActionPending  = errors.New("file already has a pending action")
+	ErrInvalidMove    = errors.New("invalid move")
+)
+
+// Device action types
+const (
+	ActionDelete  = "delete"
+	ActionMove    = "move"
+	ActionScan    = "scan"    // Rescan storage and sync; queued by the scan scheduler
+	ActionRestore = "restore" // Put a deleted file back from the device's own trash
+)
+
+// Device action states
+const (
+	ActionPending   = "pending"
+	ActionDelivered = "delivered"
+	ActionSucceeded = "succeeded"
+	ActionFailed    = "failed"
+	ActionCancelled = "cancelled"
+)
+
+const (
+	maxActionBatch        = 100
+	maxActionWait         = 60 * time.Second
+	actionRedeliveryAfter = 10 * time.Minute // Delivered but unacknowledged actions are sent again after this
+	actionRecheckInterval = 5 * time.Second  // Long polls also recheck the queue, in case a notification is missed
+)
+
+// ActionService queues deletions and moves for devices. The server cannot change files
+// on a phone, so it asks the device and updates its metadata only when the device
+// acknowledges the action.
+type ActionService struct {
+	db          *gorm.DB
+	redis       *redis.Client
+	fileService *FileService
+}
+
+func NewActionService(db *gorm.DB, redis *redis.Client, fileService *FileService) *ActionService {
+	return &ActionService{
+		db:          db,
+		redis:       redis,
+		fileService: fileService,
+	}
+}
+
+type MoveFileRequest struct {
+	NewPath string `json:"new_path" binding:"required"`
+}
+
+type ActionAck struct {
+	ActionID uuid.UUID `json:"action_id" binding:"required"`
+	Status   string    `json:"status" binding:"required,oneof=succeeded failed"`
+	Error    string    `json:"error"`
+}
+
+type AckRequest struct {
+	Acks []ActionAck `json:"acks" binding:"required,min=1,dive"`
+}
+
+// EnqueueMove asks the file's device to move it to a new path
+func (s *ActionService) EnqueueMove(ctx context.Context, userID uuid.UUID, fileID uint, newPath string) (*models.DeviceAction, error) {
+	if reason := validatePathTail(newPath); reason != "" {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidMove, reason)
+	}
+
+	var action *models.DeviceAction
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var file models.File
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND user_id = ?", fileID, userID).
+			First(&file).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrFileNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if file.PathTail == newPath {
+			return fmt.Errorf("%w: the file is already at %s", ErrInvalidMove, newPath)
+		}
+
+		var open int64
+		err = tx.Model(&models.DeviceAction{}).
+			Where("file_id = ? AND status IN ?", fileID, []string{ActionPending, ActionDelivered}).
+			Count(&open).Error
+		if err != nil {
+			return err
+		}
+		if open > 0 {
+			return ErrActionPending
+		}
+
+		action = &models.DeviceAction{
+			ID:       uuid.New(),
+			UserID:   userID,
+			DeviceID: file.DeviceID,
+			Type:     ActionMove,
+			FileID:   file.ID,
+			PathTail: file.PathTail,
+			NewPath:  newPath,
+			SHA256:   file.SHA256,
+			Size:     file.Size,
+			Status:   ActionPending,
+		}
+		return tx.Create(action).Error
+	})
+	if err != nil {
+		return nil, actionError("failed to queue move", err)
+	}
+
+	s.notify(ctx, userID, []string{action.DeviceID})
+
+	return action, nil
+}
+
+// PollActions hands the device its queued actions. With a wait it long-polls: when
+// nothing is queued it blocks until an action arrives or the wait expires.
+func (s *ActionService) PollActions(ctx context.Context, userID uuid.UUID, deviceID string, wait time.Duration) ([]models.DeviceAction, error) {
+	var device models.Device
+	err := s.db.WithContext(ctx).Where("user_id = ? AND device_id = ?", userID, deviceID).First(&device).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrDeviceNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get device: %w", err)
+	}
+
+	if wait > maxActionWait {
+		wait = maxActionWait
+	}
+
+	// Subscribe before the first check so an action queued in between is not missed
+	var notifications <-chan *redis.Message
+	if wait > 0 && s.redis != nil {
+		sub := s.redis.Subscribe(ctx, actionChannel(userID, deviceID))
+		defer sub.Close()
+		if _, err := sub.Receive(ctx); err == nil {
+			notifications = sub.Channel()
+		}
+	}
+
+	deadline := time.NewTimer(wait)
+	defer deadline.Stop()
+	recheck := time.NewTicker(actionRecheckInterval)
+	defer recheck.Stop()
+
+	for {
+		actions, err := s.deliverActions(ctx, userID, deviceID)
+		if err != nil || len(actions) > 0 || wait <= 0 {
+			return actions, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return actions, nil
+		case <-deadline.C:
+			return actions, nil
+		case <-notifications:
+		case <-recheck.C:
+		}
+	}
+}
+
+// deliverActions marks the device's next actions as delivered and returns them. Actions
+// delivered long ago without an acknowledgement are delivered again.
+func (s *ActionService) deliverActions(ctx context.Context, userID uuid.UUID, deviceID string) ([]models.DeviceAction, error) {
+	actions := []models.DeviceAction{}
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("user_id = ? AND device_id = ? AND (status = ? OR (status = ? AND delivered_at < ?))",
+				userID, deviceID, ActionPending, ActionDelivered, now.Add(-actionRedeliveryAfter)).
+			Order("created_at ASC, id ASC").
+			Limit(maxActionBatch).
+			Find(&actions).Error
+		if err != nil || len(actions) == 0 {
+			return err
+		}
+
+		ids := make([]uuid.UUID, len(actions))
+		for i := range actions {
+			ids[i] = actions[i].ID
+			actions[i].Status = ActionDelivered
+			actions[i].DeliveredAt = &now
+			actions[i].Attempts++
+		}
+
+		return tx.Model(&models.DeviceAction{}).Where("id IN ?", ids).Updates(map[string]interface{}{
+			"status":       ActionDelivered,
+			"delivered_at": now,
+			"attempts":     gorm.Expr("attempts + 1"),
+		}).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to deliver actions: %w", err)
+	}
+
+	return actions, nil
+}
+
+// Acknowledge records the outcome of actions the device carried out and reconciles the
+// file metadata: acknowledged deletions move files to the trash and advance their cleanup
+// plan, acknowledged moves update the path and acknowledged restores take files out of the
+// trash. Repeated acknowledgements are ignored.
+func (s *ActionService) Acknowledge(ctx context.Context, userID uuid.UUID, deviceID string, req *AckRequest) ([]models.DeviceAction, error) {
+	var actions []models.DeviceAction
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		ids := make([]uuid.UUID, 0, len(req.Acks))
+		for _, ack := range req.Acks {
+			ids = append(ids, ack.ActionID)
+		}
+
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id IN ? AND user_id = ? AND device_id = ?", ids, userID, deviceID).
+			Order("created_at ASC, id ASC").
+			Find(&actions).Error
+		if err != nil {
+			return err
+		}
+
+		byID := make(map[uuid.UUID]*models.DeviceAction, len(actions))
+		for i := range actions {
+			byID[actions[i].ID] = &actions[i]
+		}
+
+		now := time.Now()
+		var planIDs []uuid.UUID
+		planResults := make(map[uuid.UUID][]PlanItemResult)
+
+		for _, ack := range req.Acks {
+			action, ok := byID[ack.ActionID]
+			if !ok {
+				return fmt.Errorf("%w: %s", ErrActionNotFound, ack.ActionID)
+			}
+			if action.Status != ActionPending && action.Status != ActionDelivered {
+				continue
+			}
+
+			action.Status = ack.Status
+			action.Error = ack.Error
+			action.AckedAt = &now
+			err := tx.Model(&models.DeviceAction{}).Where("id = ?", action.ID).Updates(map[string]interface{}{
+				"status":   action.Status,
+				"error":    action.Error,
+				"acked_at": now,
+			}).Error
+			if err != nil {
+				return fmt.Errorf("failed to update action: %w", err)
+			}
+
+			switch {
+			case action.Type == ActionDelete && action.PlanID != nil:
+				result := PlanItemResult{FileID: action.FileID, Status: PlanItemDeleted}
+				if action.Status == ActionFailed {
+					result = PlanItemResult{FileID: action.FileID, Status: PlanItemFailed, Error: action.Error}
+				}
+				if _, seen := planResults[*action.PlanID]; !seen {
+					planIDs = append(planIDs, *action.PlanID)
+				}
+				planResults[*action.PlanID] = append(planResults[*action.PlanID], result)
+			case action.Type == ActionMove && action.Status == ActionSucceeded:
+				if err := applyMove(tx, action); err != nil {
+					return err
+				}
+			case action.Type == ActionRestore && action.Status == ActionSucceeded:
+				if err := applyRestore(tx, action); err != nil {
+					return err
+				}
+			case action.Type == ActionScan:
+				if err := completeScanRun(tx, action, now); err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, planID := range planIDs {
+			plan, err := loadPlan(tx.Clauses(clause.Locking{Strength: "UPDATE"}), userID, planID)
+			if err != nil {
+				return err
+			}
+			if plan.Status != PlanCommitted && plan.Status != PlanExecuting {
+				continue
+			}
+			if err := applyPlanResults(tx, plan, planResults[planID], now); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, actionError("failed to acknowledge actions", err)
+	}
+
+	s.fileService.statsChanged(ctx, userID)
+
+	return actions, nil
+}
+
+// applyMove moves a file's row to the path the device moved it to. A row already at that
+// path is replaced, since the device reports that the moved file is there now.
+func applyMove(tx *gorm.DB, action *models.DeviceAction) error {
+	var file models.File
+	err := tx.Where("id = ? AND user_id = ?", action.FileID, action.UserID).First(&file).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil // Removed since the move was queued; the next sync catches up
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get file: %w", err)
+	}
+
+	var replaced []models.File
+	err = tx.Unscoped().
+		Where("user_id = ? AND device_id = ? AND path_tail = ? AND id <> ?", file.UserID, file.DeviceID, action.NewPath, file.ID).
+		Find(&replaced).Error
+	if err != nil {
+		return fmt.Errorf("failed to check move target: %w", err)
+	}
+
+	events := make([]models.FileEvent, 0, len(replaced)+1)
+	for _, old := range replaced {
+		if err := tx.Unscoped().Delete(&models.File{}, old.ID).Error; err != nil {
+			return fmt.Errorf("failed to replace %s: %w", old.PathTail, err)
+		}
+		events = append(events, removedEvent(old))
+	}
+
+	err = tx.Model(&models.File{}).Where("id = ?", file.ID).Updates(map[string]interface{}{
+		"path_tail":    action.NewPath,
+		"last_seen_at": time.Now(),
+	}).Error
+	if err != nil {
+		return fmt.Errorf("failed to move %s: %w", file.PathTail, err)
+	}
+	events = append(events, movedEvent(file, action.NewPath))
+
+	return recordFileEvents(tx, events)
+}
+
+// planDeleteActions builds one delete action per pending item of a committed plan
+func planDeleteActions(plan *models.CleanupPlan) []models.DeviceAction {
+	var actions []models.DeviceAction
+	for _, item := range plan.Items {
+		if item.Status != PlanItemPending {
+			continue
+		}
+		actions = append(actions, models.DeviceAction{
+			ID:       uuid.New(),
+			UserID:   plan.UserID,
+			DeviceID: item.DeviceID,
+			Type:     ActionDelete,
+			FileID:   item.FileID,
+			PathTail: item.PathTail,
+			SHA256:   item.SHA256,
+			Size:     item.Size,
+			PlanID:   &plan.ID,
+			Status:   ActionPending,
+		})
+	}
+	return actions
+}
+
+func enqueueActions(tx *gorm.DB, actions []models.DeviceAction) error {
+	if len(actions) == 0 {
+		return nil
+	}
+
+	if err := tx.CreateInBatches(&actions, upsertBatchSize).Error; err != nil {
+		return fmt.Errorf("failed to queue device actions: %w", err)
+	}
+
+	return nil
+}
+
+// cancelPlanActions withdraws the open actions for plan items whose outcome was reported
+// another way
+func cancelPlanActions(tx *gorm.DB, planID uuid.UUID, fileIDs []uint) error {
+	err := tx.Model(&models.DeviceAction{}).
+		Where("plan_id = ? AND file_id IN ? AND status IN ?", planID, fileIDs, []string{ActionPending, ActionDelivered}).
+		Update("status", ActionCancelled).Error
+	if err != nil {
+		return fmt.Errorf("failed to cancel device actions: %w", err)
+	}
+
+	return nil
+}
+
+// notify wakes the long polls of the given devices. Best effort: polls recheck the
+// queue on their own.
+func (s *ActionService) notify(ctx context.Context, userID uuid.UUID, deviceIDs []string) {
+	if s.redis == nil {
+		return
+	}
+
+	seen := make(map[string]bool, len(deviceIDs))
+	for _, deviceID := range deviceIDs {
+		if seen[deviceID] {
+			continue
+		}
+		seen[deviceID] = true
+		s.redis.Publish(ctx, actionChannel(userID, deviceID), "1")
+	}
+}
+
+func actionChannel(userID uuid.UUID, deviceID string) string {
+	return fmt.Sprintf("actions:%s:%s", userID, deviceID)
+}
+
+func actionError(message string, err error) error {
+	for _, known := range []error{ErrActionNotFound, ErrActionPending, ErrInvalidMove, ErrFileNotFound} {
+		if errors.Is(err, known) {
+			return err
+		}
+	}
+	return planError(message, err)
+}
@@ -0,0 +1,44 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/purespace/backend/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanDeleteActions(t *testing.T) {
+	plan := &models.CleanupPlan{
+		ID:     uuid.New(),
+		UserID: uuid.New(),
+		Items: []models.CleanupPlanItem{
+			{FileID: 1, DeviceID: "pixel", PathTail: "DCIM/a.jpg", SHA256: "aaa", Size: 100, Status: PlanItemPending},
+			{FileID: 2, DeviceID: "tablet", PathTail: "DCIM/b.jpg", SHA256: "bbb", Size: 200, Status: PlanItemDeleted},
+			{FileID: 3, DeviceID: "tablet", PathTail: "DCIM/c.jpg", SHA256: "ccc", Size: 300, Status: PlanItemPending},
+		},
+	}
+
+	actions := planDeleteActions(plan)
+
+	require.Len(t, actions, 2, "only pending items are queued")
+	for _, action := range actions {
+		assert.NotEqual(t, uuid.Nil, action.ID)
+		assert.Equal(t, plan.UserID, action.UserID)
+		assert.Equal(t, plan.ID, *action.PlanID)
+		assert.Equal(t, ActionDelete, action.Type)
+		assert.Equal(t, ActionPending, action.Status)
+	}
+	assert.Equal(t, "pixel", actions[0].DeviceID)
+	assert.Equal(t, "DCIM/a.jpg", actions[0].PathTail)
+	assert.Equal(t, "aaa", actions[0].SHA256)
+	assert.Equal(t, uint(3), actions[1].FileID)
+	assert.Equal(t, "tablet", actions[1].DeviceID)
+}
+
+func TestActionChannel(t *testing.T) {
+	userID := uuid.MustParse("6f1c7a52-0d7e-4a55-9a3c-2b8e5d1f4c90")
+
+	assert.Equal(t, "actions:6f1c7a52-0d7e-4a55-9a3c-2b8e5d1f4c90:pixel", actionChannel(userID, "pixel"))
+}
@@ -2,9 +2,7 @@ package services
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"net/http"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -12,12 +10,16 @@ import (
 )
 
 type AuthService struct {
-	jwtSecret string
+	jwtSecret      string
+	accessTokenTTL time.Duration
+	google         *GoogleVerifier
 }
 
-func NewAuthService(jwtSecret string) *AuthService {
+func NewAuthService(jwtSecret string, accessTokenTTL time.Duration, google *GoogleVerifier) *AuthService {
 	return &AuthService{
-		jwtSecret: jwtSecret,
+		jwtSecret:      jwtSecret,
+		accessTokenTTL: accessTokenTTL,
+		google:         google,
 	}
 }
 
@@ -35,57 +37,38 @@ type Claims struct {
 	jwt.RegisteredClaims
 }
 
-// VerifyGoogleToken verifies a Google ID token and returns user info
-func (s *AuthService) VerifyGoogleToken(ctx context.Context, idToken string) (*GoogleTokenInfo, error) {
-	// In a real implementation, you would verify the token with Google's public keys
-	// For this example, we'll simulate token verification
-	
-	// This is a simplified implementation - in production, use Google's token verification
-	url := fmt.Sprintf("https://oauth2.googleapis.com/tokeninfo?id_token=%s", idToken)
-	
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-	
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to verify token: %w", err)
-	}
-	defer resp.Body.Close()
-	
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("invalid token")
-	}
-	
-	var tokenInfo GoogleTokenInfo
-	if err := json.NewDecoder(resp.Body).Decode(&tokenInfo); err != nil {
-		return nil, fmt.Errorf("failed to decode token info: %w", err)
-	}
-	
-	if !tokenInfo.EmailVerified {
-		return nil, fmt.Errorf("email not verified")
-	}
-	
-	return &tokenInfo, nil
+// VerifyGoogleToken verifies a Google ID token locally and returns user info. nonce is the
+// value the client passed to Google Sign-In, or empty if it passed none.
+func (s *AuthService) VerifyGoogleToken(ctx context.Context, idToken, nonce string) (*GoogleTokenInfo, error) {
+	return s.google.Verify(ctx, idToken, nonce)
 }
 
-// GenerateJWT generates a JWT token for the user
+// GenerateJWT generates a short-lived access token for the user
 func (s *AuthService) GenerateJWT(userID uuid.UUID, email string) (string, error) {
-	claims := Claims{
+	token, _, err := s.issueAccessToken(userID, email, time.Now())
+	return token, err
+}
+
+// issueAccessToken signs an access token with a unique ID (jti), so it can be revoked
+// before it expires
+func (s *AuthService) issueAccessToken(userID uuid.UUID, email string, now time.Time) (string, *Claims, error) {
+	claims := &Claims{
 		UserID: userID,
 		Email:  email,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			NotBefore: jwt.NewNumericDate(time.Now()),
+			ID:        uuid.NewString(),
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.accessTokenTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
 			Issuer:    "purespace-api",
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(s.jwtSecret))
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(s.jwtSecret))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to sign access token: %w", err)
+	}
+	return token, claims, nil
 }
 
 // ValidateJWT validates a JWT token and returns the claims
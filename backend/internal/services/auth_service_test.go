@@ -11,7 +11,7 @@ import (
 )
 
 func TestAuthService_GenerateJWT(t *testing.T) {
-	authService := NewAuthService("test-secret-key")
+	authService := NewAuthService("test-secret-key", time.Hour, nil)
 	userID := uuid.New()
 	email := "test@example.com"
 
@@ -33,8 +33,28 @@ func TestAuthService_GenerateJWT(t *testing.T) {
 	assert.Equal(t, "purespace-api", claims.Issuer)
 }
 
+func TestAuthService_GenerateJWT_AccessTokenLifetime(t *testing.T) {
+	authService := NewAuthService("test-secret-key", 15*time.Minute, nil)
+	now := time.Now()
+
+	first, claims, err := authService.issueAccessToken(uuid.New(), "test@example.com", now)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(15*time.Minute).Unix(), claims.ExpiresAt.Unix())
+
+	// Every token gets its own jti, so one can be revoked without the others
+	second, other, err := authService.issueAccessToken(claims.UserID, claims.Email, now)
+	require.NoError(t, err)
+	assert.NotEqual(t, first, second)
+	assert.NotEmpty(t, claims.ID)
+	assert.NotEqual(t, claims.ID, other.ID)
+
+	parsed, err := authService.ValidateJWT(first)
+	require.NoError(t, err)
+	assert.Equal(t, claims.ID, parsed.ID)
+}
+
 func TestAuthService_ValidateJWT(t *testing.T) {
-	authService := NewAuthService("test-secret-key")
+	authService := NewAuthService("test-secret-key", time.Hour, nil)
 	userID := uuid.New()
 	email := "test@example.com"
 
@@ -50,14 +70,14 @@ func TestAuthService_ValidateJWT(t *testing.T) {
 }
 
 func TestAuthService_ValidateJWT_InvalidToken(t *testing.T) {
-	authService := NewAuthService("test-secret-key")
+	authService := NewAuthService("test-secret-key", time.Hour, nil)
 
 	// Test with invalid token
 	_, err := authService.ValidateJWT("invalid-token")
 	assert.Error(t, err)
 
 	// Test with token signed with different secret
-	otherAuthService := NewAuthService("different-secret")
+	otherAuthService := NewAuthService("different-secret", time.Hour, nil)
 	userID := uuid.New()
 	email := "test@example.com"
 	
@@ -69,7 +89,7 @@ func TestAuthService_ValidateJWT_InvalidToken(t *testing.T) {
 }
 
 func TestAuthService_ValidateJWT_ExpiredToken(t *testing.T) {
-	authService := NewAuthService("test-secret-key")
+	authService := NewAuthService("test-secret-key", time.Hour, nil)
 	userID := uuid.New()
 	email := "test@example.com"
 
@@ -95,7 +115,7 @@ func TestAuthService_ValidateJWT_ExpiredToken(t *testing.T) {
 }
 
 func TestAuthService_ExtractBearerToken(t *testing.T) {
-	authService := NewAuthService("test-secret-key")
+	authService := NewAuthService("test-secret-key", time.Hour, nil)
 
 	tests := []struct {
 		name           string
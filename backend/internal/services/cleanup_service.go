@@ -0,0 +1,521 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/purespace/backend/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrPlanNotFound     = errors.New("cleanup plan not found")
+	ErrPlanState        = errors.New("cleanup plan is not in a state that allows this")
+	ErrEmptyPlan        = errors.New("cleanup plan has no files")
+	ErrClusterNotFound  = errors.New("duplicate cluster not found")
+	ErrPlanItemNotFound = errors.New("file is not part of the cleanup plan")
+)
+
+// Cleanup plan states
+const (
+	PlanDraft           = "draft"
+	PlanCommitted       = "committed"
+	PlanExecuting       = "executing"
+	PlanCompleted       = "completed"
+	PlanPartiallyFailed = "partially_failed"
+)
+
+// Cleanup plan item states
+const (
+	PlanItemPending  = "pending"
+	PlanItemDeleted  = "deleted"
+	PlanItemFailed   = "failed"
+	PlanItemRestored = "restored" // Deleted, then restored from the trash
+)
+
+type CleanupService struct {
+	db                *gorm.DB
+	duplicateDetector *DuplicateDetector
+	fileService       *FileService
+	actionService     *ActionService
+}
+
+func NewCleanupService(db *gorm.DB, duplicateDetector *DuplicateDetector, fileService *FileService, actionService *ActionService) *CleanupService {
+	return &CleanupService{
+		db:                db,
+		duplicateDetector: duplicateDetector,
+		fileService:       fileService,
+		actionService:     actionService,
+	}
+}
+
+// CreatePlanRequest selects files by exact-hash duplicate cluster, keeping the oldest
+// copy of each, and by file ID. Override lets the plan delete the last copy of a file; it
+// needs a reason, which is kept for audit. Protected paths cannot be overridden.
+type CreatePlanRequest struct {
+	ClusterIDs     []string `json:"cluster_ids"`
+	FileIDs        []uint   `json:"file_ids"`
+	Override       bool     `json:"override"`
+	OverrideReason string   `json:"override_reason" binding:"required_if=Override true"`
+
+	PolicyID *uuid.UUID `json:"-"` // Set when a cleanup policy creates the plan
+}
+
+type PlanItemResult struct {
+	FileID uint   `json:"file_id" binding:"required"`
+	Status string `json:"status" binding:"required,oneof=deleted failed"`
+	Error  string `json:"error"`
+}
+
+type PlanResultsRequest struct {
+	Results []PlanItemResult `json:"results" binding:"required,min=1,dive"`
+}
+
+// CreatePlan builds a draft plan for review
+func (s *CleanupService) CreatePlan(ctx context.Context, userID uuid.UUID, req *CreatePlanRequest) (*models.CleanupPlan, error) {
+	fileIDs := append([]uint(nil), req.FileIDs...)
+
+	if len(req.ClusterIDs) > 0 {
+		clusters, err := s.duplicateDetector.DetectDuplicates(ctx, userID, StrategyHash)
+		if err != nil {
+			return nil, err
+		}
+		clusterFiles, err := redundantClusterFiles(clusters, req.ClusterIDs)
+		if err != nil {
+			return nil, err
+		}
+		fileIDs = append(fileIDs, clusterFiles...)
+	}
+
+	fileIDs = uniqueIDs(fileIDs)
+	if len(fileIDs) == 0 {
+		return nil, ErrEmptyPlan
+	}
+
+	var files []models.File
+	if err := s.db.WithContext(ctx).Where("id IN ? AND user_id = ?", fileIDs, userID).Order("id ASC").Find(&files).Error; err != nil {
+		return nil, fmt.Errorf("failed to load files: %w", err)
+	}
+	if len(files) != len(fileIDs) {
+		return nil, fmt.Errorf("%w: %d of %d files do not exist", ErrFileNotFound, len(fileIDs)-len(files), len(fileIDs))
+	}
+
+	plan := newPlan(userID, files)
+	plan.PolicyID = req.PolicyID
+	if req.Override {
+		plan.Override = true
+		plan.OverrideReason = req.OverrideReason
+	}
+
+	violations, err := checkPlanSafety(s.db.WithContext(ctx), plan)
+	if err != nil {
+		return nil, err
+	}
+	if blocking := blockingViolations(violations, plan.Override); len(blocking) > 0 {
+		return nil, &SafetyError{Violations: blocking}
+	}
+
+	if err := s.db.WithContext(ctx).Create(plan).Error; err != nil {
+		return nil, fmt.Errorf("failed to create cleanup plan: %w", err)
+	}
+
+	return plan, nil
+}
+
+func newPlan(userID uuid.UUID, files []models.File) *models.CleanupPlan {
+	plan := &models.CleanupPlan{
+		ID:     uuid.New(),
+		UserID: userID,
+		Status: PlanDraft,
+	}
+
+	for _, file := range files {
+		plan.Items = append(plan.Items, models.CleanupPlanItem{
+			FileID:   file.ID,
+			DeviceID: file.DeviceID,
+			PathTail: file.PathTail,
+			SHA256:   file.SHA256,
+			Size:     file.Size,
+			Mime:     file.Mime,
+			Status:   PlanItemPending,
+		})
+		plan.ProjectedBytes += file.Size
+	}
+	plan.ItemCount = len(plan.Items)
+
+	return plan
+}
+
+// redundantClusterFiles returns every file of the requested clusters except the first,
+// oldest copy, which is kept
+func redundantClusterFiles(clusters []DuplicateCluster, clusterIDs []string) ([]uint, error) {
+	byID := make(map[string]DuplicateCluster, len(clusters))
+	for _, cluster := range clusters {
+		byID[cluster.ID] = cluster
+	}
+
+	var fileIDs []uint
+	for _, id := range clusterIDs {
+		cluster, ok := byID[id]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrClusterNotFound, id)
+		}
+		for i, candidate := range cluster.Candidates {
+			if i == 0 {
+				continue
+			}
+			fileIDs = append(fileIDs, candidate.File.ID)
+		}
+	}
+
+	return fileIDs, nil
+}
+
+func uniqueIDs(ids []uint) []uint {
+	seen := make(map[uint]bool, len(ids))
+	result := make([]uint, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+// ListPlans returns the user's plans, newest first, without their items
+func (s *CleanupService) ListPlans(ctx context.Context, userID uuid.UUID) ([]models.CleanupPlan, error) {
+	plans := []models.CleanupPlan{}
+	err := s.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Find(&plans).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cleanup plans: %w", err)
+	}
+
+	return plans, nil
+}
+
+// GetPlan returns a plan with its items
+func (s *CleanupService) GetPlan(ctx context.Context, userID, planID uuid.UUID) (*models.CleanupPlan, error) {
+	return loadPlan(s.db.WithContext(ctx), userID, planID)
+}
+
+func loadPlan(db *gorm.DB, userID, planID uuid.UUID) (*models.CleanupPlan, error) {
+	var plan models.CleanupPlan
+	err := db.Preload("Items", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id ASC")
+	}).Where("id = ? AND user_id = ?", planID, userID).First(&plan).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrPlanNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cleanup plan: %w", err)
+	}
+
+	return &plan, nil
+}
+
+// CommitPlan approves a draft plan and queues a delete action for each file on its
+// device. Files are deleted as the devices acknowledge the actions. The safety rules are
+// checked again, since other plans may have been committed since this one was drafted.
+// The user row is locked first so that concurrent commits check the rules one at a time
+// and cannot each count the other's targets as surviving copies.
+func (s *CleanupService) CommitPlan(ctx context.Context, userID, planID uuid.UUID) (*models.CleanupPlan, error) {
+	var plan *models.CleanupPlan
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id").
+			Where("id = ?", userID).
+			Take(&models.User{}).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrPlanNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to lock user: %w", err)
+		}
+
+		plan, err = loadPlan(tx.Clauses(clause.Locking{Strength: "UPDATE"}), userID, planID)
+		if err != nil {
+			return err
+		}
+		if plan.Status != PlanDraft {
+			return ErrPlanState
+		}
+
+		violations, err := checkPlanSafety(tx, plan)
+		if err != nil {
+			return err
+		}
+		if blocking := blockingViolations(violations, plan.Override); len(blocking) > 0 {
+			return &SafetyError{Violations: blocking}
+		}
+		if len(violations) > 0 {
+			err = tx.Create(&models.SafetyOverride{
+				UserID:     userID,
+				PlanID:     plan.ID,
+				Reason:     plan.OverrideReason,
+				Violations: violations,
+			}).Error
+			if err != nil {
+				return fmt.Errorf("failed to record safety override: %w", err)
+			}
+		}
+
+		now := time.Now()
+		plan.Status = PlanCommitted
+		plan.CommittedAt = &now
+		err = tx.Model(&models.CleanupPlan{}).Where("id = ?", plan.ID).Updates(map[string]interface{}{
+			"status":       plan.Status,
+			"committed_at": now,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		return enqueueActions(tx, planDeleteActions(plan))
+	})
+	if err != nil {
+		return nil, planError("failed to commit cleanup plan", err)
+	}
+
+	deviceIDs := make([]string, len(plan.Items))
+	for i, item := range plan.Items {
+		deviceIDs[i] = item.DeviceID
+	}
+	s.actionService.notify(ctx, userID, deviceIDs)
+
+	return plan, nil
+}
+
+// DeleteFiles creates and commits a plan in one step
+func (s *CleanupService) DeleteFiles(ctx context.Context, userID uuid.UUID, req *CreatePlanRequest) (*models.CleanupPlan, error) {
+	plan, err := s.CreatePlan(ctx, userID, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.CommitPlan(ctx, userID, plan.ID)
+}
+
+// DeletePlan discards a draft plan
+func (s *CleanupService) DeletePlan(ctx context.Context, userID, planID uuid.UUID) error {
+	result := s.db.WithContext(ctx).
+		Where("id = ? AND user_id = ? AND status = ?", planID, userID, PlanDraft).
+		Delete(&models.CleanupPlan{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete cleanup plan: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		if _, err := s.GetPlan(ctx, userID, planID); err != nil {
+			return err
+		}
+		return ErrPlanState
+	}
+
+	return nil
+}
+
+// ReportResults records what the device did with each file, for devices that execute a
+// plan without acknowledging its actions one by one; the matching actions are cancelled.
+// Deleted files are moved to the trash. Once no item is pending the plan is completed,
+// or partially failed if any deletion failed, and its report is written.
+func (s *CleanupService) ReportResults(ctx context.Context, userID, planID uuid.UUID, req *PlanResultsRequest) (*models.CleanupPlan, error) {
+	var plan *models.CleanupPlan
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		plan, err = loadPlan(tx.Clauses(clause.Locking{Strength: "UPDATE"}), userID, planID)
+		if err != nil {
+			return err
+		}
+		if plan.Status != PlanCommitted && plan.Status != PlanExecuting {
+			return ErrPlanState
+		}
+
+		fileIDs := make([]uint, len(req.Results))
+		for i, result := range req.Results {
+			fileIDs[i] = result.FileID
+		}
+		if err := cancelPlanActions(tx, plan.ID, fileIDs); err != nil {
+			return err
+		}
+
+		return applyPlanResults(tx, plan, req.Results, time.Now())
+	})
+	if err != nil {
+		return nil, planError("failed to record cleanup results", err)
+	}
+
+	s.fileService.statsChanged(ctx, userID)
+
+	return plan, nil
+}
+
+// applyPlanResults updates the items of a locked, committed plan and finishes it when
+// every item is resolved
+func applyPlanResults(tx *gorm.DB, plan *models.CleanupPlan, results []PlanItemResult, now time.Time) error {
+	items := make(map[uint]*models.CleanupPlanItem, len(plan.Items))
+	for i := range plan.Items {
+		items[plan.Items[i].FileID] = &plan.Items[i]
+	}
+
+	var deletedIDs []uint
+	var events []models.FileEvent
+	for _, result := range results {
+		item, ok := items[result.FileID]
+		if !ok {
+			return fmt.Errorf("%w: %d", ErrPlanItemNotFound, result.FileID)
+		}
+		if item.Status != PlanItemPending {
+			continue
+		}
+
+		item.Status = result.Status
+		item.Error = result.Error
+		err := tx.Model(&models.CleanupPlanItem{}).Where("id = ?", item.ID).Updates(map[string]interface{}{
+			"status": item.Status,
+			"error":  item.Error,
+		}).Error
+		if err != nil {
+			return fmt.Errorf("failed to update plan item: %w", err)
+		}
+
+		if item.Status == PlanItemDeleted {
+			deletedIDs = append(deletedIDs, item.FileID)
+			events = append(events, removedEvent(models.File{
+				ID:       item.FileID,
+				UserID:   plan.UserID,
+				DeviceID: item.DeviceID,
+				PathTail: item.PathTail,
+				SHA256:   item.SHA256,
+				Size:     item.Size,
+			}))
+		}
+	}
+
+	if len(deletedIDs) > 0 {
+		if err := tx.Where("id IN ? AND user_id = ?", deletedIDs, plan.UserID).Delete(&models.File{}).Error; err != nil {
+			return fmt.Errorf("failed to trash deleted files: %w", err)
+		}
+		if err := recordFileEvents(tx, events); err != nil {
+			return err
+		}
+	}
+
+	plan.Status = planStatus(plan.Items)
+	if plan.Status == PlanCompleted || plan.Status == PlanPartiallyFailed {
+		report := planReport(plan, now)
+		if err := tx.Create(report).Error; err != nil {
+			return fmt.Errorf("failed to create report: %w", err)
+		}
+		plan.ReportID = &report.ID
+		if err := notifyPlanCompleted(tx, plan, report); err != nil {
+			return err
+		}
+		plan.CompletedAt = &now
+	}
+
+	return tx.Model(&models.CleanupPlan{}).Where("id = ?", plan.ID).Updates(map[string]interface{}{
+		"status":       plan.Status,
+		"report_id":    plan.ReportID,
+		"completed_at": plan.CompletedAt,
+	}).Error
+}
+
+// failDeviceItems fails the pending items a device holds in committed plans, since a
+// removed device will never acknowledge them. Plans left with no pending items finish
+// and get their report.
+func failDeviceItems(tx *gorm.DB, userID uuid.UUID, deviceID string, now time.Time) error {
+	var planIDs []uuid.UUID
+	err := tx.Model(&models.CleanupPlanItem{}).
+		Joins("JOIN cleanup_plans ON cleanup_plans.id = cleanup_plan_items.plan_id").
+		Where("cleanup_plans.user_id = ? AND cleanup_plans.status IN ?", userID, []string{PlanCommitted, PlanExecuting}).
+		Where("cleanup_plan_items.device_id = ? AND cleanup_plan_items.status = ?", deviceID, PlanItemPending).
+		Distinct().
+		Order("cleanup_plan_items.plan_id").
+		Pluck("cleanup_plan_items.plan_id", &planIDs).Error
+	if err != nil {
+		return fmt.Errorf("failed to get the device's cleanup plans: %w", err)
+	}
+
+	for _, planID := range planIDs {
+		plan, err := loadPlan(tx.Clauses(clause.Locking{Strength: "UPDATE"}), userID, planID)
+		if err != nil {
+			return err
+		}
+
+		var results []PlanItemResult
+		for _, item := range plan.Items {
+			if item.DeviceID == deviceID && item.Status == PlanItemPending {
+				results = append(results, PlanItemResult{FileID: item.FileID, Status: PlanItemFailed, Error: "device was removed"})
+			}
+		}
+		if err := applyPlanResults(tx, plan, results, now); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// planStatus derives a committed plan's state from its items
+func planStatus(items []models.CleanupPlanItem) string {
+	failed := false
+	resolved := 0
+	for _, item := range items {
+		switch item.Status {
+		case PlanItemDeleted, PlanItemRestored:
+			resolved++
+		case PlanItemFailed:
+			resolved++
+			failed = true
+		}
+	}
+
+	switch {
+	case resolved < len(items):
+		return PlanExecuting
+	case failed:
+		return PlanPartiallyFailed
+	default:
+		return PlanCompleted
+	}
+}
+
+// planReport summarizes the files a finished plan actually deleted
+func planReport(plan *models.CleanupPlan, completedAt time.Time) *models.Report {
+	report := &models.Report{
+		UserID:      plan.UserID,
+		PlanID:      &plan.ID,
+		StartedAt:   completedAt,
+		CompletedAt: completedAt,
+	}
+	if plan.CommittedAt != nil {
+		report.StartedAt = *plan.CommittedAt
+	}
+
+	for _, item := range plan.Items {
+		if item.Status == PlanItemDeleted {
+			report.BytesSaved += item.Size
+			report.ItemsDeleted++
+		}
+	}
+
+	return report
+}
+
+func planError(message string, err error) error {
+	for _, known := range []error{ErrPlanNotFound, ErrPlanState, ErrPlanItemNotFound, ErrSafetyViolation} {
+		if errors.Is(err, known) {
+			return err
+		}
+	}
+	return fmt.Errorf("%s: %w", message, err)
+}
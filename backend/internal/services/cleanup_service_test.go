@@ -0,0 +1,151 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/purespace/backend/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedundantClusterFiles(t *testing.T) {
+	clusters := []DuplicateCluster{
+		{ID: "a", Candidates: []DuplicateCandidate{
+			{File: models.File{ID: 1}}, {File: models.File{ID: 2}}, {File: models.File{ID: 3}},
+		}},
+		{ID: "b", Candidates: []DuplicateCandidate{
+			{File: models.File{ID: 4}}, {File: models.File{ID: 5}},
+		}},
+	}
+
+	ids, err := redundantClusterFiles(clusters, []string{"a", "b"})
+	require.NoError(t, err)
+	assert.Equal(t, []uint{2, 3, 5}, ids, "the first, oldest copy of each cluster is kept")
+
+	_, err = redundantClusterFiles(clusters, []string{"missing"})
+	assert.True(t, errors.Is(err, ErrClusterNotFound))
+}
+
+func TestNewPlan(t *testing.T) {
+	userID := uuid.New()
+	plan := newPlan(userID, []models.File{
+		{ID: 1, DeviceID: "pixel", PathTail: "a.jpg", Size: 100},
+		{ID: 2, DeviceID: "pixel", PathTail: "b.jpg", Size: 250},
+	})
+
+	assert.Equal(t, PlanDraft, plan.Status)
+	assert.Equal(t, userID, plan.UserID)
+	assert.Equal(t, 2, plan.ItemCount)
+	assert.Equal(t, int64(350), plan.ProjectedBytes)
+	for _, item := range plan.Items {
+		assert.Equal(t, PlanItemPending, item.Status)
+	}
+}
+
+func TestPlanStatus(t *testing.T) {
+	item := func(status string) models.CleanupPlanItem { return models.CleanupPlanItem{Status: status} }
+
+	tests := []struct {
+		name     string
+		items    []models.CleanupPlanItem
+		expected string
+	}{
+		{"Nothing reported", []models.CleanupPlanItem{item(PlanItemPending), item(PlanItemPending)}, PlanExecuting},
+		{"Some reported", []models.CleanupPlanItem{item(PlanItemDeleted), item(PlanItemPending)}, PlanExecuting},
+		{"All deleted", []models.CleanupPlanItem{item(PlanItemDeleted), item(PlanItemDeleted)}, PlanCompleted},
+		{"Some failed", []models.CleanupPlanItem{item(PlanItemDeleted), item(PlanItemFailed)}, PlanPartiallyFailed},
+		{"All failed", []models.CleanupPlanItem{item(PlanItemFailed)}, PlanPartiallyFailed},
+		{"Restored before completion", []models.CleanupPlanItem{item(PlanItemRestored), item(PlanItemDeleted)}, PlanCompleted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, planStatus(tt.items))
+		})
+	}
+}
+
+func TestPlanReport(t *testing.T) {
+	committedAt := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	completedAt := committedAt.Add(time.Hour)
+	plan := &models.CleanupPlan{
+		ID:          uuid.New(),
+		UserID:      uuid.New(),
+		CommittedAt: &committedAt,
+		Items: []models.CleanupPlanItem{
+			{Size: 100, Status: PlanItemDeleted},
+			{Size: 200, Status: PlanItemFailed},
+			{Size: 300, Status: PlanItemDeleted},
+		},
+	}
+
+	report := planReport(plan, completedAt)
+
+	assert.Equal(t, plan.UserID, report.UserID)
+	assert.Equal(t, plan.ID, *report.PlanID)
+	assert.Equal(t, int64(400), report.BytesSaved)
+	assert.Equal(t, 2, report.ItemsDeleted)
+	assert.Equal(t, committedAt, report.StartedAt)
+	assert.Equal(t, completedAt, report.CompletedAt)
+}
+
+func TestCleanupService_CommitPlan_OverlappingPlans(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t, &models.User{}, &models.File{}, &models.SafetySettings{}, &models.SafetyOverride{},
+		&models.CleanupPlan{}, &models.CleanupPlanItem{}, &models.DeviceAction{})
+	fileService := NewFileService(db, nil, 0)
+	cleanupService := NewCleanupService(db, NewDuplicateDetector(db), fileService, NewActionService(db, nil, fileService))
+
+	user := models.User{Email: "test@example.com", Provider: "google"}
+	require.NoError(t, db.Create(&user).Error)
+	hash := strings.Repeat("ab", 32)
+	files := []models.File{
+		{UserID: user.ID, DeviceID: "pixel", PathTail: "DCIM/a.jpg", SHA256: hash, Size: 100},
+		{UserID: user.ID, DeviceID: "tablet", PathTail: "DCIM/a.jpg", SHA256: hash, Size: 100},
+	}
+	require.NoError(t, db.Create(&files).Error)
+
+	// Each draft on its own leaves the other device's copy
+	var plans []*models.CleanupPlan
+	for _, file := range files {
+		plan, err := cleanupService.CreatePlan(ctx, user.ID, &CreatePlanRequest{FileIDs: []uint{file.ID}})
+		require.NoError(t, err)
+		plans = append(plans, plan)
+	}
+
+	errs := make([]error, len(plans))
+	var wg sync.WaitGroup
+	for i, plan := range plans {
+		wg.Add(1)
+		go func(i int, planID uuid.UUID) {
+			defer wg.Done()
+			_, errs[i] = cleanupService.CommitPlan(ctx, user.ID, planID)
+		}(i, plan.ID)
+	}
+	wg.Wait()
+
+	// Whichever commits second would delete the last copy
+	var committed, refused int
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			committed++
+		case errors.Is(err, ErrSafetyViolation):
+			refused++
+		default:
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	assert.Equal(t, 1, committed)
+	assert.Equal(t, 1, refused)
+
+	var actions int64
+	require.NoError(t, db.Model(&models.DeviceAction{}).Count(&actions).Error)
+	assert.Equal(t, int64(1), actions)
+}
@@ -0,0 +1,248 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/purespace/backend/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrDeviceNotFound     = errors.New("device not found")
+	ErrDeviceLimitReached = errors.New("device limit reached for free accounts")
+)
+
+type DeviceService struct {
+	db          *gorm.DB
+	fileService *FileService
+}
+
+func NewDeviceService(db *gorm.DB, fileService *FileService) *DeviceService {
+	return &DeviceService{
+		db:          db,
+		fileService: fileService,
+	}
+}
+
+type RegisterDeviceRequest struct {
+	DeviceID     string                `json:"device_id" binding:"required"`
+	Name         string                `json:"name"`
+	Manufacturer string                `json:"manufacturer"`
+	Model        string                `json:"model"`
+	OSVersion    string                `json:"os_version"`
+	TotalBytes   int64                 `json:"total_bytes" binding:"min=0"`
+	FreeBytes    int64                 `json:"free_bytes" binding:"min=0"`
+	Volumes      []models.DeviceVolume `json:"volumes"`
+	PushToken    string                `json:"push_token"` // Kept from the last registration when omitted
+}
+
+type RenameDeviceRequest struct {
+	Name string `json:"name" binding:"required,max=100"`
+}
+
+// deviceInfoColumns are refreshed every time a device registers again. The name is
+// kept, since the user may have renamed the device.
+var deviceInfoColumns = []string{"manufacturer", "model", "os_version", "total_bytes", "free_bytes", "volumes", "updated_at"}
+
+// Register adds a device or refreshes its hardware and storage details
+func (s *DeviceService) Register(ctx context.Context, userID uuid.UUID, req *RegisterDeviceRequest) (*models.Device, error) {
+	device := models.Device{
+		UserID:       userID,
+		DeviceID:     req.DeviceID,
+		Name:         req.Name,
+		Manufacturer: req.Manufacturer,
+		Model:        req.Model,
+		OSVersion:    req.OSVersion,
+		TotalBytes:   req.TotalBytes,
+		FreeBytes:    req.FreeBytes,
+		Volumes:      req.Volumes,
+		PushToken:    req.PushToken,
+	}
+	if device.Name == "" {
+		device.Name = defaultDeviceName(device)
+	}
+
+	columns := deviceInfoColumns
+	if req.PushToken != "" {
+		columns = append(append([]string(nil), deviceInfoColumns...), "push_token")
+	}
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Device{}).
+			Where("user_id = ? AND device_id = ?", userID, req.DeviceID).
+			Select(columns).
+			Updates(&device)
+		if result.Error != nil {
+			return fmt.Errorf("failed to update device: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			if err := createDevice(tx, &device, s.fileService.freeDeviceLimit); err != nil {
+				return err
+			}
+		}
+
+		return tx.Where("user_id = ? AND device_id = ?", userID, req.DeviceID).First(&device).Error
+	})
+	if err != nil {
+		if errors.Is(err, ErrDeviceLimitReached) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to register device: %w", err)
+	}
+
+	return &device, nil
+}
+
+// ListDevices returns the user's devices, oldest first
+func (s *DeviceService) ListDevices(ctx context.Context, userID uuid.UUID) ([]models.Device, error) {
+	devices := []models.Device{}
+	err := s.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("created_at ASC").
+		Find(&devices).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list devices: %w", err)
+	}
+
+	return devices, nil
+}
+
+// Rename changes a device's display name
+func (s *DeviceService) Rename(ctx context.Context, userID uuid.UUID, deviceID string, req *RenameDeviceRequest) (*models.Device, error) {
+	result := s.db.WithContext(ctx).Model(&models.Device{}).
+		Where("user_id = ? AND device_id = ?", userID, deviceID).
+		Update("name", req.Name)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to rename device: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrDeviceNotFound
+	}
+
+	var device models.Device
+	if err := s.db.WithContext(ctx).Where("user_id = ? AND device_id = ?", userID, deviceID).First(&device).Error; err != nil {
+		return nil, fmt.Errorf("failed to get device: %w", err)
+	}
+
+	return &device, nil
+}
+
+// Remove deletes a device together with its files, sync state, upload sessions, storage
+// snapshots and queued actions. File history is kept. The device's pending items in
+// committed cleanup plans fail, so those plans can still finish.
+func (s *DeviceService) Remove(ctx context.Context, userID uuid.UUID, deviceID string) error {
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("user_id = ? AND device_id = ?", userID, deviceID).Delete(&models.Device{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrDeviceNotFound
+		}
+
+		if err := failDeviceItems(tx, userID, deviceID, time.Now()); err != nil {
+			return err
+		}
+
+		for _, model := range []interface{}{
+			&models.File{},
+			&models.DeviceSyncState{},
+			&models.UploadSession{},
+			&models.StorageSnapshot{},
+			&models.DeviceAction{},
+			&models.ScanRun{},
+		} {
+			if err := tx.Unscoped().Where("user_id = ? AND device_id = ?", userID, deviceID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, ErrDeviceNotFound) {
+			return err
+		}
+		return fmt.Errorf("failed to remove device: %w", err)
+	}
+
+	s.fileService.statsChanged(ctx, userID)
+
+	return nil
+}
+
+// ensureDevice marks a device as synced, registering it first if this is the first
+// time it uploads. New devices count against the free-tier limit.
+func ensureDevice(tx *gorm.DB, userID uuid.UUID, deviceID string, freeLimit int) error {
+	now := time.Now()
+	result := tx.Model(&models.Device{}).
+		Where("user_id = ? AND device_id = ?", userID, deviceID).
+		Update("last_synced_at", now)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update device: %w", result.Error)
+	}
+	if result.RowsAffected > 0 {
+		return nil
+	}
+
+	return createDevice(tx, &models.Device{
+		UserID:       userID,
+		DeviceID:     deviceID,
+		Name:         deviceID,
+		LastSyncedAt: &now,
+	}, freeLimit)
+}
+
+// createDevice inserts a new device unless a free user already has freeLimit devices.
+// A limit of zero or less means unlimited. The user row is locked before counting, so
+// two devices registering at once cannot both pass the limit.
+func createDevice(tx *gorm.DB, device *models.Device, freeLimit int) error {
+	if freeLimit > 0 {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id").
+			Where("id = ?", device.UserID).
+			Take(&models.User{}).Error
+		if err != nil {
+			return fmt.Errorf("failed to lock user: %w", err)
+		}
+
+		var count int64
+		if err := tx.Model(&models.Device{}).Where("user_id = ?", device.UserID).Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to count devices: %w", err)
+		}
+
+		if count >= int64(freeLimit) {
+			premium, err := isPremium(tx, device.UserID)
+			if err != nil {
+				return err
+			}
+			if !premium {
+				return ErrDeviceLimitReached
+			}
+		}
+	}
+
+	// A concurrent request may have registered the same device
+	err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(device).Error
+	if err != nil {
+		return fmt.Errorf("failed to create device: %w", err)
+	}
+
+	return nil
+}
+
+func defaultDeviceName(device models.Device) string {
+	switch {
+	case device.Manufacturer != "" && device.Model != "":
+		return device.Manufacturer + " " + device.Model
+	case device.Model != "":
+		return device.Model
+	default:
+		return device.DeviceID
+	}
+}
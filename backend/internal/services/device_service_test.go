@@ -0,0 +1,48 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/purespace/backend/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeviceService_Remove_FailsPendingPlanItems(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t, &models.Device{}, &models.File{}, &models.FileEvent{}, &models.DeviceSyncState{},
+		&models.UploadSession{}, &models.StorageSnapshot{}, &models.DeviceAction{}, &models.ScanRun{},
+		&models.CleanupPlan{}, &models.CleanupPlanItem{}, &models.Report{}, &models.Notification{})
+	deviceService := NewDeviceService(db, NewFileService(db, newTestRedis(t), 0))
+
+	userID := uuid.New()
+	planID := uuid.New()
+	hash := strings.Repeat("ab", 32)
+	require.NoError(t, db.Create(&models.Device{UserID: userID, DeviceID: "pixel", Name: "Pixel"}).Error)
+	require.NoError(t, db.Create(&models.CleanupPlan{ID: planID, UserID: userID, Status: PlanExecuting}).Error)
+	require.NoError(t, db.Create(&[]models.CleanupPlanItem{
+		{PlanID: planID, FileID: 1, DeviceID: "tablet", PathTail: "a.jpg", SHA256: hash, Size: 100, Status: PlanItemDeleted},
+		{PlanID: planID, FileID: 2, DeviceID: "pixel", PathTail: "b.jpg", SHA256: hash, Size: 100, Status: PlanItemPending},
+	}).Error)
+
+	require.NoError(t, deviceService.Remove(ctx, userID, "pixel"))
+
+	var item models.CleanupPlanItem
+	require.NoError(t, db.Where("file_id = ?", 2).First(&item).Error)
+	assert.Equal(t, PlanItemFailed, item.Status)
+	assert.Equal(t, "device was removed", item.Error)
+
+	// No ack can arrive for the removed device, so the plan finishes now
+	var plan models.CleanupPlan
+	require.NoError(t, db.First(&plan, "id = ?", planID).Error)
+	assert.Equal(t, PlanPartiallyFailed, plan.Status)
+	require.NotNil(t, plan.ReportID)
+
+	var report models.Report
+	require.NoError(t, db.First(&report, *plan.ReportID).Error)
+	assert.Equal(t, int64(100), report.BytesSaved)
+	assert.Equal(t, 1, report.ItemsDeleted)
+}
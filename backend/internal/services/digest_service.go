@@ -0,0 +1,438 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"net/url"
+	"path"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/purespace/backend/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrUnsubscribeTokenNotFound = errors.New("unsubscribe token not found")
+
+const (
+	digestPeriod        = 7 * 24 * time.Hour
+	digestSendHour      = 8 // UTC
+	digestLargeFiles    = 5
+	maxDigestBatch      = 50
+	maxDigestAttempts   = 3
+	digestRetryDelay    = time.Hour
+	defaultDigestDay    = int(time.Monday)
+	unsubscribeTokenLen = 32
+)
+
+//go:embed templates/digest.txt templates/digest.html
+var digestTemplates embed.FS
+
+var digestFuncs = map[string]interface{}{
+	"bytes": formatBytes,
+	"signedBytes": func(n int64) string {
+		if n < 0 {
+			return "-" + formatBytes(-n)
+		}
+		return "+" + formatBytes(n)
+	},
+}
+
+var (
+	digestText = texttemplate.Must(texttemplate.New("digest.txt").Funcs(digestFuncs).ParseFS(digestTemplates, "templates/digest.txt"))
+	digestHTML = htmltemplate.Must(htmltemplate.New("digest.html").Funcs(digestFuncs).ParseFS(digestTemplates, "templates/digest.html"))
+)
+
+// DigestService builds and emails the weekly storage digest to users who opted in
+type DigestService struct {
+	db        *gorm.DB
+	mailer    Mailer
+	publicURL string
+}
+
+func NewDigestService(db *gorm.DB, mailer Mailer, publicURL string) *DigestService {
+	return &DigestService{
+		db:        db,
+		mailer:    mailer,
+		publicURL: strings.TrimRight(publicURL, "/"),
+	}
+}
+
+type DigestSettingsRequest struct {
+	Enabled bool `json:"enabled"`
+	Weekday *int `json:"weekday" binding:"omitempty,min=0,max=6"` // Defaults to Monday
+}
+
+// Digest is a user's storage activity over one week
+type Digest struct {
+	PeriodStart       time.Time        `json:"period_start"`
+	PeriodEnd         time.Time        `json:"period_end"`
+	TotalBytes        int64            `json:"total_bytes"`
+	TotalGrowth       int64            `json:"total_growth"`
+	Categories        []DigestCategory `json:"categories"`
+	NewDuplicates     int64            `json:"new_duplicates"`
+	NewDuplicateBytes int64            `json:"new_duplicate_bytes"`
+	LargeFiles        []DigestFile     `json:"large_files"`
+	BytesSaved        int64            `json:"bytes_saved"`
+	ItemsDeleted      int64            `json:"items_deleted"`
+}
+
+type DigestCategory struct {
+	Category string `json:"category"`
+	Bytes    int64  `json:"bytes"`
+	Growth   int64  `json:"growth"`
+}
+
+type DigestFile struct {
+	Name     string `json:"name"`
+	DeviceID string `json:"device_id"`
+	Size     int64  `json:"size"`
+}
+
+// GetSettings returns the user's digest settings; the digest is off until the user turns it on
+func (s *DigestService) GetSettings(ctx context.Context, userID uuid.UUID) (*models.DigestSettings, error) {
+	var settings models.DigestSettings
+	err := s.db.WithContext(ctx).Where("user_id = ?", userID).First(&settings).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &models.DigestSettings{UserID: userID, Weekday: defaultDigestDay}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get digest settings: %w", err)
+	}
+
+	return &settings, nil
+}
+
+// UpdateSettings turns the digest on or off and sets the day it is sent on. The
+// unsubscribe token is created with the settings and kept afterwards.
+func (s *DigestService) UpdateSettings(ctx context.Context, userID uuid.UUID, req *DigestSettingsRequest) (*models.DigestSettings, error) {
+	token, err := newUnsubscribeToken()
+	if err != nil {
+		return nil, err
+	}
+
+	settings := &models.DigestSettings{
+		UserID:           userID,
+		Enabled:          req.Enabled,
+		Weekday:          defaultDigestDay,
+		UnsubscribeToken: token,
+	}
+	if req.Weekday != nil {
+		settings.Weekday = *req.Weekday
+	}
+	if settings.Enabled {
+		next := nextDigestAt(settings.Weekday, time.Now())
+		settings.NextSendAt = &next
+	}
+
+	err = s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"enabled", "weekday", "next_send_at", "updated_at"}),
+	}).Create(settings).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to save digest settings: %w", err)
+	}
+
+	return s.GetSettings(ctx, userID)
+}
+
+// Unsubscribe turns the digest off for the user the token was issued to
+func (s *DigestService) Unsubscribe(ctx context.Context, token string) error {
+	if token == "" {
+		return ErrUnsubscribeTokenNotFound
+	}
+
+	result := s.db.WithContext(ctx).Model(&models.DigestSettings{}).
+		Where("unsubscribe_token = ?", token).
+		Updates(map[string]interface{}{
+			"enabled":      false,
+			"next_send_at": nil,
+		})
+	if result.Error != nil {
+		return fmt.Errorf("failed to unsubscribe: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrUnsubscribeTokenNotFound
+	}
+
+	return nil
+}
+
+// Preview returns the digest the user would receive now
+func (s *DigestService) Preview(ctx context.Context, userID uuid.UUID) (*Digest, error) {
+	return buildDigest(s.db.WithContext(ctx), userID, time.Now())
+}
+
+// SendDue emails the digests that are due and returns how many were sent. Due settings
+// are claimed with SKIP LOCKED and moved to the next week, so replicas sending at the
+// same time do not send a digest twice. A failed send is retried hourly, up to three times.
+func (s *DigestService) SendDue(ctx context.Context) (int, error) {
+	var due []models.DigestSettings
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("enabled = ? AND next_send_at <= ?", true, now).
+			Order("next_send_at ASC").
+			Limit(maxDigestBatch).
+			Find(&due).Error
+		if err != nil {
+			return err
+		}
+
+		for i := range due {
+			next := nextDigestAt(due[i].Weekday, now)
+			err := tx.Model(&models.DigestSettings{}).Where("user_id = ?", due[i].UserID).Update("next_send_at", next).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to claim digests: %w", err)
+	}
+
+	sent := 0
+	for i := range due {
+		now := time.Now()
+		delivered, sendErr := s.send(ctx, &due[i], now)
+
+		updates := map[string]interface{}{"attempts": 0, "last_error": ""}
+		switch {
+		case sendErr == nil:
+			if delivered {
+				updates["last_sent_at"] = now
+				sent++
+			}
+		case due[i].Attempts+1 < maxDigestAttempts:
+			updates["attempts"] = due[i].Attempts + 1
+			updates["last_error"] = sendErr.Error()
+			updates["next_send_at"] = now.Add(digestRetryDelay)
+		default:
+			// Give up on this week's digest; the claim already moved it to next week
+			updates["last_error"] = sendErr.Error()
+		}
+
+		err := s.db.WithContext(ctx).Model(&models.DigestSettings{}).Where("user_id = ?", due[i].UserID).Updates(updates).Error
+		if err != nil {
+			return sent, fmt.Errorf("failed to update digest settings: %w", err)
+		}
+	}
+
+	return sent, nil
+}
+
+// send builds and emails one user's digest. A user without any files or cleanups is
+// skipped, since the digest would be empty.
+func (s *DigestService) send(ctx context.Context, settings *models.DigestSettings, now time.Time) (bool, error) {
+	db := s.db.WithContext(ctx)
+
+	var user models.User
+	if err := db.Select("email").Where("id = ?", settings.UserID).Take(&user).Error; err != nil {
+		return false, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	digest, err := buildDigest(db, settings.UserID, now)
+	if err != nil {
+		return false, err
+	}
+	if digest.TotalBytes == 0 && digest.ItemsDeleted == 0 {
+		return false, nil
+	}
+
+	unsubscribeURL := s.publicURL + "/api/v1/digest/unsubscribe?token=" + url.QueryEscape(settings.UnsubscribeToken)
+	email, err := renderDigest(digest, unsubscribeURL)
+	if err != nil {
+		return false, err
+	}
+	email.To = user.Email
+
+	if err := s.mailer.Send(ctx, email); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// buildDigest gathers the user's activity over the week before now
+func buildDigest(db *gorm.DB, userID uuid.UUID, now time.Time) (*Digest, error) {
+	digest := &Digest{
+		PeriodStart: now.Add(-digestPeriod),
+		PeriodEnd:   now,
+		Categories:  []DigestCategory{},
+		LargeFiles:  []DigestFile{},
+	}
+
+	current, err := combinedSnapshot(db, userID, "day <= ?", startOfDay(now), "day DESC")
+	if err != nil {
+		return nil, err
+	}
+	// The baseline is the last snapshot before the week, or the first within it for new users
+	baseline, err := combinedSnapshot(db, userID, "day <= ?", startOfDay(digest.PeriodStart), "day DESC")
+	if err == nil && baseline == nil {
+		baseline, err = combinedSnapshot(db, userID, "day > ?", startOfDay(digest.PeriodStart), "day ASC")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if current != nil {
+		digest.TotalBytes = current.TotalBytes
+		if baseline == nil {
+			baseline = current
+		}
+		digest.TotalGrowth = current.TotalBytes - baseline.TotalBytes
+		digest.Categories = categoryGrowth(baseline, current)
+	}
+
+	// A file is a new duplicate if it appeared this week and an older copy of it exists
+	err = db.Raw(`
+		SELECT COUNT(*), COALESCE(SUM(f.size), 0)
+		FROM files f
+		WHERE f.user_id = ? AND f.deleted_at IS NULL AND f.first_seen_at >= ?
+		AND EXISTS (
+			SELECT 1 FROM files o
+			WHERE o.user_id = f.user_id AND o.sha256 = f.sha256 AND o.id < f.id AND o.deleted_at IS NULL
+		)
+	`, userID, digest.PeriodStart).Row().Scan(&digest.NewDuplicates, &digest.NewDuplicateBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count new duplicates: %w", err)
+	}
+
+	var files []models.File
+	err = db.Select("path_tail", "device_id", "size").
+		Where("user_id = ?", userID).
+		Order("size DESC").
+		Limit(digestLargeFiles).
+		Find(&files).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get large files: %w", err)
+	}
+	for _, file := range files {
+		digest.LargeFiles = append(digest.LargeFiles, DigestFile{
+			Name:     path.Base(file.PathTail),
+			DeviceID: file.DeviceID,
+			Size:     file.Size,
+		})
+	}
+
+	err = db.Model(&models.Report{}).
+		Select("COALESCE(SUM(bytes_saved), 0), COALESCE(SUM(items_deleted), 0)").
+		Where("user_id = ? AND completed_at >= ?", userID, digest.PeriodStart).
+		Row().Scan(&digest.BytesSaved, &digest.ItemsDeleted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sum cleanups: %w", err)
+	}
+
+	return digest, nil
+}
+
+// combinedSnapshot returns the user's first combined snapshot matching the day condition
+// in the given order, or nil if there is none
+func combinedSnapshot(db *gorm.DB, userID uuid.UUID, condition string, day time.Time, order string) (*models.StorageSnapshot, error) {
+	var snapshot models.StorageSnapshot
+	err := db.Where("user_id = ? AND device_id = ?", userID, "").
+		Where(condition, day).
+		Order(order).
+		Take(&snapshot).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get storage snapshot: %w", err)
+	}
+
+	return &snapshot, nil
+}
+
+// categoryGrowth lists the categories that hold data or changed between two snapshots
+func categoryGrowth(before, after *models.StorageSnapshot) []DigestCategory {
+	categories := []DigestCategory{}
+	for _, category := range mediaCategories {
+		size := snapshotCategoryBytes(after, category)
+		growth := size - snapshotCategoryBytes(before, category)
+		if size == 0 && growth == 0 {
+			continue
+		}
+		categories = append(categories, DigestCategory{Category: category, Bytes: size, Growth: growth})
+	}
+
+	return categories
+}
+
+func snapshotCategoryBytes(snapshot *models.StorageSnapshot, category string) int64 {
+	switch category {
+	case CategoryImages:
+		return snapshot.ImageBytes
+	case CategoryVideos:
+		return snapshot.VideoBytes
+	case CategoryAudio:
+		return snapshot.AudioBytes
+	case CategoryDocuments:
+		return snapshot.DocumentBytes
+	case CategoryArchives:
+		return snapshot.ArchiveBytes
+	case CategoryAPKs:
+		return snapshot.APKBytes
+	default:
+		return snapshot.OtherBytes
+	}
+}
+
+// renderDigest renders the digest email without its recipient
+func renderDigest(digest *Digest, unsubscribeURL string) (*Email, error) {
+	view := struct {
+		*Digest
+		UnsubscribeURL string
+	}{digest, unsubscribeURL}
+
+	var text, html bytes.Buffer
+	if err := digestText.Execute(&text, view); err != nil {
+		return nil, fmt.Errorf("failed to render digest: %w", err)
+	}
+	if err := digestHTML.Execute(&html, view); err != nil {
+		return nil, fmt.Errorf("failed to render digest: %w", err)
+	}
+
+	subject := fmt.Sprintf("Your PureSpace week: %s of storage", formatBytes(digest.TotalBytes))
+	if digest.NewDuplicates > 0 {
+		subject = fmt.Sprintf("Your PureSpace week: %s of new duplicates", formatBytes(digest.NewDuplicateBytes))
+	}
+
+	return &Email{
+		Subject: subject,
+		Text:    text.String(),
+		HTML:    html.String(),
+		Headers: map[string]string{
+			"List-Unsubscribe":      "<" + unsubscribeURL + ">",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		},
+	}, nil
+}
+
+// nextDigestAt returns the first send time on the weekday after the given time
+func nextDigestAt(weekday int, after time.Time) time.Time {
+	after = after.UTC()
+	next := time.Date(after.Year(), after.Month(), after.Day(), digestSendHour, 0, 0, 0, time.UTC)
+	next = next.AddDate(0, 0, (weekday-int(next.Weekday())+7)%7)
+	if !next.After(after) {
+		next = next.AddDate(0, 0, 7)
+	}
+	return next
+}
+
+func newUnsubscribeToken() (string, error) {
+	b := make([]byte, unsubscribeTokenLen)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate unsubscribe token: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/purespace/backend/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNextDigestAt(t *testing.T) {
+	// 2024-06-05 is a Wednesday
+	tests := []struct {
+		name     string
+		weekday  time.Weekday
+		after    time.Time
+		expected time.Time
+	}{
+		{"Later this week", time.Friday, time.Date(2024, 6, 5, 12, 0, 0, 0, time.UTC), time.Date(2024, 6, 7, 8, 0, 0, 0, time.UTC)},
+		{"Next week", time.Monday, time.Date(2024, 6, 5, 12, 0, 0, 0, time.UTC), time.Date(2024, 6, 10, 8, 0, 0, 0, time.UTC)},
+		{"Today before the hour", time.Wednesday, time.Date(2024, 6, 5, 7, 0, 0, 0, time.UTC), time.Date(2024, 6, 5, 8, 0, 0, 0, time.UTC)},
+		{"Today at the hour", time.Wednesday, time.Date(2024, 6, 5, 8, 0, 0, 0, time.UTC), time.Date(2024, 6, 12, 8, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, nextDigestAt(int(tt.weekday), tt.after))
+		})
+	}
+}
+
+func TestCategoryGrowth(t *testing.T) {
+	before := &models.StorageSnapshot{ImageBytes: 100, VideoBytes: 500, AudioBytes: 50}
+	after := &models.StorageSnapshot{ImageBytes: 300, VideoBytes: 400, AudioBytes: 50, APKBytes: 10}
+
+	assert.Equal(t, []DigestCategory{
+		{Category: CategoryImages, Bytes: 300, Growth: 200},
+		{Category: CategoryVideos, Bytes: 400, Growth: -100},
+		{Category: CategoryAudio, Bytes: 50, Growth: 0},
+		{Category: CategoryAPKs, Bytes: 10, Growth: 10},
+	}, categoryGrowth(before, after))
+}
+
+func TestRenderDigest(t *testing.T) {
+	digest := &Digest{
+		PeriodStart:       time.Date(2024, 6, 1, 8, 0, 0, 0, time.UTC),
+		PeriodEnd:         time.Date(2024, 6, 8, 8, 0, 0, 0, time.UTC),
+		TotalBytes:        3 << 30,
+		TotalGrowth:       -(1 << 20),
+		Categories:        []DigestCategory{{Category: CategoryImages, Bytes: 2 << 30, Growth: 1 << 20}},
+		NewDuplicates:     4,
+		NewDuplicateBytes: 10 << 20,
+		LargeFiles:        []DigestFile{{Name: "<movie>.mp4", Size: 1 << 30}},
+		BytesSaved:        5 << 20,
+		ItemsDeleted:      2,
+	}
+
+	email, err := renderDigest(digest, "https://api.example.com/api/v1/digest/unsubscribe?token=abc")
+	require.NoError(t, err)
+
+	assert.Equal(t, "Your PureSpace week: 10.0 MiB of new duplicates", email.Subject)
+	assert.Equal(t, "<https://api.example.com/api/v1/digest/unsubscribe?token=abc>", email.Headers["List-Unsubscribe"])
+	assert.Equal(t, "List-Unsubscribe=One-Click", email.Headers["List-Unsubscribe-Post"])
+
+	assert.Contains(t, email.Text, "Storage: 3.0 GiB (-1.0 MiB this week)")
+	assert.Contains(t, email.Text, "New duplicates: 4 files taking 10.0 MiB")
+	assert.Contains(t, email.Text, "<movie>.mp4")
+	assert.Contains(t, email.Text, "freed 5.0 MiB by deleting 2 files")
+
+	assert.Contains(t, email.HTML, "&lt;movie&gt;.mp4")
+	assert.False(t, strings.Contains(email.HTML, "<movie>"))
+	assert.Contains(t, email.HTML, `href="https://api.example.com/api/v1/digest/unsubscribe?token=abc"`)
+}
@@ -31,7 +31,7 @@ func (s *DuplicateService) GetDuplicateGroups(ctx context.Context, userID uuid.U
 			COUNT(*) as count,
 			SUM(size) as total_size
 		FROM files 
-		WHERE user_id = ? 
+		WHERE user_id = ? AND deleted_at IS NULL
 		GROUP BY sha256 
 		HAVING COUNT(*) > 1
 		ORDER BY total_size DESC
@@ -93,34 +93,6 @@ func (s *DuplicateService) GetDuplicateGroupsWithFiles(ctx context.Context, user
 	return groups, nil
 }
 
-// DeleteDuplicateFiles deletes specified files from a duplicate group
-func (s *DuplicateService) DeleteDuplicateFiles(ctx context.Context, userID uuid.UUID, fileIDs []uint) error {
-	if len(fileIDs) == 0 {
-		return fmt.Errorf("no file IDs provided")
-	}
-
-	// Verify all files belong to the user
-	var count int64
-	s.db.WithContext(ctx).Model(&models.File{}).
-		Where("id IN ? AND user_id = ?", fileIDs, userID).
-		Count(&count)
-
-	if count != int64(len(fileIDs)) {
-		return fmt.Errorf("some files don't belong to user or don't exist")
-	}
-
-	// Delete the files
-	result := s.db.WithContext(ctx).
-		Where("id IN ? AND user_id = ?", fileIDs, userID).
-		Delete(&models.File{})
-
-	if result.Error != nil {
-		return fmt.Errorf("failed to delete files: %w", result.Error)
-	}
-
-	return nil
-}
-
 // GetLargeFiles returns files above a certain size threshold
 func (s *DuplicateService) GetLargeFiles(ctx context.Context, userID uuid.UUID, minSize int64, limit int) ([]models.File, error) {
 	var files []models.File
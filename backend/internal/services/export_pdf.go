@@ -0,0 +1,160 @@
+package services
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+const (
+	pdfRowHeight    = 6.0
+	pdfCellPadding  = 2.0
+	pdfMeasuredRows = 500 // Rows measured when sizing columns
+)
+
+// writeExportPDF renders the table as a landscape A4 document using the built-in
+// Helvetica font, so no font files are needed. Characters outside Windows-1252 are
+// printed as dots; the CSV and JSON exports keep them.
+func writeExportPDF(w io.Writer, table *ExportTable) error {
+	pdf := gofpdf.New("L", "mm", "A4", "")
+	pdf.SetTitle("PureSpace - "+table.Title, true)
+	pdf.SetCreator("PureSpace", false)
+	pdf.SetCreationDate(table.GeneratedAt)
+	pdf.SetAutoPageBreak(true, 15)
+	pdf.AliasNbPages("")
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+
+	pageWidth, _ := pdf.GetPageSize()
+	left, _, right, _ := pdf.GetMargins()
+
+	pdf.SetFont("Helvetica", "", 9)
+	widths := pdfColumnWidths(pdf, table, tr, pageWidth-left-right)
+
+	header := func() {
+		pdf.SetFont("Helvetica", "B", 9)
+		pdf.SetFillColor(230, 236, 245)
+		for i, column := range table.Columns {
+			pdf.CellFormat(widths[i], pdfRowHeight+1, tr(column), "B", 0, "L", true, 0, "")
+		}
+		pdf.Ln(-1)
+		pdf.SetFont("Helvetica", "", 9)
+	}
+
+	tableStarted := false
+	pdf.SetHeaderFunc(func() {
+		if tableStarted {
+			header()
+		}
+	})
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-12)
+		pdf.SetFont("Helvetica", "", 8)
+		pdf.SetTextColor(120, 120, 120)
+		pdf.CellFormat(0, 5, fmt.Sprintf("Page %d of {nb}", pdf.PageNo()), "", 0, "R", false, 0, "")
+		pdf.SetTextColor(0, 0, 0)
+	})
+
+	pdf.AddPage()
+
+	pdf.SetFont("Helvetica", "B", 16)
+	pdf.CellFormat(0, 10, tr("PureSpace - "+table.Title), "", 1, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 9)
+	pdf.SetTextColor(90, 90, 90)
+	pdf.CellFormat(0, 5, "Generated "+table.GeneratedAt.UTC().Format("2006-01-02 15:04 MST"), "", 1, "L", false, 0, "")
+	pdf.SetTextColor(0, 0, 0)
+	pdf.Ln(3)
+
+	for _, total := range table.Totals {
+		pdf.SetFont("Helvetica", "B", 10)
+		pdf.CellFormat(45, pdfRowHeight, tr(total.Label), "", 0, "L", false, 0, "")
+		pdf.SetFont("Helvetica", "", 10)
+		pdf.CellFormat(0, pdfRowHeight, tr(total.Value), "", 1, "L", false, 0, "")
+	}
+	pdf.Ln(4)
+
+	if len(table.Rows) == 0 {
+		pdf.SetFont("Helvetica", "I", 10)
+		pdf.CellFormat(0, pdfRowHeight, "Nothing to report.", "", 1, "L", false, 0, "")
+		return pdf.Output(w)
+	}
+
+	header()
+	tableStarted = true
+
+	for _, row := range table.Rows {
+		for i, value := range row {
+			text := fitPDFText(pdf, tr(value), widths[i]-pdfCellPadding)
+			pdf.CellFormat(widths[i], pdfRowHeight, text, "", 0, "L", false, 0, "")
+		}
+		pdf.Ln(-1)
+	}
+
+	return pdf.Output(w)
+}
+
+// pdfColumnWidths sizes columns to their content. When the content does not fit, narrow
+// columns keep their width and the wide ones share the rest.
+func pdfColumnWidths(pdf *gofpdf.Fpdf, table *ExportTable, tr func(string) string, available float64) []float64 {
+	widths := make([]float64, len(table.Columns))
+	for i, column := range table.Columns {
+		widths[i] = pdf.GetStringWidth(tr(column))
+	}
+	for r, row := range table.Rows {
+		if r == pdfMeasuredRows {
+			break
+		}
+		for i, value := range row {
+			if width := pdf.GetStringWidth(tr(value)); width > widths[i] {
+				widths[i] = width
+			}
+		}
+	}
+
+	var total float64
+	for i := range widths {
+		widths[i] += 2 * pdfCellPadding
+		total += widths[i]
+	}
+	if total <= available {
+		for i := range widths {
+			widths[i] *= available / total
+		}
+		return widths
+	}
+
+	share := available / float64(len(widths))
+	fixed, wide := 0.0, 0.0
+	for _, width := range widths {
+		if width <= share {
+			fixed += width
+		} else {
+			wide += width
+		}
+	}
+	for i, width := range widths {
+		if width > share {
+			widths[i] = width / wide * (available - fixed)
+		}
+	}
+	return widths
+}
+
+// fitPDFText shortens text with a trailing "..." until it fits in width
+func fitPDFText(pdf *gofpdf.Fpdf, text string, width float64) string {
+	if pdf.GetStringWidth(text) <= width {
+		return text
+	}
+
+	// text is already single-byte encoded, so any prefix is valid
+	lo, hi := 0, len(text)
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if pdf.GetStringWidth(text[:mid]+"...") <= width {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	return text[:lo] + "..."
+}
@@ -0,0 +1,327 @@
+package services
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/purespace/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrPremiumRequired = errors.New("this feature requires a premium subscription")
+	ErrInvalidExport   = errors.New("invalid export request")
+)
+
+// Export formats
+const (
+	ExportCSV  = "csv"
+	ExportJSON = "json"
+	ExportPDF  = "pdf"
+)
+
+// Export scopes
+const (
+	ScopeDuplicates     = "duplicates"
+	ScopeLargeFiles     = "large-files"
+	ScopeCleanupHistory = "cleanup-history"
+)
+
+// DefaultLargeFileSize is the smallest file the large files listing and export include
+// unless the client asks for another threshold
+const DefaultLargeFileSize = 100 * 1024 * 1024
+
+var exportContentTypes = map[string]string{
+	ExportCSV:  "text/csv; charset=utf-8",
+	ExportJSON: "application/json",
+	ExportPDF:  "application/pdf",
+}
+
+// ExportService builds shareable documents from the user's duplicates, large files
+// and cleanup history. Exports are a premium feature.
+type ExportService struct {
+	db               *gorm.DB
+	duplicateService *DuplicateService
+	reportService    *ReportService
+}
+
+func NewExportService(db *gorm.DB, duplicateService *DuplicateService, reportService *ReportService) *ExportService {
+	return &ExportService{
+		db:               db,
+		duplicateService: duplicateService,
+		reportService:    reportService,
+	}
+}
+
+type ExportRequest struct {
+	Format  string
+	Scope   string
+	MinSize int64 // Large files only
+}
+
+// ExportTable is a generated export. CSV and PDF render Columns and Rows; JSON renders
+// Data, which keeps the full records.
+type ExportTable struct {
+	Title       string        `json:"title"`
+	Scope       string        `json:"scope"`
+	GeneratedAt time.Time     `json:"generated_at"`
+	Totals      []ExportTotal `json:"totals"`
+	Columns     []string      `json:"-"`
+	Rows        [][]string    `json:"-"`
+	Data        interface{}   `json:"data"`
+}
+
+type ExportTotal struct {
+	Label string `json:"label"`
+	Value string `json:"value"`
+}
+
+// Validate checks the format and scope of an export request
+func (r *ExportRequest) Validate() error {
+	if _, ok := exportContentTypes[r.Format]; !ok {
+		return fmt.Errorf("%w: unknown format %q, expected csv, json or pdf", ErrInvalidExport, r.Format)
+	}
+	switch r.Scope {
+	case ScopeDuplicates, ScopeLargeFiles, ScopeCleanupHistory:
+	default:
+		return fmt.Errorf("%w: unknown scope %q, expected duplicates, large-files or cleanup-history", ErrInvalidExport, r.Scope)
+	}
+	if r.MinSize <= 0 {
+		r.MinSize = DefaultLargeFileSize
+	}
+	return nil
+}
+
+// ContentType returns the MIME type of the export
+func (r *ExportRequest) ContentType() string {
+	return exportContentTypes[r.Format]
+}
+
+// Filename returns a download name such as purespace-duplicates-2024-06-01.csv
+func (r *ExportRequest) Filename(now time.Time) string {
+	return fmt.Sprintf("purespace-%s-%s.%s", r.Scope, now.Format("2006-01-02"), r.Format)
+}
+
+// BuildExport collects the data for an export after checking the user's subscription
+func (s *ExportService) BuildExport(ctx context.Context, userID uuid.UUID, req *ExportRequest) (*ExportTable, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	premium, err := isPremium(s.db.WithContext(ctx), userID)
+	if err != nil {
+		return nil, err
+	}
+	if !premium {
+		return nil, ErrPremiumRequired
+	}
+
+	now := time.Now().UTC()
+	switch req.Scope {
+	case ScopeDuplicates:
+		groups, err := s.duplicateService.GetDuplicateGroupsWithFiles(ctx, userID, 0)
+		if err != nil {
+			return nil, err
+		}
+		return duplicatesTable(groups, now), nil
+	case ScopeLargeFiles:
+		files, err := s.duplicateService.GetLargeFiles(ctx, userID, req.MinSize, 0)
+		if err != nil {
+			return nil, err
+		}
+		return largeFilesTable(files, req.MinSize, now), nil
+	default:
+		reports, err := s.cleanupHistory(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		return cleanupHistoryTable(reports, now), nil
+	}
+}
+
+// cleanupHistory returns every report with the files it deleted, newest first
+func (s *ExportService) cleanupHistory(ctx context.Context, userID uuid.UUID) ([]ReportDetail, error) {
+	reports, err := s.reportService.ListReports(ctx, userID, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	details := make([]ReportDetail, len(reports))
+	byPlan := make(map[uuid.UUID]*ReportDetail)
+	var planIDs []uuid.UUID
+	for i, report := range reports {
+		details[i] = ReportDetail{Report: report, Files: []models.CleanupPlanItem{}}
+		if report.PlanID != nil {
+			byPlan[*report.PlanID] = &details[i]
+			planIDs = append(planIDs, *report.PlanID)
+		}
+	}
+
+	if len(planIDs) > 0 {
+		var items []models.CleanupPlanItem
+		err := s.db.WithContext(ctx).
+			Where("plan_id IN ? AND status = ?", planIDs, PlanItemDeleted).
+			Order("id ASC").
+			Find(&items).Error
+		if err != nil {
+			return nil, fmt.Errorf("failed to get report files: %w", err)
+		}
+		for _, item := range items {
+			detail := byPlan[item.PlanID]
+			detail.Files = append(detail.Files, item)
+		}
+	}
+
+	return details, nil
+}
+
+func duplicatesTable(groups []models.DuplicateGroup, now time.Time) *ExportTable {
+	table := &ExportTable{
+		Title:       "Duplicate files",
+		Scope:       ScopeDuplicates,
+		GeneratedAt: now,
+		Columns:     []string{"SHA-256", "Device", "Path", "Size", "Modified"},
+		Rows:        [][]string{},
+		Data:        groups,
+	}
+
+	var files int
+	var reclaimable int64
+	for _, group := range groups {
+		files += len(group.Files)
+		if group.Count > 1 {
+			reclaimable += group.TotalSize / int64(group.Count) * int64(group.Count-1)
+		}
+		for _, file := range group.Files {
+			table.Rows = append(table.Rows, []string{
+				group.SHA256, file.DeviceID, file.PathTail, strconv.FormatInt(file.Size, 10), exportTime(file.ModifiedAt),
+			})
+		}
+	}
+
+	table.Totals = []ExportTotal{
+		{Label: "Duplicate groups", Value: strconv.Itoa(len(groups))},
+		{Label: "Files", Value: strconv.Itoa(files)},
+		{Label: "Reclaimable", Value: formatBytes(reclaimable)},
+	}
+	return table
+}
+
+func largeFilesTable(files []models.File, minSize int64, now time.Time) *ExportTable {
+	table := &ExportTable{
+		Title:       "Large files",
+		Scope:       ScopeLargeFiles,
+		GeneratedAt: now,
+		Columns:     []string{"Device", "Path", "Size", "MIME type", "Modified"},
+		Rows:        make([][]string, 0, len(files)),
+		Data:        files,
+	}
+
+	var total int64
+	for _, file := range files {
+		total += file.Size
+		table.Rows = append(table.Rows, []string{
+			file.DeviceID, file.PathTail, strconv.FormatInt(file.Size, 10), file.Mime, exportTime(file.ModifiedAt),
+		})
+	}
+
+	table.Totals = []ExportTotal{
+		{Label: "Minimum size", Value: formatBytes(minSize)},
+		{Label: "Files", Value: strconv.Itoa(len(files))},
+		{Label: "Total size", Value: formatBytes(total)},
+	}
+	return table
+}
+
+func cleanupHistoryTable(reports []ReportDetail, now time.Time) *ExportTable {
+	table := &ExportTable{
+		Title:       "Cleanup history",
+		Scope:       ScopeCleanupHistory,
+		GeneratedAt: now,
+		Columns:     []string{"Report", "Completed", "Device", "Path", "Size"},
+		Rows:        [][]string{},
+		Data:        reports,
+	}
+
+	var items int
+	var saved int64
+	for _, detail := range reports {
+		report := detail.Report
+		items += report.ItemsDeleted
+		saved += report.BytesSaved
+
+		reportID := strconv.FormatUint(uint64(report.ID), 10)
+		completed := report.CompletedAt.UTC().Format(time.RFC3339)
+		if len(detail.Files) == 0 {
+			// Reports written before per-file details were kept have totals only
+			table.Rows = append(table.Rows, []string{reportID, completed, "", "", strconv.FormatInt(report.BytesSaved, 10)})
+			continue
+		}
+		for _, file := range detail.Files {
+			table.Rows = append(table.Rows, []string{
+				reportID, completed, file.DeviceID, file.PathTail, strconv.FormatInt(file.Size, 10),
+			})
+		}
+	}
+
+	table.Totals = []ExportTotal{
+		{Label: "Cleanups", Value: strconv.Itoa(len(reports))},
+		{Label: "Files deleted", Value: strconv.Itoa(items)},
+		{Label: "Space saved", Value: formatBytes(saved)},
+	}
+	return table
+}
+
+// WriteExport renders the table in the requested format
+func WriteExport(w io.Writer, format string, table *ExportTable) error {
+	switch format {
+	case ExportCSV:
+		return writeExportCSV(w, table)
+	case ExportJSON:
+		return json.NewEncoder(w).Encode(table)
+	case ExportPDF:
+		return writeExportPDF(w, table)
+	default:
+		return fmt.Errorf("%w: unknown format %q", ErrInvalidExport, format)
+	}
+}
+
+func writeExportCSV(w io.Writer, table *ExportTable) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(table.Columns); err != nil {
+		return err
+	}
+	if err := cw.WriteAll(table.Rows); err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+func exportTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// formatBytes renders a size with binary units, such as 1.5 GiB
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit && exp < 4; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTP"[exp])
+}
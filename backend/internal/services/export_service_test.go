@@ -0,0 +1,116 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/purespace/backend/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportRequest_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     ExportRequest
+		wantErr bool
+	}{
+		{"CSV duplicates", ExportRequest{Format: ExportCSV, Scope: ScopeDuplicates}, false},
+		{"JSON large files", ExportRequest{Format: ExportJSON, Scope: ScopeLargeFiles}, false},
+		{"PDF history", ExportRequest{Format: ExportPDF, Scope: ScopeCleanupHistory}, false},
+		{"Unknown format", ExportRequest{Format: "xlsx", Scope: ScopeDuplicates}, true},
+		{"Unknown scope", ExportRequest{Format: ExportCSV, Scope: "photos"}, true},
+		{"Missing scope", ExportRequest{Format: ExportCSV}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.req.Validate()
+			if tt.wantErr {
+				assert.True(t, errors.Is(err, ErrInvalidExport))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, int64(DefaultLargeFileSize), tt.req.MinSize)
+		})
+	}
+}
+
+func TestExportRequest_Filename(t *testing.T) {
+	req := ExportRequest{Format: ExportPDF, Scope: ScopeCleanupHistory}
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, "purespace-cleanup-history-2024-06-01.pdf", req.Filename(now))
+	assert.Equal(t, "application/pdf", req.ContentType())
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		input    int64
+		expected string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{100 << 20, "100.0 MiB"},
+		{3 << 30, "3.0 GiB"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, formatBytes(tt.input))
+	}
+}
+
+func TestDuplicatesTable(t *testing.T) {
+	groups := []models.DuplicateGroup{
+		{SHA256: "aaa", Count: 3, TotalSize: 300, Files: []models.File{
+			{DeviceID: "pixel", PathTail: "a.jpg", Size: 100},
+			{DeviceID: "pixel", PathTail: "b.jpg", Size: 100},
+			{DeviceID: "tablet", PathTail: "c.jpg", Size: 100},
+		}},
+		{SHA256: "bbb", Count: 2, TotalSize: 2048, Files: []models.File{
+			{DeviceID: "pixel", PathTail: "d.mp4", Size: 1024},
+			{DeviceID: "pixel", PathTail: "e.mp4", Size: 1024},
+		}},
+	}
+
+	table := duplicatesTable(groups, time.Now())
+
+	assert.Len(t, table.Rows, 5)
+	assert.Equal(t, []string{"aaa", "pixel", "a.jpg", "100", ""}, table.Rows[0])
+	assert.Equal(t, []ExportTotal{
+		{Label: "Duplicate groups", Value: "2"},
+		{Label: "Files", Value: "5"},
+		{Label: "Reclaimable", Value: "1.2 KiB"},
+	}, table.Totals)
+}
+
+func TestWriteExport(t *testing.T) {
+	table := largeFilesTable([]models.File{
+		{DeviceID: "pixel", PathTail: "Movies/holiday, 2023.mp4", Size: 200 << 20, Mime: "video/mp4"},
+		{DeviceID: "pixel", PathTail: strings.Repeat("very/long/folder/", 30) + "backup.zip", Size: 150 << 20},
+	}, DefaultLargeFileSize, time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+
+	var csvOut bytes.Buffer
+	require.NoError(t, WriteExport(&csvOut, ExportCSV, table))
+	lines := strings.Split(strings.TrimSpace(csvOut.String()), "\n")
+	require.Len(t, lines, 3)
+	assert.Equal(t, "Device,Path,Size,MIME type,Modified", lines[0])
+	assert.Equal(t, `pixel,"Movies/holiday, 2023.mp4",209715200,video/mp4,`, lines[1])
+
+	var jsonOut bytes.Buffer
+	require.NoError(t, WriteExport(&jsonOut, ExportJSON, table))
+	assert.Contains(t, jsonOut.String(), `"scope":"large-files"`)
+	assert.Contains(t, jsonOut.String(), `"path_tail":"Movies/holiday, 2023.mp4"`)
+
+	var pdfOut bytes.Buffer
+	require.NoError(t, WriteExport(&pdfOut, ExportPDF, table))
+	assert.True(t, bytes.HasPrefix(pdfOut.Bytes(), []byte("%PDF-")))
+
+	err := WriteExport(&bytes.Buffer{}, "xlsx", table)
+	assert.True(t, errors.Is(err, ErrInvalidExport))
+}
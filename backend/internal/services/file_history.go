@@ -0,0 +1,134 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/purespace/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// ErrFileNotFound is returned when a file has neither a row nor any history for the user
+var ErrFileNotFound = errors.New("file not found")
+
+// File event types
+const (
+	FileEventFirstSeen      = "first_seen"
+	FileEventMoved          = "moved"
+	FileEventContentChanged = "content_changed"
+	FileEventRemoved        = "removed"
+	FileEventRestored       = "restored"
+)
+
+type FileHistory struct {
+	File   *models.File       `json:"file"` // nil once the file has been purged; deleted_at is set while it is in the trash
+	Events []models.FileEvent `json:"events"`
+}
+
+// GetFileHistory returns a file and its recorded events, oldest first
+func (s *FileService) GetFileHistory(ctx context.Context, userID uuid.UUID, fileID uint) (*FileHistory, error) {
+	history := &FileHistory{}
+
+	var file models.File
+	err := s.db.WithContext(ctx).Unscoped().Where("id = ? AND user_id = ?", fileID, userID).First(&file).Error
+	switch {
+	case err == nil:
+		history.File = &file
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, fmt.Errorf("failed to get file: %w", err)
+	}
+
+	err = s.db.WithContext(ctx).
+		Where("file_id = ? AND user_id = ?", fileID, userID).
+		Order("created_at ASC, id ASC").
+		Find(&history.Events).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file history: %w", err)
+	}
+
+	if history.File == nil && len(history.Events) == 0 {
+		return nil, ErrFileNotFound
+	}
+
+	return history, nil
+}
+
+func recordFileEvents(tx *gorm.DB, events []models.FileEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	if err := tx.CreateInBatches(&events, upsertBatchSize).Error; err != nil {
+		return fmt.Errorf("failed to record file history: %w", err)
+	}
+
+	return nil
+}
+
+func firstSeenEvent(file models.File) models.FileEvent {
+	return models.FileEvent{
+		FileID:    file.ID,
+		UserID:    file.UserID,
+		DeviceID:  file.DeviceID,
+		Type:      FileEventFirstSeen,
+		NewPath:   file.PathTail,
+		NewSHA256: file.SHA256,
+		NewSize:   file.Size,
+	}
+}
+
+func contentChangedEvent(before, after models.File) models.FileEvent {
+	return models.FileEvent{
+		FileID:    before.ID,
+		UserID:    before.UserID,
+		DeviceID:  before.DeviceID,
+		Type:      FileEventContentChanged,
+		OldPath:   before.PathTail,
+		NewPath:   after.PathTail,
+		OldSHA256: before.SHA256,
+		NewSHA256: after.SHA256,
+		OldSize:   before.Size,
+		NewSize:   after.Size,
+	}
+}
+
+func movedEvent(before models.File, newPath string) models.FileEvent {
+	return models.FileEvent{
+		FileID:    before.ID,
+		UserID:    before.UserID,
+		DeviceID:  before.DeviceID,
+		Type:      FileEventMoved,
+		OldPath:   before.PathTail,
+		NewPath:   newPath,
+		OldSHA256: before.SHA256,
+		NewSHA256: before.SHA256,
+		OldSize:   before.Size,
+		NewSize:   before.Size,
+	}
+}
+
+func removedEvent(file models.File) models.FileEvent {
+	return models.FileEvent{
+		FileID:    file.ID,
+		UserID:    file.UserID,
+		DeviceID:  file.DeviceID,
+		Type:      FileEventRemoved,
+		OldPath:   file.PathTail,
+		OldSHA256: file.SHA256,
+		OldSize:   file.Size,
+	}
+}
+
+func restoredEvent(file models.File) models.FileEvent {
+	return models.FileEvent{
+		FileID:    file.ID,
+		UserID:    file.UserID,
+		DeviceID:  file.DeviceID,
+		Type:      FileEventRestored,
+		NewPath:   file.PathTail,
+		NewSHA256: file.SHA256,
+		NewSize:   file.Size,
+	}
+}
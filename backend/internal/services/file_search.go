@@ -0,0 +1,310 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/purespace/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+var ErrInvalidSearchQuery = errors.New("invalid search query")
+
+const (
+	defaultSearchPageSize = 50
+	maxSearchPageSize     = 500
+	maxSearchPage         = 10000 // Keeps the offset far from overflowing
+)
+
+// SearchQuery is a parsed file search. Every term becomes a parameterized WHERE
+// clause and the clauses are ANDed together.
+type SearchQuery struct {
+	clauses []searchClause
+}
+
+type searchClause struct {
+	sql  string
+	args []interface{}
+}
+
+type SearchResult struct {
+	Files      []models.File `json:"files"`
+	Total      int64         `json:"total"`
+	TotalBytes int64         `json:"total_bytes"`
+	Page       int           `json:"page"`
+	PageSize   int           `json:"page_size"`
+}
+
+// fileTimestamp is when a file was last modified, falling back to when we first stored it
+const fileTimestamp = "COALESCE(modified_at, created_at)"
+
+var sizeUnits = map[string]int64{
+	"":   1,
+	"b":  1,
+	"k":  1 << 10,
+	"kb": 1 << 10,
+	"m":  1 << 20,
+	"mb": 1 << 20,
+	"g":  1 << 30,
+	"gb": 1 << 30,
+	"t":  1 << 40,
+	"tb": 1 << 40,
+}
+
+var ageUnits = map[string]time.Duration{
+	"h": time.Hour,
+	"d": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour,
+	"m": 30 * 24 * time.Hour,
+	"y": 365 * 24 * time.Hour,
+}
+
+// ParseSearchQuery parses a query such as
+//
+//	size>100MB mime:video/* device:pixel path:DCIM/** dup:true older:1y
+//
+// Supported terms are size (with >, >=, <, <=, = or :), mime, device, path, ext,
+// dup, older and newer. A leading "-" negates a term, values may be double-quoted,
+// and a bare word matches anywhere in the path. Ages are relative to now.
+func ParseSearchQuery(q string, now time.Time) (*SearchQuery, error) {
+	tokens, err := tokenizeSearchQuery(q)
+	if err != nil {
+		return nil, err
+	}
+
+	query := &SearchQuery{}
+	for _, token := range tokens {
+		negate := false
+		if strings.HasPrefix(token, "-") && len(token) > 1 {
+			negate = true
+			token = token[1:]
+		}
+
+		clause, err := parseSearchTerm(token, now)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidSearchQuery, err)
+		}
+		if negate {
+			clause.sql = "NOT (" + clause.sql + ")"
+		}
+		query.clauses = append(query.clauses, clause)
+	}
+
+	return query, nil
+}
+
+func tokenizeSearchQuery(q string) ([]string, error) {
+	var tokens []string
+	var current strings.Builder
+	inQuotes := false
+
+	for _, r := range q {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+		case unicode.IsSpace(r) && !inQuotes:
+			if current.Len() > 0 {
+				tokens = append(tokens, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if inQuotes {
+		return nil, fmt.Errorf("%w: unterminated quote", ErrInvalidSearchQuery)
+	}
+	if current.Len() > 0 {
+		tokens = append(tokens, current.String())
+	}
+
+	return tokens, nil
+}
+
+func parseSearchTerm(token string, now time.Time) (searchClause, error) {
+	if len(token) > len("size") && strings.EqualFold(token[:len("size")], "size") &&
+		strings.ContainsRune("<>=:", rune(token[len("size")])) {
+		return parseSizeTerm(token[len("size"):])
+	}
+
+	key, value, found := strings.Cut(token, ":")
+	if !found {
+		return searchClause{"path_tail ILIKE ?", []interface{}{"%" + escapeLike(token) + "%"}}, nil
+	}
+	if value == "" {
+		return searchClause{}, fmt.Errorf("%s: missing value", key)
+	}
+
+	switch strings.ToLower(key) {
+	case "mime":
+		value = strings.ToLower(value)
+		if strings.HasSuffix(value, "/*") {
+			return searchClause{"mime LIKE ?", []interface{}{escapeLike(strings.TrimSuffix(value, "*")) + "%"}}, nil
+		}
+		return searchClause{"mime = ?", []interface{}{value}}, nil
+	case "device":
+		return searchClause{"device_id ILIKE ?", []interface{}{"%" + escapeLike(value) + "%"}}, nil
+	case "path":
+		return searchClause{"path_tail ~* ?", []interface{}{pathGlobRegex(value)}}, nil
+	case "ext":
+		return searchClause{"path_tail ILIKE ?", []interface{}{"%." + escapeLike(strings.TrimPrefix(value, "."))}}, nil
+	case "dup":
+		dup, err := strconv.ParseBool(value)
+		if err != nil {
+			return searchClause{}, fmt.Errorf("dup: expected true or false, got %q", value)
+		}
+		sql := "EXISTS (SELECT 1 FROM files d WHERE d.user_id = files.user_id AND d.sha256 = files.sha256 AND d.id <> files.id AND d.deleted_at IS NULL)"
+		if !dup {
+			sql = "NOT " + sql
+		}
+		return searchClause{sql, nil}, nil
+	case "older", "newer":
+		age, err := parseAge(value)
+		if err != nil {
+			return searchClause{}, fmt.Errorf("%s: %w", key, err)
+		}
+		op := "<"
+		if strings.ToLower(key) == "newer" {
+			op = ">="
+		}
+		return searchClause{fileTimestamp + " " + op + " ?", []interface{}{now.Add(-age)}}, nil
+	default:
+		return searchClause{}, fmt.Errorf("unknown field %q", key)
+	}
+}
+
+func parseSizeTerm(rest string) (searchClause, error) {
+	op := ""
+	for _, candidate := range []string{">=", "<=", ">", "<", "=", ":"} {
+		if strings.HasPrefix(rest, candidate) {
+			op = candidate
+			break
+		}
+	}
+	if op == "" {
+		return searchClause{}, fmt.Errorf("size: expected one of > >= < <= = :")
+	}
+
+	size, err := parseSize(rest[len(op):])
+	if err != nil {
+		return searchClause{}, err
+	}
+	if op == ":" {
+		op = "="
+	}
+
+	return searchClause{"size " + op + " ?", []interface{}{size}}, nil
+}
+
+// parseSize reads a byte count with an optional binary unit, e.g. 100MB or 1.5g
+func parseSize(value string) (int64, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	split := strings.IndexFunc(value, func(r rune) bool { return !unicode.IsDigit(r) && r != '.' })
+	if split == -1 {
+		split = len(value)
+	}
+
+	number, err := strconv.ParseFloat(value[:split], 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("size: invalid number %q", value)
+	}
+	unit, ok := sizeUnits[value[split:]]
+	if !ok {
+		return 0, fmt.Errorf("size: unknown unit %q", value[split:])
+	}
+
+	size := number * float64(unit)
+	if size >= math.MaxInt64 {
+		return 0, fmt.Errorf("size: %q is too large", value)
+	}
+
+	return int64(size), nil
+}
+
+// parseAge reads an age such as 12h, 30d, 2w, 6m or 1y (months are 30 days, years 365)
+func parseAge(value string) (time.Duration, error) {
+	value = strings.ToLower(value)
+	if len(value) < 2 {
+		return 0, fmt.Errorf("invalid age %q", value)
+	}
+
+	unit, ok := ageUnits[value[len(value)-1:]]
+	if !ok {
+		return 0, fmt.Errorf("unknown age unit in %q", value)
+	}
+	n, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid age %q", value)
+	}
+	if n > math.MaxInt64/int64(unit) {
+		return 0, fmt.Errorf("age %q is too large", value)
+	}
+
+	return time.Duration(n) * unit, nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// SearchFiles runs a search query over the user's files, largest first
+func (s *FileService) SearchFiles(ctx context.Context, userID uuid.UUID, q string, page, pageSize int) (*SearchResult, error) {
+	query, err := ParseSearchQuery(q, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if page < 1 {
+		page = 1
+	}
+	if page > maxSearchPage {
+		page = maxSearchPage
+	}
+	if pageSize <= 0 {
+		pageSize = defaultSearchPageSize
+	}
+	if pageSize > maxSearchPageSize {
+		pageSize = maxSearchPageSize
+	}
+
+	base := s.db.WithContext(ctx).Model(&models.File{}).Where("user_id = ?", userID)
+	for _, clause := range query.clauses {
+		base = base.Where(clause.sql, clause.args...)
+	}
+
+	var totals struct {
+		Total      int64
+		TotalBytes int64
+	}
+	err = base.Session(&gorm.Session{}).
+		Select("COUNT(*) AS total, COALESCE(SUM(size), 0) AS total_bytes").
+		Scan(&totals).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to count search results: %w", err)
+	}
+
+	files := []models.File{}
+	err = base.Session(&gorm.Session{}).
+		Order("size DESC, id ASC").
+		Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Find(&files).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to search files: %w", err)
+	}
+
+	return &SearchResult{
+		Files:      files,
+		Total:      totals.Total,
+		TotalBytes: totals.TotalBytes,
+		Page:       page,
+		PageSize:   pageSize,
+	}, nil
+}
@@ -0,0 +1,203 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/purespace/backend/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	dupSQL := "EXISTS (SELECT 1 FROM files d WHERE d.user_id = files.user_id AND d.sha256 = files.sha256 AND d.id <> files.id AND d.deleted_at IS NULL)"
+
+	tests := []struct {
+		name     string
+		query    string
+		expected []searchClause
+	}{
+		{
+			name:     "Empty query",
+			query:    "   ",
+			expected: nil,
+		},
+		{
+			name:     "Size with unit",
+			query:    "size>100MB",
+			expected: []searchClause{{"size > ?", []interface{}{int64(100 << 20)}}},
+		},
+		{
+			name:     "Size with fractional unit and colon",
+			query:    "size:1.5k",
+			expected: []searchClause{{"size = ?", []interface{}{int64(1536)}}},
+		},
+		{
+			name:     "Size at most",
+			query:    "SIZE<=2gb",
+			expected: []searchClause{{"size <= ?", []interface{}{int64(2 << 30)}}},
+		},
+		{
+			name:     "Mime wildcard",
+			query:    "mime:video/*",
+			expected: []searchClause{{"mime LIKE ?", []interface{}{"video/%"}}},
+		},
+		{
+			name:     "Exact mime",
+			query:    "mime:Image/JPEG",
+			expected: []searchClause{{"mime = ?", []interface{}{"image/jpeg"}}},
+		},
+		{
+			name:     "Device substring escapes LIKE characters",
+			query:    "device:pixel_7",
+			expected: []searchClause{{"device_id ILIKE ?", []interface{}{`%pixel\_7%`}}},
+		},
+		{
+			name:     "Path glob",
+			query:    "path:DCIM/**",
+			expected: []searchClause{{"path_tail ~* ?", []interface{}{"^DCIM/.*$"}}},
+		},
+		{
+			name:     "Quoted path",
+			query:    `path:"My Photos/*.jpg"`,
+			expected: []searchClause{{"path_tail ~* ?", []interface{}{`^My Photos/[^/]*\.jpg$`}}},
+		},
+		{
+			name:     "Extension",
+			query:    "ext:.apk",
+			expected: []searchClause{{"path_tail ILIKE ?", []interface{}{"%.apk"}}},
+		},
+		{
+			name:     "Duplicates only",
+			query:    "dup:true",
+			expected: []searchClause{{dupSQL, nil}},
+		},
+		{
+			name:     "Unique files only",
+			query:    "dup:false",
+			expected: []searchClause{{"NOT " + dupSQL, nil}},
+		},
+		{
+			name:     "Older than a year",
+			query:    "older:1y",
+			expected: []searchClause{{"COALESCE(modified_at, created_at) < ?", []interface{}{now.Add(-365 * 24 * time.Hour)}}},
+		},
+		{
+			name:     "Newer than two weeks",
+			query:    "newer:2w",
+			expected: []searchClause{{"COALESCE(modified_at, created_at) >= ?", []interface{}{now.Add(-14 * 24 * time.Hour)}}},
+		},
+		{
+			name:     "Negated term",
+			query:    "-path:Android/**",
+			expected: []searchClause{{"NOT (path_tail ~* ?)", []interface{}{"^Android/.*$"}}},
+		},
+		{
+			name:     "Bare word matches path",
+			query:    "100%",
+			expected: []searchClause{{"path_tail ILIKE ?", []interface{}{`%100\%%`}}},
+		},
+		{
+			name:  "Combined terms",
+			query: "size>100MB mime:video/* device:pixel",
+			expected: []searchClause{
+				{"size > ?", []interface{}{int64(100 << 20)}},
+				{"mime LIKE ?", []interface{}{"video/%"}},
+				{"device_id ILIKE ?", []interface{}{"%pixel%"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := ParseSearchQuery(tt.query, now)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, query.clauses)
+		})
+	}
+}
+
+func TestParseSearchQuery_Errors(t *testing.T) {
+	tests := []string{
+		"size>lots",
+		"size>10PB",
+		"size>9999999TB",
+		"size<99999999999999999999",
+		"sizeof",
+		"dup:maybe",
+		"older:1x",
+		"older:y",
+		"older:999999y",
+		"newer:9223372036854775807d",
+		"older:99999999999999999999h",
+		"owner:bob",
+		"mime:",
+		`path:"DCIM`,
+	}
+
+	for _, query := range tests {
+		t.Run(query, func(t *testing.T) {
+			_, err := ParseSearchQuery(query, time.Now())
+			if query == "sizeof" {
+				// Not a size term, just a word to look for in paths
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.True(t, errors.Is(err, ErrInvalidSearchQuery))
+		})
+	}
+}
+
+func TestFileService_SearchFiles_PageCap(t *testing.T) {
+	db := newTestDB(t, &models.File{})
+	fileService := NewFileService(db, nil, 0)
+
+	userID := uuid.New()
+	require.NoError(t, db.Create(&models.File{UserID: userID, DeviceID: "pixel", PathTail: "a.jpg", SHA256: strings.Repeat("ab", 32), Size: 100}).Error)
+
+	result, err := fileService.SearchFiles(context.Background(), userID, "", math.MaxInt, maxSearchPageSize)
+	require.NoError(t, err)
+	assert.Equal(t, maxSearchPage, result.Page)
+	assert.Equal(t, int64(1), result.Total)
+	assert.Empty(t, result.Files)
+}
+
+func TestPathGlobRegex(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		matches bool
+	}{
+		{"DCIM/**", "DCIM/Camera/IMG_1.jpg", true},
+		{"DCIM/**", "Pictures/IMG_1.jpg", false},
+		{"DCIM", "DCIM/Camera/IMG_1.jpg", true},
+		{"DCIM", "DCIM", true},
+		{"DCIM", "DCIMX/a.jpg", false},
+		{"DCIM/*.jpg", "DCIM/a.jpg", true},
+		{"DCIM/*.jpg", "DCIM/Camera/a.jpg", false},
+		{"**/*.apk", "app.apk", true},
+		{"**/*.apk", "Download/old/app.apk", true},
+		{"Download/**/*.pdf", "Download/a.pdf", true},
+		{"Download/**/*.pdf", "Download/x/y/a.pdf", true},
+		{"IMG_???.jpg", "IMG_001.jpg", true},
+		{"IMG_???.jpg", "IMG_0001.jpg", false},
+		{"dcim/**", "DCIM/a.jpg", true},
+		{"Фото/*", "Фото/a.jpg", true},
+		{"a+b/(1).txt", "a+b/(1).txt", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.path, func(t *testing.T) {
+			re, err := compilePathGlob(tt.pattern)
+			require.NoError(t, err)
+			assert.Equal(t, tt.matches, re.MatchString(tt.path))
+		})
+	}
+}
@@ -3,6 +3,7 @@ package services
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
@@ -10,23 +11,28 @@ import (
 	"github.com/purespace/backend/internal/models"
 	"github.com/redis/go-redis/v9"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type FileService struct {
 	db    *gorm.DB
 	redis *redis.Client
+	// New devices a free account may sync; zero or less means unlimited
+	freeDeviceLimit int
 }
 
-func NewFileService(db *gorm.DB, redis *redis.Client) *FileService {
+func NewFileService(db *gorm.DB, redis *redis.Client, freeDeviceLimit int) *FileService {
 	return &FileService{
-		db:    db,
-		redis: redis,
+		db:              db,
+		redis:           redis,
+		freeDeviceLimit: freeDeviceLimit,
 	}
 }
 
 type UploadMetadataRequest struct {
 	DeviceID string     `json:"device_id" binding:"required"`
 	Files    []FileItem `json:"files" binding:"required"`
+	Strict   bool       `json:"strict"` // Reject the whole request if any file is invalid
 }
 
 type FileItem struct {
@@ -34,77 +40,325 @@ type FileItem struct {
 	Size     int64  `json:"size" binding:"required"`
 	Mime     string `json:"mime"`
 	PathTail string `json:"path_tail" binding:"required"`
+
+	// Optional media metadata
+	ModifiedAt  *time.Time `json:"modified_at,omitempty"`
+	CapturedAt  *time.Time `json:"captured_at,omitempty"`
+	CameraModel string     `json:"camera_model,omitempty"`
+	HasGPS      *bool      `json:"has_gps,omitempty"`
+	Width       *int       `json:"width,omitempty"`
+	Height      *int       `json:"height,omitempty"`
+	DurationMs  *int64     `json:"duration_ms,omitempty"`
+	Bitrate     *int64     `json:"bitrate,omitempty"`
+	Orientation *int       `json:"orientation,omitempty"`
+}
+
+// toModel converts an uploaded item into a file row for the given device
+func (item FileItem) toModel(userID uuid.UUID, deviceID string) models.File {
+	return models.File{
+		UserID:      userID,
+		DeviceID:    deviceID,
+		PathTail:    item.PathTail,
+		Mime:        item.Mime,
+		Size:        item.Size,
+		SHA256:      item.SHA256,
+		ModifiedAt:  item.ModifiedAt,
+		CapturedAt:  item.CapturedAt,
+		CameraModel: item.CameraModel,
+		HasGPS:      item.HasGPS,
+		Width:       item.Width,
+		Height:      item.Height,
+		DurationMs:  item.DurationMs,
+		Bitrate:     item.Bitrate,
+		Orientation: item.Orientation,
+	}
+}
+
+// fileMetadataColumns are the columns an upload may change on an existing row
+var fileMetadataColumns = []string{
+	"sha256", "size", "mime",
+	"modified_at", "captured_at", "camera_model", "has_gps",
+	"width", "height", "duration_ms", "bitrate", "orientation",
+}
+
+// sameMetadata reports whether a stored row already matches an uploaded row
+func sameMetadata(a, b models.File) bool {
+	return a.SHA256 == b.SHA256 &&
+		a.Size == b.Size &&
+		a.Mime == b.Mime &&
+		a.CameraModel == b.CameraModel &&
+		equalTime(a.ModifiedAt, b.ModifiedAt) &&
+		equalTime(a.CapturedAt, b.CapturedAt) &&
+		equalPtr(a.HasGPS, b.HasGPS) &&
+		equalPtr(a.Width, b.Width) &&
+		equalPtr(a.Height, b.Height) &&
+		equalPtr(a.DurationMs, b.DurationMs) &&
+		equalPtr(a.Bitrate, b.Bitrate) &&
+		equalPtr(a.Orientation, b.Orientation)
+}
+
+func equalPtr[T comparable](a, b *T) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func equalTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// ErrValidationFailed is returned in strict mode when any item is rejected
+var ErrValidationFailed = errors.New("one or more files failed validation")
+
+// Per-item upload outcomes
+const (
+	ItemAccepted  = "accepted"
+	ItemUpdated   = "updated"
+	ItemUnchanged = "unchanged"
+	ItemRejected  = "rejected"
+)
+
+type ItemResult struct {
+	Index    int    `json:"index"`
+	PathTail string `json:"path_tail"`
+	Status   string `json:"status"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+type UploadResult struct {
+	Accepted  int          `json:"accepted"`
+	Updated   int          `json:"updated"`
+	Unchanged int          `json:"unchanged"`
+	Rejected  int          `json:"rejected"`
+	Items     []ItemResult `json:"items"`
 }
 
-// UploadMetadata processes and stores file metadata
-func (s *FileService) UploadMetadata(ctx context.Context, userID uuid.UUID, req *UploadMetadataRequest) error {
+// UploadMetadata validates and stores file metadata, reporting the outcome of every item.
+// In strict mode nothing is stored if any item is rejected.
+func (s *FileService) UploadMetadata(ctx context.Context, userID uuid.UUID, req *UploadMetadataRequest) (*UploadResult, error) {
 	// Validate request
 	if len(req.Files) == 0 {
-		return fmt.Errorf("no files provided")
+		return nil, fmt.Errorf("no files provided")
 	}
 
 	if len(req.Files) > 1000 {
-		return fmt.Errorf("too many files in single request (max 1000)")
+		return nil, fmt.Errorf("too many files in single request (max 1000)")
+	}
+
+	result := &UploadResult{Items: make([]ItemResult, len(req.Files))}
+	valid := make([]FileItem, 0, len(req.Files))
+	seen := make(map[string]bool, len(req.Files))
+
+	for i, file := range req.Files {
+		result.Items[i] = ItemResult{Index: i, PathTail: file.PathTail}
+
+		reason := validateFileItem(&file)
+		if reason == "" && seen[file.PathTail] {
+			reason = "duplicate path_tail in request"
+		}
+		if reason != "" {
+			result.Items[i].Status = ItemRejected
+			result.Items[i].Reason = reason
+			result.Rejected++
+			continue
+		}
+
+		seen[file.PathTail] = true
+		valid = append(valid, file)
+	}
+
+	if req.Strict && result.Rejected > 0 {
+		return result, ErrValidationFailed
 	}
 
-	// Process files in batches
-	const batchSize = 100
-	for i := 0; i < len(req.Files); i += batchSize {
-		end := i + batchSize
-		if end > len(req.Files) {
-			end = len(req.Files)
+	var counts *upsertCounts
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := ensureDevice(tx, userID, req.DeviceID, s.freeDeviceLimit); err != nil {
+			return err
 		}
 
-		batch := req.Files[i:end]
-		if err := s.processBatch(ctx, userID, req.DeviceID, batch); err != nil {
-			return fmt.Errorf("failed to process batch: %w", err)
+		if err := restoreReportedPaths(tx, userID, req.DeviceID, valid); err != nil {
+			return err
 		}
+
+		var err error
+		counts, err = upsertFileItems(tx, userID, req.DeviceID, valid)
+		return err
+	})
+	if errors.Is(err, ErrDeviceLimitReached) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to store metadata: %w", err)
+	}
+
+	for i := range result.Items {
+		if result.Items[i].Status == ItemRejected {
+			continue
+		}
+		result.Items[i].Status = counts.statuses[result.Items[i].PathTail]
 	}
+	result.Accepted = counts.Inserted
+	result.Updated = counts.Updated
+	result.Unchanged = counts.Unchanged
 
 	// Invalidate cache
-	s.invalidateUserCache(ctx, userID)
+	s.statsChanged(ctx, userID)
 
-	return nil
+	return result, nil
+}
+
+// fileNaturalKey identifies a file row: one path per device per user
+var fileNaturalKey = []clause.Column{{Name: "user_id"}, {Name: "device_id"}, {Name: "path_tail"}}
+
+const upsertBatchSize = 500
+
+type upsertCounts struct {
+	Inserted  int `json:"inserted"`
+	Updated   int `json:"updated"`
+	Unchanged int `json:"unchanged"`
+
+	statuses map[string]string // Item status by path
 }
 
-func (s *FileService) processBatch(ctx context.Context, userID uuid.UUID, deviceID string, files []FileItem) error {
-	var dbFiles []models.File
+// upsertFileItems writes items with INSERT ... ON CONFLICT DO UPDATE on the natural key.
+// Content changes at an existing path update that row in place; unchanged rows are not written.
+func upsertFileItems(tx *gorm.DB, userID uuid.UUID, deviceID string, items []FileItem) (*upsertCounts, error) {
+	counts := &upsertCounts{statuses: make(map[string]string, len(items))}
+	items = dedupeByPath(items)
+	if len(items) == 0 {
+		return counts, nil
+	}
+
+	paths := make([]string, len(items))
+	for i, item := range items {
+		paths[i] = item.PathTail
+	}
+
+	existing, err := findFilesByPath(tx, userID, deviceID, paths)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, file := range files {
-		// Validate SHA256 format
-		if len(file.SHA256) != 64 {
-			continue // Skip invalid hashes
+	now := time.Now()
+	var rows []models.File
+	var events []models.FileEvent
+	var unchangedIDs []uint
+
+	for _, item := range items {
+		row := item.toModel(userID, deviceID)
+		row.LastSeenAt = now
+
+		if current, ok := existing[item.PathTail]; ok {
+			if sameMetadata(current, row) {
+				counts.Unchanged++
+				counts.statuses[item.PathTail] = ItemUnchanged
+				unchangedIDs = append(unchangedIDs, current.ID)
+				continue
+			}
+			counts.Updated++
+			counts.statuses[item.PathTail] = ItemUpdated
+			if current.SHA256 != row.SHA256 || current.Size != row.Size {
+				events = append(events, contentChangedEvent(current, row))
+			}
+		} else {
+			counts.Inserted++
+			counts.statuses[item.PathTail] = ItemAccepted
+			row.FirstSeenAt = now
 		}
 
-		dbFile := models.File{
-			UserID:   userID,
-			DeviceID: deviceID,
-			PathTail: file.PathTail,
-			Mime:     file.Mime,
-			Size:     file.Size,
-			SHA256:   file.SHA256,
+		rows = append(rows, row)
+	}
+
+	if err := touchFiles(tx, unchangedIDs, now); err != nil {
+		return nil, err
+	}
+
+	if len(rows) > 0 {
+		err = tx.Clauses(clause.OnConflict{
+			Columns:   fileNaturalKey,
+			DoUpdates: clause.AssignmentColumns(append(fileMetadataColumns, "last_seen_at", "updated_at")),
+		}).CreateInBatches(&rows, upsertBatchSize).Error
+		if err != nil {
+			return nil, fmt.Errorf("failed to upsert files: %w", err)
 		}
+	}
 
-		dbFiles = append(dbFiles, dbFile)
+	for _, row := range rows {
+		if counts.statuses[row.PathTail] == ItemAccepted {
+			events = append(events, firstSeenEvent(row))
+		}
 	}
 
-	if len(dbFiles) == 0 {
-		return nil
+	if err := recordFileEvents(tx, events); err != nil {
+		return nil, err
 	}
 
-	// Upsert files (insert or update on conflict)
-	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
-		for _, file := range dbFiles {
-			result := tx.Where("user_id = ? AND device_id = ? AND sha256 = ? AND path_tail = ?",
-				file.UserID, file.DeviceID, file.SHA256, file.PathTail).
-				FirstOrCreate(&file)
+	return counts, nil
+}
 
-			if result.Error != nil {
-				return result.Error
-			}
+// touchFiles marks files as seen in the device's latest manifest
+func touchFiles(tx *gorm.DB, ids []uint, seenAt time.Time) error {
+	for i := 0; i < len(ids); i += upsertBatchSize {
+		end := i + upsertBatchSize
+		if end > len(ids) {
+			end = len(ids)
 		}
-		return nil
-	})
+
+		err := tx.Model(&models.File{}).Where("id IN ?", ids[i:end]).Update("last_seen_at", seenAt).Error
+		if err != nil {
+			return fmt.Errorf("failed to update last seen: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// findFilesByPath returns the device's rows for the given paths, keyed by path
+func findFilesByPath(tx *gorm.DB, userID uuid.UUID, deviceID string, paths []string) (map[string]models.File, error) {
+	existing := make(map[string]models.File, len(paths))
+
+	for i := 0; i < len(paths); i += upsertBatchSize {
+		end := i + upsertBatchSize
+		if end > len(paths) {
+			end = len(paths)
+		}
+
+		var rows []models.File
+		err := tx.Where("user_id = ? AND device_id = ? AND path_tail IN ?", userID, deviceID, paths[i:end]).
+			Find(&rows).Error
+		if err != nil {
+			return nil, fmt.Errorf("failed to load existing files: %w", err)
+		}
+
+		for _, row := range rows {
+			existing[row.PathTail] = row
+		}
+	}
+
+	return existing, nil
+}
+
+// dedupeByPath keeps the last item for each path, since one upsert statement
+// cannot touch the same row twice
+func dedupeByPath(items []FileItem) []FileItem {
+	index := make(map[string]int, len(items))
+	result := make([]FileItem, 0, len(items))
+
+	for _, item := range items {
+		if i, ok := index[item.PathTail]; ok {
+			result[i] = item
+			continue
+		}
+		index[item.PathTail] = len(result)
+		result = append(result, item)
+	}
+
+	return result
 }
 
 // GetFilesByUser returns all files for a user
@@ -179,6 +433,8 @@ func (s *FileService) GetFileStats(ctx context.Context, userID uuid.UUID) (*mode
 }
 
 func (s *FileService) invalidateUserCache(ctx context.Context, userID uuid.UUID) {
-	cacheKey := fmt.Sprintf("stats:%s", userID.String())
-	s.redis.Del(ctx, cacheKey)
+	s.redis.Del(ctx,
+		fmt.Sprintf("stats:%s", userID.String()),
+		fmt.Sprintf("stats:categories:%s", userID.String()),
+	)
 }
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"encoding/hex"
+	"mime"
+	"path"
+	"strings"
+)
+
+const maxPathTailLength = 1024
+
+// validateFileItem returns why an uploaded item is unacceptable, or "" if it is valid.
+// The hash is lowercased in place, since older clients send it in uppercase.
+func validateFileItem(item *FileItem) string {
+	item.SHA256 = strings.ToLower(item.SHA256)
+	if len(item.SHA256) != 64 {
+		return "sha256 must be 64 hex characters"
+	}
+	if _, err := hex.DecodeString(item.SHA256); err != nil {
+		return "sha256 is not valid hex"
+	}
+
+	if item.Size < 0 {
+		return "size must not be negative"
+	}
+
+	if reason := validatePathTail(item.PathTail); reason != "" {
+		return reason
+	}
+
+	if item.Mime != "" {
+		mediaType, _, err := mime.ParseMediaType(item.Mime)
+		if err != nil || !strings.Contains(mediaType, "/") {
+			return "mime is not a valid media type"
+		}
+	}
+
+	if item.Width != nil && *item.Width < 0 || item.Height != nil && *item.Height < 0 {
+		return "dimensions must not be negative"
+	}
+	if item.Orientation != nil && (*item.Orientation < 1 || *item.Orientation > 8) {
+		return "orientation must be between 1 and 8"
+	}
+
+	return ""
+}
+
+// validatePathTail rejects paths that are absolute, escape their root or are not in clean form
+func validatePathTail(p string) string {
+	switch {
+	case p == "":
+		return "path_tail is required"
+	case len(p) > maxPathTailLength:
+		return "path_tail is too long"
+	case strings.ContainsRune(p, 0):
+		return "path_tail contains a NUL byte"
+	case strings.Contains(p, `\`):
+		return "path_tail must use forward slashes"
+	case strings.HasPrefix(p, "/"):
+		return "path_tail must be relative"
+	}
+
+	for _, segment := range strings.Split(p, "/") {
+		if segment == ".." {
+			return "path_tail must not contain '..'"
+		}
+	}
+
+	if path.Clean(p) != p {
+		return "path_tail is not in clean form"
+	}
+
+	return ""
+}
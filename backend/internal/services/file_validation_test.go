@@ -0,0 +1,97 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateFileItem(t *testing.T) {
+	validHash := strings.Repeat("ab", 32)
+	negative := -1
+	badOrientation := 9
+
+	tests := []struct {
+		name           string
+		item           FileItem
+		expectedReason string
+	}{
+		{
+			name: "Valid item",
+			item: FileItem{SHA256: validHash, Size: 1024, Mime: "image/jpeg", PathTail: "DCIM/Camera/IMG_0001.jpg"},
+		},
+		{
+			name: "Valid item without mime",
+			item: FileItem{SHA256: validHash, Size: 0, PathTail: "Download/notes.txt"},
+		},
+		{
+			name:           "Short hash",
+			item:           FileItem{SHA256: "abc", Size: 1, PathTail: "a.txt"},
+			expectedReason: "sha256 must be 64 hex characters",
+		},
+		{
+			name:           "Non-hex hash",
+			item:           FileItem{SHA256: strings.Repeat("zz", 32), Size: 1, PathTail: "a.txt"},
+			expectedReason: "sha256 is not valid hex",
+		},
+		{
+			name: "Uppercase hash",
+			item: FileItem{SHA256: strings.ToUpper(validHash), Size: 1, PathTail: "a.txt"},
+		},
+		{
+			name:           "Negative size",
+			item:           FileItem{SHA256: validHash, Size: -5, PathTail: "a.txt"},
+			expectedReason: "size must not be negative",
+		},
+		{
+			name:           "Path traversal",
+			item:           FileItem{SHA256: validHash, Size: 1, PathTail: "DCIM/../../etc/passwd"},
+			expectedReason: "path_tail must not contain '..'",
+		},
+		{
+			name:           "Absolute path",
+			item:           FileItem{SHA256: validHash, Size: 1, PathTail: "/sdcard/a.txt"},
+			expectedReason: "path_tail must be relative",
+		},
+		{
+			name:           "Unclean path",
+			item:           FileItem{SHA256: validHash, Size: 1, PathTail: "DCIM//Camera/./a.jpg"},
+			expectedReason: "path_tail is not in clean form",
+		},
+		{
+			name:           "Backslashes",
+			item:           FileItem{SHA256: validHash, Size: 1, PathTail: `DCIM\a.jpg`},
+			expectedReason: "path_tail must use forward slashes",
+		},
+		{
+			name:           "Invalid mime",
+			item:           FileItem{SHA256: validHash, Size: 1, Mime: "jpeg", PathTail: "a.jpg"},
+			expectedReason: "mime is not a valid media type",
+		},
+		{
+			name:           "Negative width",
+			item:           FileItem{SHA256: validHash, Size: 1, PathTail: "a.jpg", Width: &negative},
+			expectedReason: "dimensions must not be negative",
+		},
+		{
+			name:           "Orientation out of range",
+			item:           FileItem{SHA256: validHash, Size: 1, PathTail: "a.jpg", Orientation: &badOrientation},
+			expectedReason: "orientation must be between 1 and 8",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item := tt.item
+			assert.Equal(t, tt.expectedReason, validateFileItem(&item))
+		})
+	}
+}
+
+func TestValidateFileItem_LowercasesHash(t *testing.T) {
+	item := FileItem{SHA256: strings.Repeat("AB", 32), Size: 1, PathTail: "a.txt"}
+
+	assert.Empty(t, validateFileItem(&item))
+	assert.Equal(t, strings.Repeat("ab", 32), item.SHA256)
+}
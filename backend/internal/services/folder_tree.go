@@ -0,0 +1,179 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+var ErrInvalidFolderPath = errors.New("invalid folder path")
+
+const (
+	defaultTreeDepth = 2
+	maxTreeDepth     = 10
+)
+
+// FolderNode is one folder of a user's directory tree. Bytes, FileCount and
+// DuplicateBytes include every file below the folder; OwnBytes counts only the files
+// directly inside it, which is the value a treemap or sunburst should give the node
+// itself. Truncated folders have subfolders beyond the requested depth.
+type FolderNode struct {
+	Name           string        `json:"name"`
+	Path           string        `json:"path"`
+	Bytes          int64         `json:"bytes"`
+	FileCount      int64         `json:"file_count"`
+	DuplicateBytes int64         `json:"duplicate_bytes"`
+	OwnBytes       int64         `json:"own_bytes"`
+	Truncated      bool          `json:"truncated,omitempty"`
+	Children       []*FolderNode `json:"children,omitempty"`
+
+	index map[string]*FolderNode
+}
+
+// treeGroup is the files of one folder at the tree's depth limit, aggregated in SQL.
+// Deep groups are the files further down, which count toward the folder but not its
+// own bytes. Duplicate bytes count every copy of a content hash except the first one
+// stored, so they are what could be freed.
+type treeGroup struct {
+	Folder         string
+	Deep           bool
+	Bytes          int64
+	FileCount      int64
+	DuplicateBytes int64
+}
+
+// GetFolderTree aggregates the user's files into a folder tree rooted at path, depth
+// levels deep. Devices are merged unless deviceID is given.
+func (s *FileService) GetFolderTree(ctx context.Context, userID uuid.UUID, path, deviceID string, depth int) (*FolderNode, error) {
+	root := strings.Trim(path, "/")
+	if root != "" {
+		if reason := validatePathTail(root); reason != "" {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidFolderPath, reason)
+		}
+	}
+	if depth <= 0 {
+		depth = defaultTreeDepth
+	}
+	if depth > maxTreeDepth {
+		depth = maxTreeDepth
+	}
+
+	// dirs is the folders between the root and the file; files are grouped by the first
+	// depth of them. A copy is a duplicate if any of the user's files with the same hash
+	// was stored before it, wherever that file is.
+	start := 1
+	if root != "" {
+		start = utf8.RuneCountInString(root) + 2
+	}
+	filter, filterArgs := folderTreeFilter(userID, root, deviceID)
+	args := append([]interface{}{depth, depth, start}, filterArgs...)
+
+	query := `SELECT folder, deep, SUM(size) AS bytes, COUNT(*) AS file_count,
+		COALESCE(SUM(size) FILTER (WHERE duplicate), 0) AS duplicate_bytes
+	FROM (
+		SELECT f.size,
+			array_to_string(f.dirs[1:LEAST(cardinality(f.dirs) - 1, ?)], '/') AS folder,
+			cardinality(f.dirs) - 1 > ? AS deep,
+			EXISTS (SELECT 1 FROM files d WHERE d.user_id = f.user_id AND d.sha256 = f.sha256
+				AND d.deleted_at IS NULL AND d.id < f.id) AS duplicate
+		FROM (
+			SELECT id, user_id, sha256, size, string_to_array(substr(path_tail, ?), '/') AS dirs
+			FROM files WHERE ` + filter + `
+		) f
+	) t
+	GROUP BY folder, deep`
+
+	var groups []treeGroup
+	if err := s.db.WithContext(ctx).Raw(query, args...).Scan(&groups).Error; err != nil {
+		return nil, fmt.Errorf("failed to load folder tree: %w", err)
+	}
+
+	return buildFolderTree(root, groups), nil
+}
+
+// folderTreeFilter selects the files below root. The prefix is matched with case, like
+// the folder names the tree is grouped by, so every matched file lands under the root.
+func folderTreeFilter(userID uuid.UUID, root, deviceID string) (string, []interface{}) {
+	filter := "user_id = ? AND deleted_at IS NULL"
+	args := []interface{}{userID}
+	if root != "" {
+		filter += ` AND path_tail LIKE ? ESCAPE '\'`
+		args = append(args, escapeLike(root)+"/%")
+	}
+	if deviceID != "" {
+		filter += " AND device_id = ?"
+		args = append(args, deviceID)
+	}
+
+	return filter, args
+}
+
+func buildFolderTree(root string, groups []treeGroup) *FolderNode {
+	tree := &FolderNode{Name: root, Path: root}
+	if i := strings.LastIndex(root, "/"); i >= 0 {
+		tree.Name = root[i+1:]
+	}
+
+	for _, group := range groups {
+		node := tree
+		node.add(group)
+		if group.Folder != "" {
+			for _, dir := range strings.Split(group.Folder, "/") {
+				node = node.child(dir)
+				node.add(group)
+			}
+		}
+
+		if group.Deep {
+			node.Truncated = true
+		} else {
+			node.OwnBytes += group.Bytes
+		}
+	}
+
+	tree.finish()
+	return tree
+}
+
+func (n *FolderNode) add(group treeGroup) {
+	n.Bytes += group.Bytes
+	n.FileCount += group.FileCount
+	n.DuplicateBytes += group.DuplicateBytes
+}
+
+func (n *FolderNode) child(name string) *FolderNode {
+	if n.index == nil {
+		n.index = make(map[string]*FolderNode)
+	}
+	if child, ok := n.index[name]; ok {
+		return child
+	}
+
+	path := name
+	if n.Path != "" {
+		path = n.Path + "/" + name
+	}
+	child := &FolderNode{Name: name, Path: path}
+	n.index[name] = child
+	n.Children = append(n.Children, child)
+	return child
+}
+
+// finish orders every folder's children largest first
+func (n *FolderNode) finish() {
+	sort.Slice(n.Children, func(i, j int) bool {
+		if n.Children[i].Bytes != n.Children[j].Bytes {
+			return n.Children[i].Bytes > n.Children[j].Bytes
+		}
+		return n.Children[i].Name < n.Children[j].Name
+	})
+	for _, child := range n.Children {
+		child.finish()
+	}
+	n.index = nil
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/purespace/backend/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildFolderTree(t *testing.T) {
+	// DCIM/Camera/2023/IMG_0.jpg is below depth 2, so it is grouped into DCIM/Camera as deep
+	groups := []treeGroup{
+		{Folder: "DCIM/Camera", Bytes: 300, FileCount: 2, DuplicateBytes: 200},
+		{Folder: "DCIM/Camera", Deep: true, Bytes: 50, FileCount: 1},
+		{Folder: "DCIM", Bytes: 10, FileCount: 1},
+		{Folder: "Download", Bytes: 1000, FileCount: 1, DuplicateBytes: 1000},
+		{Folder: "", Bytes: 5, FileCount: 1},
+	}
+
+	tree := buildFolderTree("", groups)
+
+	assert.Equal(t, int64(1365), tree.Bytes)
+	assert.Equal(t, int64(6), tree.FileCount)
+	assert.Equal(t, int64(1200), tree.DuplicateBytes)
+	assert.Equal(t, int64(5), tree.OwnBytes)
+	assert.False(t, tree.Truncated)
+	require.Len(t, tree.Children, 2)
+
+	download := tree.Children[0]
+	assert.Equal(t, "Download", download.Path)
+	assert.Equal(t, int64(1000), download.Bytes)
+	assert.Equal(t, int64(1000), download.OwnBytes)
+	assert.Empty(t, download.Children)
+
+	dcim := tree.Children[1]
+	assert.Equal(t, "DCIM", dcim.Name)
+	assert.Equal(t, int64(360), dcim.Bytes)
+	assert.Equal(t, int64(4), dcim.FileCount)
+	assert.Equal(t, int64(200), dcim.DuplicateBytes)
+	assert.Equal(t, int64(10), dcim.OwnBytes)
+	require.Len(t, dcim.Children, 1)
+
+	camera := dcim.Children[0]
+	assert.Equal(t, "DCIM/Camera", camera.Path)
+	assert.Equal(t, int64(350), camera.Bytes)
+	assert.Equal(t, int64(300), camera.OwnBytes)
+	assert.True(t, camera.Truncated, "Camera has a subfolder beyond depth 2")
+	assert.Empty(t, camera.Children)
+}
+
+func TestBuildFolderTree_Subfolder(t *testing.T) {
+	// Folders are relative to the root, one level deep
+	groups := []treeGroup{
+		{Folder: "Camera", Bytes: 100, FileCount: 1},
+		{Folder: "Camera", Deep: true, Bytes: 50, FileCount: 1},
+		{Folder: "Screenshots", Bytes: 20, FileCount: 1},
+	}
+
+	tree := buildFolderTree("DCIM", groups)
+
+	assert.Equal(t, "DCIM", tree.Name)
+	assert.Equal(t, "DCIM", tree.Path)
+	assert.Equal(t, int64(170), tree.Bytes)
+	assert.Equal(t, int64(0), tree.OwnBytes)
+	require.Len(t, tree.Children, 2)
+	assert.Equal(t, "DCIM/Camera", tree.Children[0].Path)
+	assert.True(t, tree.Children[0].Truncated)
+	assert.Equal(t, "DCIM/Screenshots", tree.Children[1].Path)
+	assert.False(t, tree.Children[1].Truncated)
+}
+
+func TestBuildFolderTree_Empty(t *testing.T) {
+	tree := buildFolderTree("Music", nil)
+
+	assert.Equal(t, "Music", tree.Name)
+	assert.Equal(t, int64(0), tree.Bytes)
+	assert.Empty(t, tree.Children)
+}
+
+func TestFolderTreeFilter(t *testing.T) {
+	db := newTestDB(t, &models.File{})
+	// SQLite folds ASCII case in LIKE by default; Postgres never does
+	require.NoError(t, db.Exec("PRAGMA case_sensitive_like = ON").Error)
+
+	userID := uuid.New()
+	var files []models.File
+	for _, path := range []string{
+		"DCIM/Camera/a.jpg",
+		"dcim/b.jpg",
+		"DCIMX/c.jpg",
+		"My_100%/d.jpg",
+		"MyX100%/e.jpg",
+		"My_100%Z/f.jpg",
+		"My_100%/Sub/g.jpg",
+	} {
+		files = append(files, models.File{UserID: userID, DeviceID: "pixel", PathTail: path, SHA256: strings.Repeat("ab", 32), Size: 1})
+	}
+	files = append(files, models.File{UserID: uuid.New(), DeviceID: "pixel", PathTail: "DCIM/other-user.jpg", SHA256: strings.Repeat("ab", 32), Size: 1})
+	require.NoError(t, db.Create(&files).Error)
+
+	tests := []struct {
+		root     string
+		expected []string
+	}{
+		{"DCIM", []string{"DCIM/Camera/a.jpg"}},
+		{"dcim", []string{"dcim/b.jpg"}},
+		{"My_100%", []string{"My_100%/d.jpg", "My_100%/Sub/g.jpg"}},
+		{"", []string{"DCIM/Camera/a.jpg", "dcim/b.jpg", "DCIMX/c.jpg", "My_100%/d.jpg", "MyX100%/e.jpg", "My_100%Z/f.jpg", "My_100%/Sub/g.jpg"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.root, func(t *testing.T) {
+			filter, args := folderTreeFilter(userID, tt.root, "")
+
+			var paths []string
+			require.NoError(t, db.Model(&models.File{}).Where(filter, args...).Order("id ASC").Pluck("path_tail", &paths).Error)
+			assert.Equal(t, tt.expected, paths)
+		})
+	}
+}
@@ -0,0 +1,279 @@
+package services
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/sync/singleflight"
+)
+
+var ErrInvalidGoogleToken = errors.New("invalid Google ID token")
+
+const (
+	DefaultGoogleJWKSURL = "https://www.googleapis.com/oauth2/v3/certs"
+
+	defaultJWKSMaxAge   = time.Hour        // Used when the response has no max-age
+	minJWKSRefresh      = time.Minute      // The keys are refetched at most this often
+	googleTokenLeeway   = 30 * time.Second // Allowed clock skew for exp and iat
+	maxJWKSResponseSize = 1 << 20
+)
+
+// googleIssuers are the values Google puts in the iss claim of its ID tokens
+var googleIssuers = []string{"accounts.google.com", "https://accounts.google.com"}
+
+// GoogleVerifier verifies Google ID tokens locally against Google's signing keys. The keys
+// are cached for as long as the JWKS response allows; a token signed with an unknown key
+// refetches them early, so key rotation is picked up without waiting for the cache to expire.
+// If Google's endpoint is down, keys from the last successful fetch keep being used.
+type GoogleVerifier struct {
+	client    *http.Client
+	jwksURL   string
+	clientIDs []string
+	fetches   singleflight.Group // Concurrent misses share one fetch
+
+	mu        sync.Mutex // Guards the cache below, never held during a fetch
+	keys      map[string]*rsa.PublicKey
+	expiresAt time.Time
+	fetchedAt time.Time
+}
+
+// NewGoogleVerifier accepts tokens issued to any of the given OAuth client IDs. Tests
+// point jwksURL at a local key server.
+func NewGoogleVerifier(jwksURL string, clientIDs []string) *GoogleVerifier {
+	if jwksURL == "" {
+		jwksURL = DefaultGoogleJWKSURL
+	}
+
+	var ids []string
+	for _, id := range clientIDs {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+
+	return &GoogleVerifier{
+		client:    &http.Client{Timeout: 10 * time.Second},
+		jwksURL:   jwksURL,
+		clientIDs: ids,
+	}
+}
+
+// googleIDClaims are the claims of a Google ID token the API uses
+type googleIDClaims struct {
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	Picture       string `json:"picture"`
+	Nonce         string `json:"nonce"`
+	jwt.RegisteredClaims
+}
+
+// Verify checks the token's signature, audience, issuer and expiry, and that its nonce is
+// the one the client sent to Google when signing in. An empty nonce accepts only tokens
+// without one.
+func (v *GoogleVerifier) Verify(ctx context.Context, idToken, nonce string) (*GoogleTokenInfo, error) {
+	if len(v.clientIDs) == 0 {
+		return nil, fmt.Errorf("%w: no Google client IDs are configured", ErrInvalidGoogleToken)
+	}
+
+	var claims googleIDClaims
+	_, err := jwt.ParseWithClaims(idToken, &claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return v.key(ctx, kid)
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(googleTokenLeeway),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidGoogleToken, err)
+	}
+
+	if !containsString(googleIssuers, claims.Issuer) {
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidGoogleToken, claims.Issuer)
+	}
+	if !audienceMatches(claims.Audience, v.clientIDs) {
+		return nil, fmt.Errorf("%w: token was issued to another client", ErrInvalidGoogleToken)
+	}
+	if claims.Nonce != nonce {
+		return nil, fmt.Errorf("%w: nonce does not match", ErrInvalidGoogleToken)
+	}
+	if !claims.EmailVerified {
+		return nil, fmt.Errorf("%w: email not verified", ErrInvalidGoogleToken)
+	}
+
+	return &GoogleTokenInfo{
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Name:          claims.Name,
+		Picture:       claims.Picture,
+		Sub:           claims.Subject,
+	}, nil
+}
+
+// key returns the signing key with the given ID, fetching the key set when the cache has
+// expired or, at most once a minute, when the ID is unknown. A cached key is still used
+// when the fetch fails.
+func (v *GoogleVerifier) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	now := time.Now()
+
+	v.mu.Lock()
+	key, cached := v.keys[kid]
+	fresh := now.Before(v.expiresAt)
+	refetch := !fresh || now.Sub(v.fetchedAt) >= minJWKSRefresh
+	v.mu.Unlock()
+
+	if cached && fresh {
+		return key, nil
+	}
+
+	if refetch {
+		_, err, _ := v.fetches.Do(v.jwksURL, func() (interface{}, error) {
+			return nil, v.fetchKeys(ctx)
+		})
+		if err != nil {
+			if cached {
+				return key, nil
+			}
+			return nil, err
+		}
+	}
+
+	v.mu.Lock()
+	key, ok := v.keys[kid]
+	v.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// jwk is one RSA key of a JSON Web Key Set
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+func (v *GoogleVerifier) fetchKeys(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.jwksURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to build JWKS request: %w", err)
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch Google signing keys: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch Google signing keys: status %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxJWKSResponseSize)).Decode(&set); err != nil {
+		return fmt.Errorf("failed to decode Google signing keys: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		key, err := rsaPublicKey(k)
+		if err != nil {
+			return fmt.Errorf("failed to parse Google signing key %q: %w", k.Kid, err)
+		}
+		keys[k.Kid] = key
+	}
+
+	now := time.Now()
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.keys = keys
+	v.fetchedAt = now
+	v.expiresAt = now.Add(jwksMaxAge(resp.Header))
+	return nil
+}
+
+func rsaPublicKey(k jwk) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("invalid modulus: %w", err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exponent: %w", err)
+	}
+
+	exponent := new(big.Int).SetBytes(e)
+	if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+		return nil, errors.New("exponent too large")
+	}
+
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+}
+
+// jwksMaxAge is how long a key set response may be cached: its max-age minus its Age, but
+// at least minJWKSRefresh so a no-cache response does not cost a fetch per token
+func jwksMaxAge(header http.Header) time.Duration {
+	maxAge := time.Duration(-1)
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(name) {
+		case "no-store", "no-cache":
+			return minJWKSRefresh
+		case "max-age":
+			if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+				maxAge = time.Duration(seconds) * time.Second
+			}
+		}
+	}
+	if maxAge < 0 {
+		return defaultJWKSMaxAge
+	}
+
+	if age, err := strconv.Atoi(header.Get("Age")); err == nil && age > 0 {
+		maxAge -= time.Duration(age) * time.Second
+	}
+	if maxAge < minJWKSRefresh {
+		return minJWKSRefresh
+	}
+	return maxAge
+}
+
+func audienceMatches(audience jwt.ClaimStrings, clientIDs []string) bool {
+	for _, aud := range audience {
+		if containsString(clientIDs, aud) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,230 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testClientID = "client-1.apps.googleusercontent.com"
+
+// keyServer serves a JWKS document for the keys it holds and counts the fetches
+type keyServer struct {
+	*httptest.Server
+	keys         atomic.Value // map[string]*rsa.PrivateKey
+	cacheControl atomic.Value // string
+	failing      atomic.Bool
+	fetches      atomic.Int32
+}
+
+func newKeyServer(t *testing.T, keys map[string]*rsa.PrivateKey) *keyServer {
+	ks := &keyServer{}
+	ks.keys.Store(keys)
+	ks.cacheControl.Store("public, max-age=3600")
+	ks.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ks.fetches.Add(1)
+		if ks.failing.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		set := struct {
+			Keys []jwk `json:"keys"`
+		}{}
+		for kid, key := range ks.keys.Load().(map[string]*rsa.PrivateKey) {
+			set.Keys = append(set.Keys, jwk{
+				Kid: kid,
+				Kty: "RSA",
+				N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+
+		w.Header().Set("Cache-Control", ks.cacheControl.Load().(string))
+		json.NewEncoder(w).Encode(set)
+	}))
+	t.Cleanup(ks.Close)
+	return ks
+}
+
+func newTestKey(t *testing.T) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	return key
+}
+
+func signIDToken(t *testing.T, key *rsa.PrivateKey, kid string, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+	return signed
+}
+
+func validClaims() jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":            "https://accounts.google.com",
+		"aud":            testClientID,
+		"sub":            "1234567890",
+		"email":          "user@example.com",
+		"email_verified": true,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+		"nonce":          "n-0S6_WzA2Mj",
+	}
+}
+
+func TestGoogleVerifier_Verify(t *testing.T) {
+	key := newTestKey(t)
+	server := newKeyServer(t, map[string]*rsa.PrivateKey{"key-1": key})
+	verifier := NewGoogleVerifier(server.URL, []string{"other-client", testClientID})
+
+	tests := []struct {
+		name    string
+		modify  func(jwt.MapClaims)
+		nonce   string
+		wantErr bool
+	}{
+		{"Valid", func(jwt.MapClaims) {}, "n-0S6_WzA2Mj", false},
+		{"Issuer without scheme", func(c jwt.MapClaims) { c["iss"] = "accounts.google.com" }, "n-0S6_WzA2Mj", false},
+		{"Other audience", func(c jwt.MapClaims) { c["aud"] = "attacker-client" }, "n-0S6_WzA2Mj", true},
+		{"Other issuer", func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }, "n-0S6_WzA2Mj", true},
+		{"Expired", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, "n-0S6_WzA2Mj", true},
+		{"Missing expiry", func(c jwt.MapClaims) { delete(c, "exp") }, "n-0S6_WzA2Mj", true},
+		{"Wrong nonce", func(jwt.MapClaims) {}, "other-nonce", true},
+		{"Missing nonce", func(jwt.MapClaims) {}, "", true},
+		{"Unverified email", func(c jwt.MapClaims) { c["email_verified"] = false }, "n-0S6_WzA2Mj", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := validClaims()
+			tt.modify(claims)
+
+			info, err := verifier.Verify(context.Background(), signIDToken(t, key, "key-1", claims), tt.nonce)
+			if tt.wantErr {
+				assert.True(t, errors.Is(err, ErrInvalidGoogleToken), "error: %v", err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "user@example.com", info.Email)
+			assert.Equal(t, "1234567890", info.Sub)
+		})
+	}
+
+	// Every token above was checked against the cached key set
+	assert.Equal(t, int32(1), server.fetches.Load())
+}
+
+func TestGoogleVerifier_RejectsForeignKeys(t *testing.T) {
+	server := newKeyServer(t, map[string]*rsa.PrivateKey{"key-1": newTestKey(t)})
+	verifier := NewGoogleVerifier(server.URL, []string{testClientID})
+
+	// Signed with a key that has a published key ID but is not the published key
+	_, err := verifier.Verify(context.Background(), signIDToken(t, newTestKey(t), "key-1", validClaims()), "n-0S6_WzA2Mj")
+	assert.True(t, errors.Is(err, ErrInvalidGoogleToken))
+
+	// HMAC tokens are rejected even if the secret is a published key
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, validClaims())
+	token.Header["kid"] = "key-1"
+	signed, err := token.SignedString([]byte("secret"))
+	require.NoError(t, err)
+	_, err = verifier.Verify(context.Background(), signed, "n-0S6_WzA2Mj")
+	assert.True(t, errors.Is(err, ErrInvalidGoogleToken))
+}
+
+func TestGoogleVerifier_KeyRotation(t *testing.T) {
+	oldKey, newKey := newTestKey(t), newTestKey(t)
+	server := newKeyServer(t, map[string]*rsa.PrivateKey{"old": oldKey})
+	verifier := NewGoogleVerifier(server.URL, []string{testClientID})
+
+	_, err := verifier.Verify(context.Background(), signIDToken(t, oldKey, "old", validClaims()), "n-0S6_WzA2Mj")
+	require.NoError(t, err)
+
+	// Google starts signing with a new key while the cached set is still fresh
+	server.keys.Store(map[string]*rsa.PrivateKey{"old": oldKey, "new": newKey})
+	verifier.fetchedAt = verifier.fetchedAt.Add(-minJWKSRefresh)
+
+	_, err = verifier.Verify(context.Background(), signIDToken(t, newKey, "new", validClaims()), "n-0S6_WzA2Mj")
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), server.fetches.Load())
+
+	// Unknown key IDs do not refetch again within a minute
+	_, err = verifier.Verify(context.Background(), signIDToken(t, newKey, "unknown", validClaims()), "n-0S6_WzA2Mj")
+	assert.Error(t, err)
+	assert.Equal(t, int32(2), server.fetches.Load())
+}
+
+func TestGoogleVerifier_FetchFailure(t *testing.T) {
+	key := newTestKey(t)
+	server := newKeyServer(t, map[string]*rsa.PrivateKey{"key-1": key})
+	verifier := NewGoogleVerifier(server.URL, []string{testClientID})
+
+	_, err := verifier.Verify(context.Background(), signIDToken(t, key, "key-1", validClaims()), "n-0S6_WzA2Mj")
+	require.NoError(t, err)
+
+	// The cache expires while Google's endpoint is down: the cached key is still used
+	server.failing.Store(true)
+	verifier.expiresAt = time.Now().Add(-time.Second)
+	verifier.fetchedAt = verifier.expiresAt.Add(-time.Hour)
+
+	_, err = verifier.Verify(context.Background(), signIDToken(t, key, "key-1", validClaims()), "n-0S6_WzA2Mj")
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), server.fetches.Load())
+
+	// Keys that were never fetched cannot be trusted
+	_, err = verifier.Verify(context.Background(), signIDToken(t, newTestKey(t), "key-2", validClaims()), "n-0S6_WzA2Mj")
+	assert.True(t, errors.Is(err, ErrInvalidGoogleToken))
+}
+
+func TestGoogleVerifier_NoCacheResponse(t *testing.T) {
+	key := newTestKey(t)
+	server := newKeyServer(t, map[string]*rsa.PrivateKey{"key-1": key})
+	server.cacheControl.Store("no-cache, no-store")
+	verifier := NewGoogleVerifier(server.URL, []string{testClientID})
+
+	for i := 0; i < 3; i++ {
+		_, err := verifier.Verify(context.Background(), signIDToken(t, key, "key-1", validClaims()), "n-0S6_WzA2Mj")
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int32(1), server.fetches.Load())
+}
+
+func TestJWKSMaxAge(t *testing.T) {
+	tests := []struct {
+		name         string
+		cacheControl string
+		age          string
+		expected     time.Duration
+	}{
+		{"Max age", "public, max-age=19204, must-revalidate, no-transform", "", 19204 * time.Second},
+		{"Minus age", "public, max-age=3600", "600", 3000 * time.Second},
+		{"Older than max age", "max-age=60", "120", minJWKSRefresh},
+		{"Below minimum", "max-age=5", "", minJWKSRefresh},
+		{"No store", "no-store", "", minJWKSRefresh},
+		{"Missing", "", "", defaultJWKSMaxAge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			header.Set("Cache-Control", tt.cacheControl)
+			header.Set("Age", tt.age)
+			assert.Equal(t, tt.expected, jwksMaxAge(header))
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// Lease is a lock in Redis that one API replica holds at a time, so a background job runs
+// on one replica only. The holder renews it on every run; if the holder dies, the lease
+// expires after its TTL and another replica takes over.
+type Lease struct {
+	redis *redis.Client
+	key   string
+	owner string
+	ttl   time.Duration
+}
+
+// acquireLease takes the lease if it is free and renews it if this owner already holds it
+var acquireLease = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return 1
+end
+return 0
+`)
+
+// releaseLease gives the lease up, but only if this owner still holds it
+var releaseLease = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+func NewLease(redis *redis.Client, name string, ttl time.Duration) *Lease {
+	return &Lease{
+		redis: redis,
+		key:   "lease:" + name,
+		owner: uuid.NewString(),
+		ttl:   ttl,
+	}
+}
+
+// Acquire takes or renews the lease and reports whether this replica holds it
+func (l *Lease) Acquire(ctx context.Context) (bool, error) {
+	held, err := acquireLease.Run(ctx, l.redis, []string{l.key}, l.owner, l.ttl.Milliseconds()).Int64()
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire lease: %w", err)
+	}
+
+	return held == 1, nil
+}
+
+// Release gives the lease up so another replica can take over right away
+func (l *Lease) Release(ctx context.Context) error {
+	if err := releaseLease.Run(ctx, l.redis, []string{l.key}, l.owner).Err(); err != nil {
+		return fmt.Errorf("failed to release lease: %w", err)
+	}
+
+	return nil
+}
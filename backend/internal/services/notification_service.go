@@ -0,0 +1,455 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/purespace/backend/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrInvalidNotificationSettings = errors.New("invalid notification settings")
+
+// Notification kinds
+const (
+	NotificationCleanupCompleted     = "cleanup_completed"
+	NotificationDuplicatesFound      = "duplicates_found"
+	NotificationSubscriptionExpiring = "subscription_expiring"
+)
+
+// Notification states
+const (
+	NotificationPending = "pending"
+	NotificationSent    = "sent"
+	NotificationFailed  = "failed"
+	NotificationSkipped = "skipped" // Push is off or no device has a push token
+)
+
+const (
+	maxNotificationBatch      = 100
+	maxNotificationAttempts   = 5
+	notificationClaimTimeout  = 5 * time.Minute
+	notificationRetryBase     = time.Minute
+	notificationRetryMax      = time.Hour
+	defaultNotificationLimit  = 50
+	maxNotificationLimit      = 500
+	duplicateGrowthThreshold  = 500 << 20 // Growth over the previous day that triggers duplicates_found
+	subscriptionExpiryWarning = 3 * 24 * time.Hour
+)
+
+// NotificationService dispatches the notification outbox. Services write notifications
+// with enqueueNotification in the transaction of the change they report, so a
+// notification is only sent for a change that was committed.
+type NotificationService struct {
+	db       *gorm.DB
+	notifier Notifier
+}
+
+func NewNotificationService(db *gorm.DB, notifier Notifier) *NotificationService {
+	return &NotificationService{
+		db:       db,
+		notifier: notifier,
+	}
+}
+
+type NotificationSettingsRequest struct {
+	PushEnabled bool   `json:"push_enabled"`
+	QuietStart  string `json:"quiet_start"`
+	QuietEnd    string `json:"quiet_end"`
+	Timezone    string `json:"timezone"`
+}
+
+// GetSettings returns the user's notification settings, or the defaults if none were saved
+func (s *NotificationService) GetSettings(ctx context.Context, userID uuid.UUID) (*models.NotificationSettings, error) {
+	return loadNotificationSettings(s.db.WithContext(ctx), userID)
+}
+
+// UpdateSettings replaces the user's notification settings
+func (s *NotificationService) UpdateSettings(ctx context.Context, userID uuid.UUID, req *NotificationSettingsRequest) (*models.NotificationSettings, error) {
+	settings := &models.NotificationSettings{
+		UserID:      userID,
+		PushEnabled: req.PushEnabled,
+		QuietStart:  strings.TrimSpace(req.QuietStart),
+		QuietEnd:    strings.TrimSpace(req.QuietEnd),
+		Timezone:    strings.TrimSpace(req.Timezone),
+	}
+	if settings.Timezone == "" {
+		settings.Timezone = "UTC"
+	}
+	if err := validateQuietHours(settings); err != nil {
+		return nil, err
+	}
+
+	err := s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"push_enabled", "quiet_start", "quiet_end", "timezone", "updated_at"}),
+	}).Create(settings).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to save notification settings: %w", err)
+	}
+
+	return loadNotificationSettings(s.db.WithContext(ctx), userID)
+}
+
+// ListNotifications returns the user's notifications, newest first
+func (s *NotificationService) ListNotifications(ctx context.Context, userID uuid.UUID, limit int) ([]models.Notification, error) {
+	if limit <= 0 {
+		limit = defaultNotificationLimit
+	}
+	if limit > maxNotificationLimit {
+		limit = maxNotificationLimit
+	}
+
+	notifications := []models.Notification{}
+	err := s.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Limit(limit).
+		Find(&notifications).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list notifications: %w", err)
+	}
+
+	return notifications, nil
+}
+
+// Dispatch sends the notifications that are due and returns how many it handled. Due
+// notifications are claimed with SKIP LOCKED and pushed back by the claim timeout, so
+// replicas dispatching at the same time do not send one twice.
+func (s *NotificationService) Dispatch(ctx context.Context) (int, error) {
+	var due []models.Notification
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", NotificationPending, now).
+			Order("next_attempt_at ASC").
+			Limit(maxNotificationBatch).
+			Find(&due).Error
+		if err != nil || len(due) == 0 {
+			return err
+		}
+
+		ids := make([]uuid.UUID, len(due))
+		for i := range due {
+			ids[i] = due[i].ID
+		}
+		return tx.Model(&models.Notification{}).Where("id IN ?", ids).Update("next_attempt_at", now.Add(notificationClaimTimeout)).Error
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to claim notifications: %w", err)
+	}
+
+	for i := range due {
+		if err := s.deliver(ctx, &due[i]); err != nil {
+			return i, err
+		}
+	}
+
+	return len(due), nil
+}
+
+// deliver pushes one claimed notification to every device of its user that has a push
+// token and records the outcome. It is sent once every device has it or has dropped its
+// token; until then failed devices are retried. It returns an error only when the outcome
+// could not be saved.
+func (s *NotificationService) deliver(ctx context.Context, n *models.Notification) error {
+	db := s.db.WithContext(ctx)
+	now := time.Now()
+
+	settings, err := loadNotificationSettings(db, n.UserID)
+	if err != nil {
+		return err
+	}
+	if !settings.PushEnabled {
+		return s.finish(ctx, n, NotificationSkipped, "push notifications are disabled", now)
+	}
+	if until, quiet := quietUntil(settings, now); quiet {
+		return s.update(ctx, n, map[string]interface{}{"next_attempt_at": until})
+	}
+
+	var devices []models.Device
+	if err := db.Where("user_id = ? AND push_token <> ''", n.UserID).Find(&devices).Error; err != nil {
+		return fmt.Errorf("failed to get push tokens: %w", err)
+	}
+
+	// Devices that got the notification on an earlier attempt are not sent it again
+	delivered := make(map[string]bool, len(n.DeliveredTo))
+	for _, deviceID := range n.DeliveredTo {
+		delivered[deviceID] = true
+	}
+
+	var sendErr error
+	for _, device := range devices {
+		if delivered[device.DeviceID] {
+			continue
+		}
+
+		err := s.notifier.Send(ctx, &PushMessage{
+			DeviceID: device.DeviceID,
+			Token:    device.PushToken,
+			Title:    n.Title,
+			Body:     n.Body,
+			Data:     n.Data,
+		})
+		switch {
+		case err == nil:
+			delivered[device.DeviceID] = true
+			n.DeliveredTo = append(n.DeliveredTo, device.DeviceID)
+		case errors.Is(err, ErrInvalidPushToken):
+			// Only clear the token if the device has not registered a new one meanwhile
+			err := db.Model(&models.Device{}).
+				Where("user_id = ? AND device_id = ? AND push_token = ?", device.UserID, device.DeviceID, device.PushToken).
+				Update("push_token", "").Error
+			if err != nil {
+				return fmt.Errorf("failed to clear push token: %w", err)
+			}
+		default:
+			sendErr = err
+		}
+	}
+
+	switch {
+	case sendErr == nil && len(n.DeliveredTo) > 0:
+		return s.finish(ctx, n, NotificationSent, "", now)
+	case sendErr == nil:
+		return s.finish(ctx, n, NotificationSkipped, "no device has a push token", now)
+	}
+
+	// Some device failed: retry just the devices that have not got it yet
+	attempts := n.Attempts + 1
+	if attempts >= maxNotificationAttempts {
+		return s.update(ctx, n, map[string]interface{}{
+			"status":       NotificationFailed,
+			"attempts":     attempts,
+			"delivered_to": n.DeliveredTo,
+			"last_error":   sendErr.Error(),
+		})
+	}
+	return s.update(ctx, n, map[string]interface{}{
+		"attempts":        attempts,
+		"next_attempt_at": now.Add(notificationBackoff(attempts)),
+		"delivered_to":    n.DeliveredTo,
+		"last_error":      sendErr.Error(),
+	})
+}
+
+func (s *NotificationService) finish(ctx context.Context, n *models.Notification, status, reason string, now time.Time) error {
+	updates := map[string]interface{}{
+		"status":     status,
+		"last_error": reason,
+	}
+	if status == NotificationSent {
+		updates["sent_at"] = now
+	}
+	return s.update(ctx, n, updates)
+}
+
+func (s *NotificationService) update(ctx context.Context, n *models.Notification, updates map[string]interface{}) error {
+	if err := s.db.WithContext(ctx).Model(&models.Notification{}).Where("id = ?", n.ID).Updates(updates).Error; err != nil {
+		return fmt.Errorf("failed to update notification: %w", err)
+	}
+	return nil
+}
+
+// EnqueueExpiringSubscriptions notifies users whose premium subscription expires soon.
+// The notification is keyed by the expiry, so each renewal period warns once.
+func (s *NotificationService) EnqueueExpiringSubscriptions(ctx context.Context, now time.Time) (int, error) {
+	var subscriptions []models.Subscription
+	err := s.db.WithContext(ctx).
+		Where("tier = ? AND expires_at > ? AND expires_at <= ?", "premium", now, now.Add(subscriptionExpiryWarning)).
+		Find(&subscriptions).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to get expiring subscriptions: %w", err)
+	}
+
+	for _, sub := range subscriptions {
+		err := enqueueNotification(s.db.WithContext(ctx), &models.Notification{
+			UserID:   sub.UserID,
+			Kind:     NotificationSubscriptionExpiring,
+			DedupKey: fmt.Sprintf("%s:%d", NotificationSubscriptionExpiring, sub.ExpiresAt.Unix()),
+			Title:    "Your premium subscription is ending",
+			Body:     fmt.Sprintf("Premium expires on %s. Renew to keep cleanup policies and scheduled scans.", sub.ExpiresAt.UTC().Format("January 2")),
+			Data:     models.StringMap{"expires_at": sub.ExpiresAt.UTC().Format(time.RFC3339)},
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(subscriptions), nil
+}
+
+// enqueueNotification writes a notification to the outbox. A notification with the same
+// dedup key as an earlier one for the user is dropped.
+func enqueueNotification(tx *gorm.DB, n *models.Notification) error {
+	n.Status = NotificationPending
+	if n.NextAttemptAt.IsZero() {
+		n.NextAttemptAt = time.Now()
+	}
+
+	err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "dedup_key"}},
+		DoNothing: true,
+	}).Create(n).Error
+	if err != nil {
+		return fmt.Errorf("failed to enqueue notification: %w", err)
+	}
+
+	return nil
+}
+
+// notifyPlanCompleted tells the user how much a finished plan freed
+func notifyPlanCompleted(tx *gorm.DB, plan *models.CleanupPlan, report *models.Report) error {
+	body := fmt.Sprintf("Freed %s by deleting %d files", formatBytes(report.BytesSaved), report.ItemsDeleted)
+
+	deviceIDs := make(map[string]bool)
+	for _, item := range plan.Items {
+		deviceIDs[item.DeviceID] = true
+	}
+	if len(deviceIDs) == 1 {
+		var device models.Device
+		err := tx.Select("name").Where("user_id = ? AND device_id = ?", plan.UserID, plan.Items[0].DeviceID).Take(&device).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("failed to get device: %w", err)
+		}
+		if device.Name != "" {
+			body += " on " + device.Name
+		}
+	}
+
+	return enqueueNotification(tx, &models.Notification{
+		UserID:   plan.UserID,
+		Kind:     NotificationCleanupCompleted,
+		DedupKey: fmt.Sprintf("%s:%s", NotificationCleanupCompleted, plan.ID),
+		Title:    "Cleanup finished",
+		Body:     body,
+		Data:     models.StringMap{"plan_id": plan.ID.String(), "report_id": fmt.Sprint(report.ID)},
+	})
+}
+
+// notifyDuplicateGrowth tells the user when their duplicates grew by more than the
+// threshold since the last snapshot before day, at most once a day. It runs in the
+// transaction that records the snapshot, not the one that changed the files.
+func notifyDuplicateGrowth(tx *gorm.DB, userID uuid.UUID, day time.Time, duplicateBytes int64) error {
+	var previous models.StorageSnapshot
+	err := tx.Where("user_id = ? AND device_id = ? AND day < ?", userID, "", day).
+		Order("day DESC").
+		Take(&previous).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get previous snapshot: %w", err)
+	}
+
+	growth := duplicateBytes - previous.DuplicateBytes
+	if growth < duplicateGrowthThreshold {
+		return nil
+	}
+
+	return enqueueNotification(tx, &models.Notification{
+		UserID:   userID,
+		Kind:     NotificationDuplicatesFound,
+		DedupKey: fmt.Sprintf("%s:%s", NotificationDuplicatesFound, day.Format("2006-01-02")),
+		Title:    "New duplicates found",
+		Body:     fmt.Sprintf("Duplicates now take %s, %s more than before. Review them to free space.", formatBytes(duplicateBytes), formatBytes(growth)),
+		Data:     models.StringMap{"duplicate_bytes": fmt.Sprint(duplicateBytes)},
+	})
+}
+
+func loadNotificationSettings(db *gorm.DB, userID uuid.UUID) (*models.NotificationSettings, error) {
+	var settings models.NotificationSettings
+	err := db.Where("user_id = ?", userID).First(&settings).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &models.NotificationSettings{
+			UserID:      userID,
+			PushEnabled: true,
+			Timezone:    "UTC",
+		}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get notification settings: %w", err)
+	}
+
+	return &settings, nil
+}
+
+func validateQuietHours(settings *models.NotificationSettings) error {
+	if _, err := time.LoadLocation(settings.Timezone); err != nil {
+		return fmt.Errorf("%w: unknown timezone %q", ErrInvalidNotificationSettings, settings.Timezone)
+	}
+	if settings.QuietStart == "" && settings.QuietEnd == "" {
+		return nil
+	}
+	if settings.QuietStart == "" || settings.QuietEnd == "" {
+		return fmt.Errorf("%w: quiet_start and quiet_end must be set together", ErrInvalidNotificationSettings)
+	}
+	if settings.QuietStart == settings.QuietEnd {
+		return fmt.Errorf("%w: quiet hours must not be empty", ErrInvalidNotificationSettings)
+	}
+	for _, value := range []string{settings.QuietStart, settings.QuietEnd} {
+		if _, err := time.Parse("15:04", value); err != nil {
+			return fmt.Errorf("%w: time %q must be HH:MM", ErrInvalidNotificationSettings, value)
+		}
+	}
+
+	return nil
+}
+
+// quietUntil reports whether now falls in the user's quiet hours and, if so, when they
+// end. Quiet hours ending before they start span midnight.
+func quietUntil(settings *models.NotificationSettings, now time.Time) (time.Time, bool) {
+	if settings.QuietStart == "" || settings.QuietEnd == "" {
+		return time.Time{}, false
+	}
+	loc, err := time.LoadLocation(settings.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	start, err1 := time.Parse("15:04", settings.QuietStart)
+	end, err2 := time.Parse("15:04", settings.QuietEnd)
+	if err1 != nil || err2 != nil {
+		return time.Time{}, false
+	}
+
+	local := now.In(loc)
+	at := func(clock time.Time, days int) time.Time {
+		return time.Date(local.Year(), local.Month(), local.Day()+days, clock.Hour(), clock.Minute(), 0, 0, loc)
+	}
+
+	startToday, endToday := at(start, 0), at(end, 0)
+	if startToday.Before(endToday) {
+		if !local.Before(startToday) && local.Before(endToday) {
+			return endToday, true
+		}
+		return time.Time{}, false
+	}
+
+	// Overnight: quiet from start until midnight, and from midnight until end
+	if !local.Before(startToday) {
+		return at(end, 1), true
+	}
+	if local.Before(endToday) {
+		return endToday, true
+	}
+	return time.Time{}, false
+}
+
+// notificationBackoff is the delay before the given attempt is retried, doubling from
+// a minute up to an hour
+func notificationBackoff(attempts int) time.Duration {
+	delay := notificationRetryBase
+	for i := 1; i < attempts && delay < notificationRetryMax; i++ {
+		delay *= 2
+	}
+	if delay > notificationRetryMax {
+		delay = notificationRetryMax
+	}
+	return delay
+}
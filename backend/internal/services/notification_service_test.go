@@ -0,0 +1,184 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/purespace/backend/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestQuietUntil(t *testing.T) {
+	day := func(hour, minute int) time.Time {
+		return time.Date(2024, 6, 1, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name      string
+		start     string
+		end       string
+		now       time.Time
+		wantQuiet bool
+		wantUntil time.Time
+	}{
+		{"No quiet hours", "", "", day(23, 0), false, time.Time{}},
+		{"Daytime inside", "12:00", "14:00", day(13, 0), true, day(14, 0)},
+		{"Daytime at end", "12:00", "14:00", day(14, 0), false, time.Time{}},
+		{"Daytime outside", "12:00", "14:00", day(9, 0), false, time.Time{}},
+		{"Overnight before midnight", "22:00", "07:00", day(23, 30), true, time.Date(2024, 6, 2, 7, 0, 0, 0, time.UTC)},
+		{"Overnight after midnight", "22:00", "07:00", day(3, 0), true, day(7, 0)},
+		{"Overnight outside", "22:00", "07:00", day(12, 0), false, time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := &models.NotificationSettings{QuietStart: tt.start, QuietEnd: tt.end, Timezone: "UTC"}
+			until, quiet := quietUntil(settings, tt.now)
+			assert.Equal(t, tt.wantQuiet, quiet)
+			assert.True(t, tt.wantUntil.Equal(until), "until %v", until)
+		})
+	}
+}
+
+func TestQuietUntil_Timezone(t *testing.T) {
+	settings := &models.NotificationSettings{QuietStart: "22:00", QuietEnd: "07:00", Timezone: "Europe/Berlin"}
+
+	// 21:30 UTC is 23:30 in Berlin in summer; quiet hours end at 05:00 UTC
+	until, quiet := quietUntil(settings, time.Date(2024, 6, 1, 21, 30, 0, 0, time.UTC))
+	assert.True(t, quiet)
+	assert.Equal(t, time.Date(2024, 6, 2, 5, 0, 0, 0, time.UTC), until.UTC())
+}
+
+func TestValidateQuietHours(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings models.NotificationSettings
+		wantErr  bool
+	}{
+		{"None", models.NotificationSettings{Timezone: "UTC"}, false},
+		{"Overnight", models.NotificationSettings{QuietStart: "22:00", QuietEnd: "07:00", Timezone: "UTC"}, false},
+		{"Only start", models.NotificationSettings{QuietStart: "22:00", Timezone: "UTC"}, true},
+		{"Empty window", models.NotificationSettings{QuietStart: "22:00", QuietEnd: "22:00", Timezone: "UTC"}, true},
+		{"Bad time", models.NotificationSettings{QuietStart: "22:00", QuietEnd: "7pm", Timezone: "UTC"}, true},
+		{"Bad timezone", models.NotificationSettings{Timezone: "Mars/Olympus"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateQuietHours(&tt.settings)
+			assert.Equal(t, tt.wantErr, err != nil)
+		})
+	}
+}
+
+func TestNotificationBackoff(t *testing.T) {
+	assert.Equal(t, time.Minute, notificationBackoff(1))
+	assert.Equal(t, 2*time.Minute, notificationBackoff(2))
+	assert.Equal(t, 8*time.Minute, notificationBackoff(4))
+	assert.Equal(t, time.Hour, notificationBackoff(20))
+}
+
+func TestFileNotifier(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notifications.jsonl")
+	notifier := NewFileNotifier(path)
+
+	for _, title := range []string{"First", "Second"} {
+		err := notifier.Send(context.Background(), &PushMessage{Token: "secret-token", Title: title, Data: map[string]string{"k": "v"}})
+		require.NoError(t, err)
+	}
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(content), "secret-token")
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var titles []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var msg PushMessage
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &msg))
+		assert.Equal(t, "v", msg.Data["k"])
+		titles = append(titles, msg.Title)
+	}
+	assert.Equal(t, []string{"First", "Second"}, titles)
+}
+
+func TestLogNotifier_OmitsPushToken(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	notifier := NewLogNotifier(zap.New(core))
+
+	err := notifier.Send(context.Background(), &PushMessage{DeviceID: "pixel", Token: "secret-token", Title: "Hi"})
+	require.NoError(t, err)
+
+	require.Equal(t, 1, logs.Len())
+	fields := logs.All()[0].ContextMap()
+	assert.Equal(t, "pixel", fields["device_id"])
+	for _, value := range fields {
+		assert.NotContains(t, fmt.Sprint(value), "secret-token")
+	}
+}
+
+// flakyNotifier fails sends to the devices in failing and records the others
+type flakyNotifier struct {
+	failing map[string]bool
+	sent    []string
+}
+
+func (n *flakyNotifier) Send(ctx context.Context, msg *PushMessage) error {
+	if n.failing[msg.DeviceID] {
+		return fmt.Errorf("device %s unreachable", msg.DeviceID)
+	}
+	n.sent = append(n.sent, msg.DeviceID)
+	return nil
+}
+
+func TestNotificationService_Dispatch_RetriesFailedDevices(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t, &models.Device{}, &models.Notification{}, &models.NotificationSettings{})
+	notifier := &flakyNotifier{failing: map[string]bool{"tablet": true}}
+	service := NewNotificationService(db, notifier)
+
+	userID := uuid.New()
+	require.NoError(t, db.Create(&[]models.Device{
+		{UserID: userID, DeviceID: "pixel", Name: "Pixel", PushToken: "pixel-token"},
+		{UserID: userID, DeviceID: "tablet", Name: "Tablet", PushToken: "tablet-token"},
+	}).Error)
+	n := &models.Notification{UserID: userID, Kind: NotificationCleanupCompleted, DedupKey: "plan:1", Title: "Done", Body: "Freed 1 MiB"}
+	require.NoError(t, enqueueNotification(db, n))
+
+	_, err := service.Dispatch(ctx)
+	require.NoError(t, err)
+
+	// The tablet missed it, so the notification is pending a retry rather than sent
+	var stored models.Notification
+	require.NoError(t, db.First(&stored, "id = ?", n.ID).Error)
+	assert.Equal(t, NotificationPending, stored.Status)
+	assert.Equal(t, 1, stored.Attempts)
+	assert.Equal(t, models.StringList{"pixel"}, stored.DeliveredTo)
+
+	// The retry only goes to the tablet
+	notifier.failing = nil
+	require.NoError(t, db.Model(&stored).Update("next_attempt_at", time.Now().Add(-time.Second)).Error)
+	_, err = service.Dispatch(ctx)
+	require.NoError(t, err)
+
+	require.NoError(t, db.First(&stored, "id = ?", n.ID).Error)
+	assert.Equal(t, NotificationSent, stored.Status)
+	assert.Equal(t, []string{"pixel", "tablet"}, notifier.sent)
+}
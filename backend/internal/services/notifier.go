@@ -0,0 +1,242 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+// ErrInvalidPushToken is returned by a Notifier when the device token is no longer
+// registered, so the token should be dropped rather than retried
+var ErrInvalidPushToken = errors.New("push token is not registered")
+
+// PushMessage is one push notification for one device
+type PushMessage struct {
+	DeviceID string            `json:"device_id,omitempty"`
+	Token    string            `json:"-"` // A device credential, never written out
+	Title    string            `json:"title"`
+	Body     string            `json:"body"`
+	Data     map[string]string `json:"data,omitempty"`
+}
+
+// Notifier delivers push notifications to devices
+type Notifier interface {
+	Send(ctx context.Context, msg *PushMessage) error
+}
+
+// LogNotifier writes notifications to the log instead of sending them, for development.
+// Push tokens are credentials for the device, so only the device ID is logged.
+type LogNotifier struct {
+	logger *zap.Logger
+}
+
+func NewLogNotifier(logger *zap.Logger) *LogNotifier {
+	return &LogNotifier{logger: logger}
+}
+
+func (n *LogNotifier) Send(ctx context.Context, msg *PushMessage) error {
+	n.logger.Info("Push notification",
+		zap.String("device_id", msg.DeviceID),
+		zap.String("title", msg.Title),
+		zap.String("body", msg.Body),
+		zap.Any("data", msg.Data))
+	return nil
+}
+
+// FileNotifier appends notifications to a file as JSON lines, for development and tests.
+// Push tokens are left out and the file is readable only by its owner.
+type FileNotifier struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewFileNotifier(path string) *FileNotifier {
+	return &FileNotifier{path: path}
+}
+
+func (n *FileNotifier) Send(ctx context.Context, msg *PushMessage) error {
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to encode notification: %w", err)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	f, err := os.OpenFile(n.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open notification file: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write notification: %w", err)
+	}
+
+	return nil
+}
+
+const fcmScope = "https://www.googleapis.com/auth/firebase.messaging"
+
+// FCMNotifier sends notifications through the Firebase Cloud Messaging HTTP v1 API,
+// authenticating with a service account key
+type FCMNotifier struct {
+	client      *http.Client
+	projectID   string
+	clientEmail string
+	tokenURI    string
+	key         *rsa.PrivateKey
+
+	mu          sync.Mutex
+	accessToken string
+	expiresAt   time.Time
+}
+
+// fcmCredentials is the subset of a service account key file the notifier needs
+type fcmCredentials struct {
+	ProjectID   string `json:"project_id"`
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// NewFCMNotifier loads a service account key file downloaded from the Firebase console
+func NewFCMNotifier(credentialsFile string) (*FCMNotifier, error) {
+	data, err := os.ReadFile(credentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read FCM credentials: %w", err)
+	}
+
+	var creds fcmCredentials
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return nil, fmt.Errorf("failed to parse FCM credentials: %w", err)
+	}
+	if creds.ProjectID == "" || creds.ClientEmail == "" || creds.TokenURI == "" {
+		return nil, errors.New("FCM credentials must include project_id, client_email and token_uri")
+	}
+
+	key, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(creds.PrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse FCM private key: %w", err)
+	}
+
+	return &FCMNotifier{
+		client:      &http.Client{Timeout: 10 * time.Second},
+		projectID:   creds.ProjectID,
+		clientEmail: creds.ClientEmail,
+		tokenURI:    creds.TokenURI,
+		key:         key,
+	}, nil
+}
+
+func (n *FCMNotifier) Send(ctx context.Context, msg *PushMessage) error {
+	token, err := n.token(ctx)
+	if err != nil {
+		return err
+	}
+
+	payload := map[string]interface{}{
+		"message": map[string]interface{}{
+			"token":        msg.Token,
+			"notification": map[string]string{"title": msg.Title, "body": msg.Body},
+			"data":         msg.Data,
+		},
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode FCM message: %w", err)
+	}
+
+	endpoint := fmt.Sprintf("https://fcm.googleapis.com/v1/projects/%s/messages:send", n.projectID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build FCM request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send FCM message: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode == http.StatusNotFound || strings.Contains(string(respBody), "UNREGISTERED") {
+		return ErrInvalidPushToken
+	}
+	return fmt.Errorf("FCM returned %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+}
+
+// token returns a cached OAuth access token, exchanging a signed assertion for a new one
+// shortly before the cached token expires
+func (n *FCMNotifier) token(ctx context.Context) (string, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	now := time.Now()
+	if n.accessToken != "" && now.Before(n.expiresAt.Add(-time.Minute)) {
+		return n.accessToken, nil
+	}
+
+	assertion, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":   n.clientEmail,
+		"scope": fcmScope,
+		"aud":   n.tokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}).SignedString(n.key)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign FCM assertion: %w", err)
+	}
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.tokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to build token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch FCM access token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return "", fmt.Errorf("token endpoint returned %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode access token: %w", err)
+	}
+
+	n.accessToken = result.AccessToken
+	n.expiresAt = now.Add(time.Duration(result.ExpiresIn) * time.Second)
+	return n.accessToken, nil
+}
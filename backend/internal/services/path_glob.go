@@ -0,0 +1,49 @@
+package services
+
+import (
+	"regexp"
+	"strings"
+)
+
+// pathGlobRegex translates a path glob into an anchored regular expression that both
+// Go and PostgreSQL (~*) accept. "*" and "?" stay within one path segment, "**"
+// crosses segments, and "**/" also matches no directory at all. A pattern without
+// wildcards matches that path and everything below it.
+func pathGlobRegex(pattern string) string {
+	pattern = strings.Trim(pattern, "/")
+	if !strings.ContainsAny(pattern, "*?") {
+		return "^" + regexp.QuoteMeta(pattern) + "(/.*)?$"
+	}
+
+	runes := []rune(pattern)
+	var b strings.Builder
+	b.WriteByte('^')
+	for i := 0; i < len(runes); i++ {
+		switch c := runes[i]; c {
+		case '*':
+			if i+1 < len(runes) && runes[i+1] == '*' {
+				i++
+				if i+1 < len(runes) && runes[i+1] == '/' {
+					i++
+					b.WriteString("(.*/)?")
+				} else {
+					b.WriteString(".*")
+				}
+				continue
+			}
+			b.WriteString("[^/]*")
+		case '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteByte('$')
+	return b.String()
+}
+
+// compilePathGlob compiles a path glob for matching in Go. Like the SQL side, matching
+// is case-insensitive because Android shared storage is.
+func compilePathGlob(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile("(?i)" + pathGlobRegex(pattern))
+}
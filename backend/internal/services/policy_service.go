@@ -0,0 +1,621 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/purespace/backend/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrPolicyNotFound = errors.New("cleanup policy not found")
+	ErrInvalidPolicy  = errors.New("invalid cleanup policy")
+)
+
+// Cleanup policy kinds
+const (
+	PolicyDuplicates = "duplicates" // Remove matching copies of content that is kept elsewhere
+	PolicyFiles      = "files"      // Remove every matching file
+)
+
+// Cleanup policy approvals
+const (
+	ApprovalAuto    = "auto"    // Plans are committed as soon as they are produced
+	ApprovalConfirm = "confirm" // Plans are left as drafts for the user to commit
+)
+
+const (
+	defaultPolicyInterval = 24
+	maxPoliciesPerUser    = 20
+	maxPolicyFiles        = 5000
+	maxPolicyBatch        = 20
+	// A claimed policy is not picked up by another evaluator until this passes
+	policyClaimTimeout = 30 * time.Minute
+)
+
+// PolicyService manages cleanup policies and turns their matches into cleanup plans
+type PolicyService struct {
+	db             *gorm.DB
+	cleanupService *CleanupService
+}
+
+func NewPolicyService(db *gorm.DB, cleanupService *CleanupService) *PolicyService {
+	return &PolicyService{
+		db:             db,
+		cleanupService: cleanupService,
+	}
+}
+
+type PolicyRequest struct {
+	Name          string `json:"name" binding:"required,max=100"`
+	Kind          string `json:"kind" binding:"required,oneof=duplicates files"`
+	Query         string `json:"query" binding:"required"`
+	KeepPath      string `json:"keep_path"`
+	AllowLastCopy bool   `json:"allow_last_copy"`
+	Approval      string `json:"approval" binding:"required,oneof=auto confirm"`
+	IntervalHours int    `json:"interval_hours" binding:"omitempty,min=1,max=720"`
+	RunAfterSync  bool   `json:"run_after_sync"`
+	Enabled       *bool  `json:"enabled"`
+}
+
+// PolicyFile is one file of a policy dry run, with why it is removed or kept
+type PolicyFile struct {
+	FileID   uint   `json:"file_id"`
+	DeviceID string `json:"device_id"`
+	PathTail string `json:"path_tail"`
+	SHA256   string `json:"sha256"`
+	Size     int64  `json:"size"`
+	Reason   string `json:"reason"`
+}
+
+// PolicyDryRun is what a policy would do if it ran now. Files are removed by the plan;
+// Skipped files matched the query but are kept.
+type PolicyDryRun struct {
+	PolicyID    *uuid.UUID   `json:"policy_id,omitempty"` // Unset for a policy that is not saved yet
+	PolicyName  string       `json:"policy_name"`
+	Kind        string       `json:"kind"`
+	Query       string       `json:"query"`
+	Approval    string       `json:"approval"`
+	EvaluatedAt time.Time    `json:"evaluated_at"`
+	Files       []PolicyFile `json:"files"`
+	Skipped     []PolicyFile `json:"skipped"`
+	TotalFiles  int          `json:"total_files"`
+	TotalBytes  int64        `json:"total_bytes"`
+	Override    bool         `json:"override"`  // Some files are last copies; the plan needs a safety override
+	Truncated   bool         `json:"truncated"` // More files matched than one run handles
+}
+
+// PolicyRun is the result of running a policy: its dry run and the plan it produced, if any
+type PolicyRun struct {
+	Report *PolicyDryRun       `json:"report"`
+	Plan   *models.CleanupPlan `json:"plan,omitempty"`
+}
+
+// ListPolicies returns the user's cleanup policies
+func (s *PolicyService) ListPolicies(ctx context.Context, userID uuid.UUID) ([]models.CleanupPolicy, error) {
+	policies := []models.CleanupPolicy{}
+	if err := s.db.WithContext(ctx).Where("user_id = ?", userID).Order("created_at ASC").Find(&policies).Error; err != nil {
+		return nil, fmt.Errorf("failed to list cleanup policies: %w", err)
+	}
+
+	return policies, nil
+}
+
+// GetPolicy returns one of the user's cleanup policies
+func (s *PolicyService) GetPolicy(ctx context.Context, userID, policyID uuid.UUID) (*models.CleanupPolicy, error) {
+	var policy models.CleanupPolicy
+	err := s.db.WithContext(ctx).Where("id = ? AND user_id = ?", policyID, userID).First(&policy).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrPolicyNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cleanup policy: %w", err)
+	}
+
+	return &policy, nil
+}
+
+// CreatePolicy saves a new policy; its first run is due right away
+func (s *PolicyService) CreatePolicy(ctx context.Context, userID uuid.UUID, req *PolicyRequest) (*models.CleanupPolicy, error) {
+	if err := requirePremium(s.db.WithContext(ctx), userID); err != nil {
+		return nil, err
+	}
+
+	var count int64
+	if err := s.db.WithContext(ctx).Model(&models.CleanupPolicy{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+		return nil, fmt.Errorf("failed to count cleanup policies: %w", err)
+	}
+	if count >= maxPoliciesPerUser {
+		return nil, fmt.Errorf("%w: at most %d policies are allowed", ErrInvalidPolicy, maxPoliciesPerUser)
+	}
+
+	policy := &models.CleanupPolicy{
+		ID:        uuid.New(),
+		UserID:    userID,
+		NextRunAt: time.Now(),
+	}
+	if err := applyPolicyRequest(policy, req); err != nil {
+		return nil, err
+	}
+
+	if err := s.db.WithContext(ctx).Create(policy).Error; err != nil {
+		return nil, fmt.Errorf("failed to create cleanup policy: %w", err)
+	}
+
+	return policy, nil
+}
+
+// UpdatePolicy replaces a policy's rule and schedule
+func (s *PolicyService) UpdatePolicy(ctx context.Context, userID, policyID uuid.UUID, req *PolicyRequest) (*models.CleanupPolicy, error) {
+	if err := requirePremium(s.db.WithContext(ctx), userID); err != nil {
+		return nil, err
+	}
+
+	policy, err := s.GetPolicy(ctx, userID, policyID)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyPolicyRequest(policy, req); err != nil {
+		return nil, err
+	}
+
+	err = s.db.WithContext(ctx).Model(&models.CleanupPolicy{}).Where("id = ?", policy.ID).Updates(map[string]interface{}{
+		"name":           policy.Name,
+		"kind":           policy.Kind,
+		"query":          policy.Query,
+		"keep_path":      policy.KeepPath,
+		"approval":       policy.Approval,
+		"interval_hours": policy.IntervalHours,
+		"run_after_sync": policy.RunAfterSync,
+		"enabled":        policy.Enabled,
+	}).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to update cleanup policy: %w", err)
+	}
+
+	return s.GetPolicy(ctx, userID, policyID)
+}
+
+// DeletePolicy removes a policy along with the draft plan it left for confirmation
+func (s *PolicyService) DeletePolicy(ctx context.Context, userID, policyID uuid.UUID) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ? AND user_id = ?", policyID, userID).Delete(&models.CleanupPolicy{})
+		if result.Error != nil {
+			return fmt.Errorf("failed to delete cleanup policy: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrPolicyNotFound
+		}
+
+		return discardPolicyDrafts(tx, policyID)
+	})
+}
+
+// DryRun reports what a saved policy would remove if it ran now
+func (s *PolicyService) DryRun(ctx context.Context, userID, policyID uuid.UUID) (*PolicyDryRun, error) {
+	if err := requirePremium(s.db.WithContext(ctx), userID); err != nil {
+		return nil, err
+	}
+
+	policy, err := s.GetPolicy(ctx, userID, policyID)
+	if err != nil {
+		return nil, err
+	}
+
+	return evaluatePolicy(s.db.WithContext(ctx), policy, time.Now())
+}
+
+// DryRunDraft reports what a policy would remove before it is saved
+func (s *PolicyService) DryRunDraft(ctx context.Context, userID uuid.UUID, req *PolicyRequest) (*PolicyDryRun, error) {
+	if err := requirePremium(s.db.WithContext(ctx), userID); err != nil {
+		return nil, err
+	}
+
+	policy := &models.CleanupPolicy{UserID: userID}
+	if err := applyPolicyRequest(policy, req); err != nil {
+		return nil, err
+	}
+
+	return evaluatePolicy(s.db.WithContext(ctx), policy, time.Now())
+}
+
+// RunPolicy runs a policy now, producing a plan if any file is removed
+func (s *PolicyService) RunPolicy(ctx context.Context, userID, policyID uuid.UUID) (*PolicyRun, error) {
+	if err := requirePremium(s.db.WithContext(ctx), userID); err != nil {
+		return nil, err
+	}
+
+	policy, err := s.GetPolicy(ctx, userID, policyID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.run(ctx, policy)
+}
+
+// RunDue runs the enabled policies whose next run has come, and returns how many ran.
+// Each batch is claimed with SKIP LOCKED, so several evaluators can run side by side.
+// A policy that fails records the error and is retried at its next run.
+func (s *PolicyService) RunDue(ctx context.Context) (int, error) {
+	var due []models.CleanupPolicy
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("enabled = ? AND next_run_at <= ?", true, now).
+			Order("next_run_at ASC").
+			Limit(maxPolicyBatch).
+			Find(&due).Error
+		if err != nil || len(due) == 0 {
+			return err
+		}
+
+		ids := make([]uuid.UUID, len(due))
+		for i := range due {
+			ids[i] = due[i].ID
+		}
+		return tx.Model(&models.CleanupPolicy{}).Where("id IN ?", ids).Update("next_run_at", now.Add(policyClaimTimeout)).Error
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to claim cleanup policies: %w", err)
+	}
+
+	for i := range due {
+		if err := requirePremium(s.db.WithContext(ctx), due[i].UserID); err != nil {
+			// Policies of lapsed subscriptions stay saved but do not run
+			s.recordRun(ctx, &due[i], time.Now(), nil, err)
+			continue
+		}
+		s.run(ctx, &due[i])
+	}
+
+	return len(due), nil
+}
+
+// run evaluates the policy and turns its matches into a plan, replacing the draft its
+// previous run left for confirmation
+func (s *PolicyService) run(ctx context.Context, policy *models.CleanupPolicy) (*PolicyRun, error) {
+	now := time.Now()
+
+	report, err := evaluatePolicy(s.db.WithContext(ctx), policy, now)
+	var plan *models.CleanupPlan
+	if err == nil && len(report.Files) > 0 {
+		plan, err = s.producePlan(ctx, policy, report)
+	}
+
+	if recordErr := s.recordRun(ctx, policy, now, plan, err); recordErr != nil && err == nil {
+		err = recordErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &PolicyRun{Report: report, Plan: plan}, nil
+}
+
+func (s *PolicyService) producePlan(ctx context.Context, policy *models.CleanupPolicy, report *PolicyDryRun) (*models.CleanupPlan, error) {
+	if err := discardPolicyDrafts(s.db.WithContext(ctx), policy.ID); err != nil {
+		return nil, err
+	}
+
+	req := &CreatePlanRequest{PolicyID: &policy.ID}
+	for _, file := range report.Files {
+		req.FileIDs = append(req.FileIDs, file.FileID)
+	}
+	if report.Override {
+		req.Override = true
+		req.OverrideReason = fmt.Sprintf("Cleanup policy %q allows removing the last copy of files matching %q", policy.Name, policy.Query)
+	}
+
+	plan, err := s.cleanupService.CreatePlan(ctx, policy.UserID, req)
+	if err != nil {
+		return nil, err
+	}
+	// A plan that deletes last copies is never committed without the user looking at it
+	if policy.Approval != ApprovalAuto || plan.Override {
+		return plan, nil
+	}
+
+	return s.cleanupService.CommitPlan(ctx, policy.UserID, plan.ID)
+}
+
+func (s *PolicyService) recordRun(ctx context.Context, policy *models.CleanupPolicy, now time.Time, plan *models.CleanupPlan, runErr error) error {
+	updates := map[string]interface{}{
+		"last_run_at": now,
+		"next_run_at": now.Add(time.Duration(policy.IntervalHours) * time.Hour),
+		"last_error":  "",
+	}
+	if runErr != nil {
+		updates["last_error"] = runErr.Error()
+	}
+	if plan != nil {
+		updates["last_plan_id"] = plan.ID
+	}
+
+	if err := s.db.WithContext(ctx).Model(&models.CleanupPolicy{}).Where("id = ?", policy.ID).Updates(updates).Error; err != nil {
+		return fmt.Errorf("failed to record cleanup policy run: %w", err)
+	}
+
+	return nil
+}
+
+// markPoliciesDue makes the user's policies that run after a sync due now
+func markPoliciesDue(tx *gorm.DB, userID uuid.UUID) error {
+	now := time.Now()
+	err := tx.Model(&models.CleanupPolicy{}).
+		Where("user_id = ? AND enabled = ? AND run_after_sync = ? AND next_run_at > ?", userID, true, true, now).
+		Update("next_run_at", now).Error
+	if err != nil {
+		return fmt.Errorf("failed to schedule cleanup policies: %w", err)
+	}
+
+	return nil
+}
+
+func discardPolicyDrafts(db *gorm.DB, policyID uuid.UUID) error {
+	err := db.Where("policy_id = ? AND status = ?", policyID, PlanDraft).Delete(&models.CleanupPlan{}).Error
+	if err != nil {
+		return fmt.Errorf("failed to discard draft plans: %w", err)
+	}
+
+	return nil
+}
+
+func applyPolicyRequest(policy *models.CleanupPolicy, req *PolicyRequest) error {
+	if _, err := ParseSearchQuery(req.Query, time.Now()); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPolicy, err)
+	}
+
+	keepPath := strings.TrimSpace(req.KeepPath)
+	if keepPath != "" {
+		if req.Kind != PolicyDuplicates {
+			return fmt.Errorf("%w: keep_path only applies to duplicates policies", ErrInvalidPolicy)
+		}
+		if _, err := compilePathGlob(keepPath); err != nil {
+			return fmt.Errorf("%w: keep_path: %v", ErrInvalidPolicy, err)
+		}
+	}
+
+	if req.AllowLastCopy && req.Kind != PolicyFiles {
+		return fmt.Errorf("%w: allow_last_copy only applies to files policies", ErrInvalidPolicy)
+	}
+
+	policy.Name = strings.TrimSpace(req.Name)
+	policy.Kind = req.Kind
+	policy.Query = req.Query
+	policy.KeepPath = keepPath
+	policy.AllowLastCopy = req.AllowLastCopy
+	policy.Approval = req.Approval
+	policy.IntervalHours = req.IntervalHours
+	if policy.IntervalHours == 0 {
+		policy.IntervalHours = defaultPolicyInterval
+	}
+	policy.RunAfterSync = req.RunAfterSync
+	policy.Enabled = req.Enabled == nil || *req.Enabled
+
+	return nil
+}
+
+// evaluatePolicy works out which of the files matching the policy's query a run would
+// remove. Files that committed plans are already deleting are left out, and the safety
+// rules are applied: protected files are always kept, and last copies are kept unless a
+// files policy allows removing them under a recorded override.
+func evaluatePolicy(db *gorm.DB, policy *models.CleanupPolicy, now time.Time) (*PolicyDryRun, error) {
+	query, err := ParseSearchQuery(policy.Query, now)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPolicy, err)
+	}
+
+	report := &PolicyDryRun{
+		PolicyName:  policy.Name,
+		Kind:        policy.Kind,
+		Query:       policy.Query,
+		Approval:    policy.Approval,
+		EvaluatedAt: now,
+		Files:       []PolicyFile{},
+		Skipped:     []PolicyFile{},
+	}
+	if policy.ID != uuid.Nil {
+		report.PolicyID = &policy.ID
+	}
+
+	base := db.Model(&models.File{}).
+		Where("user_id = ?", policy.UserID).
+		Where("id NOT IN (?)", pendingDeletions(db, policy.UserID, uuid.Nil))
+	for _, clause := range query.clauses {
+		base = base.Where(clause.sql, clause.args...)
+	}
+
+	var matched []models.File
+	if err := base.Order("id ASC").Limit(maxPolicyFiles + 1).Find(&matched).Error; err != nil {
+		return nil, fmt.Errorf("failed to load policy files: %w", err)
+	}
+	if len(matched) > maxPolicyFiles {
+		matched = matched[:maxPolicyFiles]
+		report.Truncated = true
+	}
+	if len(matched) == 0 {
+		return report, nil
+	}
+
+	seen := make(map[string]bool)
+	var hashes []string
+	for _, file := range matched {
+		if !seen[file.SHA256] {
+			seen[file.SHA256] = true
+			hashes = append(hashes, file.SHA256)
+		}
+	}
+	copies, err := loadCopies(db, policy.UserID, uuid.Nil, hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	var keep *regexp.Regexp
+	if policy.KeepPath != "" {
+		if keep, err = compilePathGlob(policy.KeepPath); err != nil {
+			return nil, fmt.Errorf("%w: keep_path: %v", ErrInvalidPolicy, err)
+		}
+	}
+
+	selected, skipped := selectPolicyFiles(policy.Kind, matched, copies, keep)
+	report.Skipped = append(report.Skipped, skipped...)
+
+	settings, err := loadSafetySettings(db, policy.UserID)
+	if err != nil {
+		return nil, err
+	}
+	allowLastCopy := policy.Kind == PolicyFiles && policy.AllowLastCopy
+	applyPolicySafety(report, allowLastCopy, selected, evaluateSafety(settings, policyPlanItems(selected), copies))
+
+	return report, nil
+}
+
+// selectPolicyFiles picks the matched files a policy removes. A files policy removes all
+// of them. A duplicates policy removes a matched copy only while another copy of the same
+// content remains: one under keep, when set, or else the oldest copy, which is kept.
+// copies holds every live copy of the matched content.
+func selectPolicyFiles(kind string, matched []models.File, copies []safetyCopy, keep *regexp.Regexp) (selected, skipped []PolicyFile) {
+	if kind == PolicyFiles {
+		for _, file := range matched {
+			selected = append(selected, policyFile(file, "Matches the policy query"))
+		}
+		return selected, skipped
+	}
+
+	var hashes []string
+	targets := make(map[string][]models.File)
+	for _, file := range matched {
+		if keep != nil && keep.MatchString(file.PathTail) {
+			continue // Matched files under keep_path are the copies being kept
+		}
+		if _, ok := targets[file.SHA256]; !ok {
+			hashes = append(hashes, file.SHA256)
+		}
+		targets[file.SHA256] = append(targets[file.SHA256], file)
+	}
+
+	targeted := make(map[uint]bool)
+	for _, files := range targets {
+		for _, file := range files {
+			targeted[file.ID] = true
+		}
+	}
+
+	survivors := make(map[string][]safetyCopy)
+	for _, c := range copies {
+		if !targeted[c.ID] && (keep == nil || keep.MatchString(c.PathTail)) {
+			survivors[c.SHA256] = append(survivors[c.SHA256], c)
+		}
+	}
+
+	for _, sha256 := range hashes {
+		files := targets[sha256]
+		kept := survivors[sha256]
+		sort.Slice(kept, func(i, j int) bool {
+			if !kept[i].CreatedAt.Equal(kept[j].CreatedAt) {
+				return kept[i].CreatedAt.Before(kept[j].CreatedAt)
+			}
+			return kept[i].ID < kept[j].ID
+		})
+
+		if len(kept) == 0 && keep != nil {
+			for _, file := range files {
+				skipped = append(skipped, policyFile(file, "No copy under keep_path remains"))
+			}
+			continue
+		}
+		if len(kept) == 0 {
+			// Every copy matched, so the oldest one stays
+			sort.Slice(files, func(i, j int) bool {
+				if !files[i].CreatedAt.Equal(files[j].CreatedAt) {
+					return files[i].CreatedAt.Before(files[j].CreatedAt)
+				}
+				return files[i].ID < files[j].ID
+			})
+			oldest := files[0]
+			skipped = append(skipped, policyFile(oldest, "Oldest copy is kept"))
+			kept = []safetyCopy{{ID: oldest.ID, DeviceID: oldest.DeviceID, PathTail: oldest.PathTail, SHA256: oldest.SHA256}}
+			files = files[1:]
+		}
+
+		reason := fmt.Sprintf("Duplicate of %s on %s", kept[0].PathTail, kept[0].DeviceID)
+		for _, file := range files {
+			selected = append(selected, policyFile(file, reason))
+		}
+	}
+
+	return selected, skipped
+}
+
+// applyPolicySafety moves files that break a safety rule from the selection into the
+// skipped list, except last copies the policy allows removing, which need an override
+func applyPolicySafety(report *PolicyDryRun, allowLastCopy bool, selected []PolicyFile, violations []models.SafetyViolation) {
+	protected := make(map[uint]string)
+	lastCopies := make(map[uint]bool)
+	for _, violation := range violations {
+		for _, fileID := range violation.FileIDs {
+			if violation.Rule == RuleProtectedPath {
+				if _, ok := protected[fileID]; !ok {
+					protected[fileID] = violation.Pattern
+				}
+				continue
+			}
+			lastCopies[fileID] = true
+		}
+	}
+
+	for _, file := range selected {
+		if pattern, ok := protected[file.FileID]; ok {
+			file.Reason = fmt.Sprintf("Under protected path %s", pattern)
+			report.Skipped = append(report.Skipped, file)
+			continue
+		}
+		if lastCopies[file.FileID] {
+			if !allowLastCopy {
+				file.Reason = "Last copy"
+				report.Skipped = append(report.Skipped, file)
+				continue
+			}
+			report.Override = true
+		}
+		report.Files = append(report.Files, file)
+		report.TotalFiles++
+		report.TotalBytes += file.Size
+	}
+}
+
+func policyFile(file models.File, reason string) PolicyFile {
+	return PolicyFile{
+		FileID:   file.ID,
+		DeviceID: file.DeviceID,
+		PathTail: file.PathTail,
+		SHA256:   file.SHA256,
+		Size:     file.Size,
+		Reason:   reason,
+	}
+}
+
+func policyPlanItems(files []PolicyFile) []models.CleanupPlanItem {
+	items := make([]models.CleanupPlanItem, len(files))
+	for i, file := range files {
+		items[i] = models.CleanupPlanItem{
+			FileID:   file.FileID,
+			DeviceID: file.DeviceID,
+			PathTail: file.PathTail,
+			SHA256:   file.SHA256,
+			Size:     file.Size,
+			Status:   PlanItemPending,
+		}
+	}
+
+	return items
+}
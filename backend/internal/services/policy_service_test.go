@@ -0,0 +1,180 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/purespace/backend/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelectPolicyFiles(t *testing.T) {
+	day := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	sent := models.File{ID: 1, DeviceID: "pixel", PathTail: "WhatsApp/Media/Sent/a.jpg", SHA256: "aaa", Size: 100, CreatedAt: day.AddDate(0, 0, 2)}
+	sentOnly := models.File{ID: 2, DeviceID: "pixel", PathTail: "WhatsApp/Media/Sent/b.jpg", SHA256: "bbb", Size: 200, CreatedAt: day}
+	sentOld := models.File{ID: 3, DeviceID: "pixel", PathTail: "WhatsApp/Media/Sent/c.jpg", SHA256: "ccc", Size: 300, CreatedAt: day}
+	sentNew := models.File{ID: 4, DeviceID: "pixel", PathTail: "WhatsApp/Media/Sent/d.jpg", SHA256: "ccc", Size: 300, CreatedAt: day.AddDate(0, 0, 1)}
+
+	matched := []models.File{sent, sentOnly, sentOld, sentNew}
+	copies := []safetyCopy{
+		{ID: 1, DeviceID: "pixel", PathTail: sent.PathTail, SHA256: "aaa", CreatedAt: sent.CreatedAt},
+		{ID: 5, DeviceID: "pixel", PathTail: "DCIM/Camera/a.jpg", SHA256: "aaa", CreatedAt: day},
+		{ID: 2, DeviceID: "pixel", PathTail: sentOnly.PathTail, SHA256: "bbb", CreatedAt: sentOnly.CreatedAt},
+		{ID: 3, DeviceID: "pixel", PathTail: sentOld.PathTail, SHA256: "ccc", CreatedAt: sentOld.CreatedAt},
+		{ID: 4, DeviceID: "pixel", PathTail: sentNew.PathTail, SHA256: "ccc", CreatedAt: sentNew.CreatedAt},
+	}
+
+	tests := []struct {
+		name     string
+		kind     string
+		keep     *regexp.Regexp
+		selected []uint
+		skipped  map[uint]string
+	}{
+		{
+			name:     "Files policy removes every match",
+			kind:     PolicyFiles,
+			selected: []uint{1, 2, 3, 4},
+			skipped:  map[uint]string{},
+		},
+		{
+			name:     "Duplicates keep the oldest copy",
+			kind:     PolicyDuplicates,
+			selected: []uint{1, 4},
+			skipped: map[uint]string{
+				2: "Oldest copy is kept",
+				3: "Oldest copy is kept",
+			},
+		},
+		{
+			name:     "Duplicates keep the copy under keep_path",
+			kind:     PolicyDuplicates,
+			keep:     regexp.MustCompile(`(?i)^DCIM/`),
+			selected: []uint{1},
+			skipped: map[uint]string{
+				2: "No copy under keep_path remains",
+				3: "No copy under keep_path remains",
+				4: "No copy under keep_path remains",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected, skipped := selectPolicyFiles(tt.kind, matched, copies, tt.keep)
+
+			var selectedIDs []uint
+			for _, file := range selected {
+				selectedIDs = append(selectedIDs, file.FileID)
+			}
+			assert.Equal(t, tt.selected, selectedIDs)
+
+			skippedReasons := map[uint]string{}
+			for _, file := range skipped {
+				skippedReasons[file.FileID] = file.Reason
+			}
+			assert.Equal(t, tt.skipped, skippedReasons)
+		})
+	}
+
+	selected, _ := selectPolicyFiles(PolicyDuplicates, matched, copies, nil)
+	assert.Equal(t, "Duplicate of DCIM/Camera/a.jpg on pixel", selected[0].Reason)
+	assert.Equal(t, "Duplicate of WhatsApp/Media/Sent/c.jpg on pixel", selected[1].Reason)
+}
+
+func TestApplyPolicySafety(t *testing.T) {
+	selected := []PolicyFile{
+		{FileID: 1, PathTail: "Pictures/Screenshots/a.png", Size: 100},
+		{FileID: 2, PathTail: "Pictures/Screenshots/b.png", Size: 200},
+		{FileID: 3, PathTail: "Pictures/Screenshots/Keep/c.png", Size: 300},
+	}
+	violations := []models.SafetyViolation{
+		{Rule: RuleLastCopy, SHA256: "bbb", FileIDs: []uint{2}},
+		{Rule: RuleLastCopy, SHA256: "ccc", FileIDs: []uint{3}},
+		{Rule: RuleProtectedPath, Pattern: "Pictures/Screenshots/Keep", FileIDs: []uint{3}},
+	}
+
+	t.Run("Allowed last copies need an override", func(t *testing.T) {
+		report := &PolicyDryRun{}
+		applyPolicySafety(report, true, selected, violations)
+
+		assert.True(t, report.Override)
+		assert.Equal(t, 2, report.TotalFiles)
+		assert.Equal(t, int64(300), report.TotalBytes)
+		assert.Equal(t, []PolicyFile{{FileID: 3, PathTail: "Pictures/Screenshots/Keep/c.png", Size: 300, Reason: "Under protected path Pictures/Screenshots/Keep"}}, report.Skipped)
+	})
+
+	t.Run("Last copies are kept by default", func(t *testing.T) {
+		report := &PolicyDryRun{}
+		applyPolicySafety(report, false, selected, violations)
+
+		assert.False(t, report.Override)
+		assert.Equal(t, 1, report.TotalFiles)
+		assert.Len(t, report.Skipped, 2)
+		assert.Equal(t, "Last copy", report.Skipped[0].Reason)
+	})
+}
+
+func TestPolicyService_LastCopies(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t, &models.User{}, &models.File{}, &models.CleanupPolicy{}, &models.CleanupPlan{},
+		&models.CleanupPlanItem{}, &models.SafetySettings{}, &models.SafetyOverride{}, &models.DeviceAction{})
+	fileService := NewFileService(db, nil, 0)
+	cleanupService := NewCleanupService(db, NewDuplicateDetector(db), fileService, NewActionService(db, nil, fileService))
+	policyService := NewPolicyService(db, cleanupService)
+
+	user := models.User{Email: "test@example.com", Provider: "google"}
+	require.NoError(t, db.Create(&user).Error)
+	file := models.File{UserID: user.ID, DeviceID: "pixel", PathTail: "Pictures/Screenshots/a.png", SHA256: strings.Repeat("ab", 32), Size: 100}
+	require.NoError(t, db.Create(&file).Error)
+
+	policy := models.CleanupPolicy{ID: uuid.New(), UserID: user.ID, Name: "Screenshots", Kind: PolicyFiles,
+		Query: "size>50", Approval: ApprovalAuto, IntervalHours: 24, Enabled: true, NextRunAt: time.Now()}
+	require.NoError(t, db.Create(&policy).Error)
+
+	// Without the opt-in the only copy is kept
+	run, err := policyService.run(ctx, &policy)
+	require.NoError(t, err)
+	assert.Nil(t, run.Plan)
+	assert.False(t, run.Report.Override)
+	require.Len(t, run.Report.Skipped, 1)
+	assert.Equal(t, "Last copy", run.Report.Skipped[0].Reason)
+
+	// With it the plan needs an override, so it waits for the user even with auto approval
+	policy.AllowLastCopy = true
+	run, err = policyService.run(ctx, &policy)
+	require.NoError(t, err)
+	require.NotNil(t, run.Plan)
+	assert.True(t, run.Plan.Override)
+	assert.Equal(t, PlanDraft, run.Plan.Status)
+
+	// A path protected after the draft was made still applies
+	_, err = NewSafetyService(db).UpdateSettings(ctx, user.ID, &UpdateSafetySettingsRequest{
+		KeepCopyScope:  KeepCopyPerUser,
+		ProtectedPaths: []string{"Pictures/**"},
+	})
+	require.NoError(t, err)
+
+	_, err = cleanupService.CommitPlan(ctx, user.ID, run.Plan.ID)
+	var safetyErr *SafetyError
+	require.True(t, errors.As(err, &safetyErr), "error: %v", err)
+	require.Len(t, safetyErr.Violations, 1)
+	assert.Equal(t, RuleProtectedPath, safetyErr.Violations[0].Rule)
+}
+
+func TestApplyPolicyRequest_AllowLastCopy(t *testing.T) {
+	req := &PolicyRequest{Name: "Sent", Kind: PolicyDuplicates, Query: "older:30d", Approval: ApprovalConfirm, AllowLastCopy: true}
+	err := applyPolicyRequest(&models.CleanupPolicy{}, req)
+	assert.True(t, errors.Is(err, ErrInvalidPolicy))
+
+	req.Kind = PolicyFiles
+	policy := &models.CleanupPolicy{}
+	require.NoError(t, applyPolicyRequest(policy, req))
+	assert.True(t, policy.AllowLastCopy)
+}
@@ -0,0 +1,183 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/purespace/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+var ErrReportNotFound = errors.New("report not found")
+
+type ReportService struct {
+	db *gorm.DB
+}
+
+func NewReportService(db *gorm.DB) *ReportService {
+	return &ReportService{
+		db: db,
+	}
+}
+
+type ReportDetail struct {
+	Report models.Report            `json:"report"`
+	Files  []models.CleanupPlanItem `json:"files"` // Files the report counts as deleted
+}
+
+type SavingsSummary struct {
+	BytesSaved   int64            `json:"bytes_saved"`
+	ItemsDeleted int64            `json:"items_deleted"`
+	ReportCount  int64            `json:"report_count"`
+	ByMonth      []MonthlySavings `json:"by_month"`
+	ByCategory   []GroupedSavings `json:"by_category"`
+	ByDevice     []GroupedSavings `json:"by_device"`
+}
+
+type MonthlySavings struct {
+	Month        string `json:"month"` // YYYY-MM
+	BytesSaved   int64  `json:"bytes_saved"`
+	ItemsDeleted int64  `json:"items_deleted"`
+}
+
+type GroupedSavings struct {
+	Key          string `json:"key"`
+	BytesSaved   int64  `json:"bytes_saved"`
+	ItemsDeleted int64  `json:"items_deleted"`
+}
+
+// ListReports returns the user's cleanup reports, newest first
+func (s *ReportService) ListReports(ctx context.Context, userID uuid.UUID, limit int) ([]models.Report, error) {
+	reports := []models.Report{}
+
+	query := s.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("completed_at DESC")
+
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+
+	if err := query.Find(&reports).Error; err != nil {
+		return nil, fmt.Errorf("failed to list reports: %w", err)
+	}
+
+	return reports, nil
+}
+
+// GetReport returns a report with the files it deleted
+func (s *ReportService) GetReport(ctx context.Context, userID uuid.UUID, reportID uint) (*ReportDetail, error) {
+	detail := &ReportDetail{Files: []models.CleanupPlanItem{}}
+
+	err := s.db.WithContext(ctx).Where("id = ? AND user_id = ?", reportID, userID).First(&detail.Report).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrReportNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get report: %w", err)
+	}
+
+	if detail.Report.PlanID != nil {
+		err = s.db.WithContext(ctx).
+			Where("plan_id = ? AND status = ?", *detail.Report.PlanID, PlanItemDeleted).
+			Order("id ASC").
+			Find(&detail.Files).Error
+		if err != nil {
+			return nil, fmt.Errorf("failed to get report files: %w", err)
+		}
+	}
+
+	return detail, nil
+}
+
+// GetSavingsSummary aggregates every report of the user: lifetime totals, totals per
+// month of completion, and bytes saved per media category and per device
+func (s *ReportService) GetSavingsSummary(ctx context.Context, userID uuid.UUID) (*SavingsSummary, error) {
+	summary := &SavingsSummary{
+		ByMonth:    []MonthlySavings{},
+		ByCategory: []GroupedSavings{},
+		ByDevice:   []GroupedSavings{},
+	}
+
+	var totals struct {
+		BytesSaved   int64
+		ItemsDeleted int64
+		ReportCount  int64
+	}
+	err := s.db.WithContext(ctx).Model(&models.Report{}).
+		Select("COALESCE(SUM(bytes_saved), 0) AS bytes_saved, COALESCE(SUM(items_deleted), 0) AS items_deleted, COUNT(*) AS report_count").
+		Where("user_id = ?", userID).
+		Scan(&totals).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to sum reports: %w", err)
+	}
+	summary.BytesSaved = totals.BytesSaved
+	summary.ItemsDeleted = totals.ItemsDeleted
+	summary.ReportCount = totals.ReportCount
+
+	err = s.db.WithContext(ctx).Model(&models.Report{}).
+		Select("TO_CHAR(DATE_TRUNC('month', completed_at), 'YYYY-MM') AS month, SUM(bytes_saved) AS bytes_saved, SUM(items_deleted) AS items_deleted").
+		Where("user_id = ?", userID).
+		Group("month").
+		Order("month ASC").
+		Scan(&summary.ByMonth).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to group reports by month: %w", err)
+	}
+
+	var items []models.CleanupPlanItem
+	err = s.db.WithContext(ctx).
+		Select("cleanup_plan_items.device_id, cleanup_plan_items.path_tail, cleanup_plan_items.mime, cleanup_plan_items.size").
+		Joins("JOIN reports ON reports.plan_id = cleanup_plan_items.plan_id").
+		Where("reports.user_id = ? AND cleanup_plan_items.status = ?", userID, PlanItemDeleted).
+		Find(&items).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load deleted files: %w", err)
+	}
+
+	summary.ByCategory, summary.ByDevice = groupSavings(items)
+
+	return summary, nil
+}
+
+// groupSavings totals deleted files per media category and per device, largest first
+func groupSavings(items []models.CleanupPlanItem) (byCategory, byDevice []GroupedSavings) {
+	categories := make(map[string]*GroupedSavings)
+	devices := make(map[string]*GroupedSavings)
+
+	add := func(groups map[string]*GroupedSavings, key string, size int64) {
+		group, ok := groups[key]
+		if !ok {
+			group = &GroupedSavings{Key: key}
+			groups[key] = group
+		}
+		group.BytesSaved += size
+		group.ItemsDeleted++
+	}
+
+	for _, item := range items {
+		add(categories, classifyFile(item.Mime, item.PathTail), item.Size)
+		add(devices, item.DeviceID, item.Size)
+	}
+
+	return sortedSavings(categories), sortedSavings(devices)
+}
+
+func sortedSavings(groups map[string]*GroupedSavings) []GroupedSavings {
+	result := make([]GroupedSavings, 0, len(groups))
+	for _, group := range groups {
+		result = append(result, *group)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].BytesSaved != result[j].BytesSaved {
+			return result[i].BytesSaved > result[j].BytesSaved
+		}
+		return result[i].Key < result[j].Key
+	})
+
+	return result
+}
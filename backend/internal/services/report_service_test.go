@@ -0,0 +1,38 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/purespace/backend/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroupSavings(t *testing.T) {
+	items := []models.CleanupPlanItem{
+		{DeviceID: "pixel", PathTail: "DCIM/a.jpg", Mime: "image/jpeg", Size: 100},
+		{DeviceID: "pixel", PathTail: "Movies/b.mp4", Mime: "video/mp4", Size: 1000},
+		{DeviceID: "tablet", PathTail: "DCIM/c.jpg", Mime: "image/jpeg", Size: 300},
+		{DeviceID: "tablet", PathTail: "Download/app.apk", Size: 50},
+	}
+
+	byCategory, byDevice := groupSavings(items)
+
+	assert.Equal(t, []GroupedSavings{
+		{Key: CategoryVideos, BytesSaved: 1000, ItemsDeleted: 1},
+		{Key: CategoryImages, BytesSaved: 400, ItemsDeleted: 2},
+		{Key: CategoryAPKs, BytesSaved: 50, ItemsDeleted: 1},
+	}, byCategory)
+	assert.Equal(t, []GroupedSavings{
+		{Key: "pixel", BytesSaved: 1100, ItemsDeleted: 2},
+		{Key: "tablet", BytesSaved: 350, ItemsDeleted: 2},
+	}, byDevice)
+}
+
+func TestGroupSavings_Empty(t *testing.T) {
+	byCategory, byDevice := groupSavings(nil)
+
+	assert.NotNil(t, byCategory)
+	assert.Empty(t, byCategory)
+	assert.NotNil(t, byDevice)
+	assert.Empty(t, byDevice)
+}
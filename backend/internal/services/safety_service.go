@@ -0,0 +1,296 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/purespace/backend/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrSafetyViolation       = errors.New("cleanup would break the safety rules")
+	ErrInvalidSafetySettings = errors.New("invalid safety settings")
+)
+
+// Keep-copy scopes: where at least one copy of each content must remain
+const (
+	KeepCopyPerUser   = "user"
+	KeepCopyPerDevice = "device"
+)
+
+// Safety rules
+const (
+	RuleLastCopy      = "last_copy"
+	RuleProtectedPath = "protected_path"
+)
+
+const maxProtectedPaths = 100
+
+// SafetyError lists the violations that stopped a cleanup
+type SafetyError struct {
+	Violations []models.SafetyViolation
+}
+
+func (e *SafetyError) Error() string {
+	return fmt.Sprintf("%s: %d violation(s)", ErrSafetyViolation, len(e.Violations))
+}
+
+func (e *SafetyError) Unwrap() error {
+	return ErrSafetyViolation
+}
+
+// SafetyService manages the safeguards checked before a cleanup deletes files: files under
+// protected paths are never deleted, and neither is the last copy of any content unless
+// the plan carries an explicit override
+type SafetyService struct {
+	db *gorm.DB
+}
+
+func NewSafetyService(db *gorm.DB) *SafetyService {
+	return &SafetyService{
+		db: db,
+	}
+}
+
+type UpdateSafetySettingsRequest struct {
+	KeepCopyScope  string   `json:"keep_copy_scope" binding:"required,oneof=user device"`
+	ProtectedPaths []string `json:"protected_paths"`
+}
+
+// GetSettings returns the user's safety settings, or the defaults if none were saved
+func (s *SafetyService) GetSettings(ctx context.Context, userID uuid.UUID) (*models.SafetySettings, error) {
+	return loadSafetySettings(s.db.WithContext(ctx), userID)
+}
+
+// UpdateSettings replaces the user's safety settings
+func (s *SafetyService) UpdateSettings(ctx context.Context, userID uuid.UUID, req *UpdateSafetySettingsRequest) (*models.SafetySettings, error) {
+	if len(req.ProtectedPaths) > maxProtectedPaths {
+		return nil, fmt.Errorf("%w: at most %d protected paths are allowed", ErrInvalidSafetySettings, maxProtectedPaths)
+	}
+
+	paths := models.StringList{}
+	for _, pattern := range req.ProtectedPaths {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if _, err := compilePathGlob(pattern); err != nil {
+			return nil, fmt.Errorf("%w: protected path %q: %v", ErrInvalidSafetySettings, pattern, err)
+		}
+		paths = append(paths, pattern)
+	}
+
+	settings := &models.SafetySettings{
+		UserID:         userID,
+		KeepCopyScope:  req.KeepCopyScope,
+		ProtectedPaths: paths,
+	}
+	err := s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"keep_copy_scope", "protected_paths", "updated_at"}),
+	}).Create(settings).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to save safety settings: %w", err)
+	}
+
+	return loadSafetySettings(s.db.WithContext(ctx), userID)
+}
+
+// ListOverrides returns the audit trail of cleanups committed despite violations, newest first
+func (s *SafetyService) ListOverrides(ctx context.Context, userID uuid.UUID) ([]models.SafetyOverride, error) {
+	overrides := []models.SafetyOverride{}
+	err := s.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Find(&overrides).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list safety overrides: %w", err)
+	}
+
+	return overrides, nil
+}
+
+func loadSafetySettings(db *gorm.DB, userID uuid.UUID) (*models.SafetySettings, error) {
+	var settings models.SafetySettings
+	err := db.Where("user_id = ?", userID).First(&settings).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &models.SafetySettings{
+			UserID:         userID,
+			KeepCopyScope:  KeepCopyPerUser,
+			ProtectedPaths: models.StringList{},
+		}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get safety settings: %w", err)
+	}
+
+	return &settings, nil
+}
+
+// safetyCopy is a live copy of content that may survive a cleanup
+type safetyCopy struct {
+	ID        uint
+	DeviceID  string
+	PathTail  string
+	SHA256    string
+	CreatedAt time.Time
+}
+
+// checkPlanSafety returns the violations the plan's pending deletions would cause.
+// Files that other committed plans are about to delete do not count as surviving copies.
+func checkPlanSafety(db *gorm.DB, plan *models.CleanupPlan) ([]models.SafetyViolation, error) {
+	settings, err := loadSafetySettings(db, plan.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []models.CleanupPlanItem
+	seen := make(map[string]bool)
+	var hashes []string
+	for _, item := range plan.Items {
+		if item.Status != PlanItemPending {
+			continue
+		}
+		targets = append(targets, item)
+		if !seen[item.SHA256] {
+			seen[item.SHA256] = true
+			hashes = append(hashes, item.SHA256)
+		}
+	}
+	if len(targets) == 0 {
+		return nil, nil
+	}
+
+	copies, err := loadCopies(db, plan.UserID, plan.ID, hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	return evaluateSafety(settings, targets, copies), nil
+}
+
+// blockingViolations returns the violations an override does not cover. An override only
+// allows deleting last copies; protected paths always apply.
+func blockingViolations(violations []models.SafetyViolation, override bool) []models.SafetyViolation {
+	if !override {
+		return violations
+	}
+
+	var blocking []models.SafetyViolation
+	for _, violation := range violations {
+		if violation.Rule != RuleLastCopy {
+			blocking = append(blocking, violation)
+		}
+	}
+	return blocking
+}
+
+// pendingDeletions selects the files other committed plans of the user are about to delete
+func pendingDeletions(db *gorm.DB, userID, planID uuid.UUID) *gorm.DB {
+	return db.Model(&models.CleanupPlanItem{}).
+		Select("cleanup_plan_items.file_id").
+		Joins("JOIN cleanup_plans ON cleanup_plans.id = cleanup_plan_items.plan_id").
+		Where("cleanup_plans.user_id = ? AND cleanup_plans.id <> ? AND cleanup_plans.status IN ? AND cleanup_plan_items.status = ?",
+			userID, planID, []string{PlanCommitted, PlanExecuting}, PlanItemPending)
+}
+
+// loadCopies returns the user's live files with the given hashes, leaving out files that
+// other committed plans are about to delete
+func loadCopies(db *gorm.DB, userID, planID uuid.UUID, hashes []string) ([]safetyCopy, error) {
+	var copies []safetyCopy
+	for i := 0; i < len(hashes); i += upsertBatchSize {
+		end := i + upsertBatchSize
+		if end > len(hashes) {
+			end = len(hashes)
+		}
+
+		var batch []safetyCopy
+		err := db.Model(&models.File{}).
+			Select("id, device_id, path_tail, sha256, created_at").
+			Where("user_id = ? AND sha256 IN ?", userID, hashes[i:end]).
+			Where("id NOT IN (?)", pendingDeletions(db, userID, planID)).
+			Scan(&batch).Error
+		if err != nil {
+			return nil, fmt.Errorf("failed to load copies: %w", err)
+		}
+		copies = append(copies, batch...)
+	}
+
+	return copies, nil
+}
+
+// evaluateSafety applies the rules to the files a cleanup would delete. copies holds the
+// live copies of the same content, including the targets themselves.
+func evaluateSafety(settings *models.SafetySettings, targets []models.CleanupPlanItem, copies []safetyCopy) []models.SafetyViolation {
+	perDevice := settings.KeepCopyScope == KeepCopyPerDevice
+	key := func(deviceID, sha256 string) string {
+		if perDevice {
+			return deviceID + "\x00" + sha256
+		}
+		return sha256
+	}
+
+	targeted := make(map[uint]bool, len(targets))
+	for _, target := range targets {
+		targeted[target.FileID] = true
+	}
+
+	survivors := make(map[string]int)
+	for _, c := range copies {
+		if !targeted[c.ID] {
+			survivors[key(c.DeviceID, c.SHA256)]++
+		}
+	}
+
+	var violations []models.SafetyViolation
+
+	lastCopies := make(map[string]int) // Index into violations by content key
+	for _, target := range targets {
+		k := key(target.DeviceID, target.SHA256)
+		if survivors[k] > 0 {
+			continue
+		}
+		if i, ok := lastCopies[k]; ok {
+			violations[i].FileIDs = append(violations[i].FileIDs, target.FileID)
+			continue
+		}
+
+		violation := models.SafetyViolation{Rule: RuleLastCopy, SHA256: target.SHA256, FileIDs: []uint{target.FileID}}
+		if perDevice {
+			violation.DeviceID = target.DeviceID
+		}
+		lastCopies[k] = len(violations)
+		violations = append(violations, violation)
+	}
+
+	for _, pattern := range settings.ProtectedPaths {
+		re, err := compilePathGlob(pattern)
+		if err != nil {
+			continue // Patterns are validated when saved
+		}
+		violations = appendProtected(violations, pattern, re, targets)
+	}
+
+	return violations
+}
+
+func appendProtected(violations []models.SafetyViolation, pattern string, re *regexp.Regexp, targets []models.CleanupPlanItem) []models.SafetyViolation {
+	var fileIDs []uint
+	for _, target := range targets {
+		if re.MatchString(target.PathTail) {
+			fileIDs = append(fileIDs, target.FileID)
+		}
+	}
+	if len(fileIDs) == 0 {
+		return violations
+	}
+
+	return append(violations, models.SafetyViolation{Rule: RuleProtectedPath, Pattern: pattern, FileIDs: fileIDs})
+}
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/purespace/backend/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvaluateSafety(t *testing.T) {
+	targets := []models.CleanupPlanItem{
+		{FileID: 1, DeviceID: "pixel", PathTail: "Download/a.jpg", SHA256: "aaa", Status: PlanItemPending},
+		{FileID: 2, DeviceID: "pixel", PathTail: "Download/b.jpg", SHA256: "bbb", Status: PlanItemPending},
+		{FileID: 3, DeviceID: "tablet", PathTail: "DCIM/Camera/b.jpg", SHA256: "bbb", Status: PlanItemPending},
+	}
+
+	tests := []struct {
+		name     string
+		settings models.SafetySettings
+		copies   []safetyCopy
+		expected []models.SafetyViolation
+	}{
+		{
+			name:     "Another copy survives",
+			settings: models.SafetySettings{KeepCopyScope: KeepCopyPerUser},
+			copies: []safetyCopy{
+				{ID: 1, DeviceID: "pixel", SHA256: "aaa"},
+				{ID: 4, DeviceID: "tablet", SHA256: "aaa"},
+				{ID: 2, DeviceID: "pixel", SHA256: "bbb"},
+				{ID: 3, DeviceID: "tablet", SHA256: "bbb"},
+				{ID: 5, DeviceID: "pixel", SHA256: "bbb"},
+			},
+		},
+		{
+			name:     "Last copy of a hash",
+			settings: models.SafetySettings{KeepCopyScope: KeepCopyPerUser},
+			copies: []safetyCopy{
+				{ID: 1, DeviceID: "pixel", SHA256: "aaa"},
+				{ID: 4, DeviceID: "tablet", SHA256: "aaa"},
+				{ID: 2, DeviceID: "pixel", SHA256: "bbb"},
+				{ID: 3, DeviceID: "tablet", SHA256: "bbb"},
+			},
+			expected: []models.SafetyViolation{
+				{Rule: RuleLastCopy, SHA256: "bbb", FileIDs: []uint{2, 3}},
+			},
+		},
+		{
+			name:     "Last copy on a device",
+			settings: models.SafetySettings{KeepCopyScope: KeepCopyPerDevice},
+			copies: []safetyCopy{
+				{ID: 1, DeviceID: "pixel", SHA256: "aaa"},
+				{ID: 4, DeviceID: "tablet", SHA256: "aaa"},
+				{ID: 2, DeviceID: "pixel", SHA256: "bbb"},
+				{ID: 3, DeviceID: "tablet", SHA256: "bbb"},
+				{ID: 5, DeviceID: "pixel", SHA256: "bbb"},
+			},
+			expected: []models.SafetyViolation{
+				{Rule: RuleLastCopy, SHA256: "aaa", DeviceID: "pixel", FileIDs: []uint{1}},
+				{Rule: RuleLastCopy, SHA256: "bbb", DeviceID: "tablet", FileIDs: []uint{3}},
+			},
+		},
+		{
+			name:     "Protected path",
+			settings: models.SafetySettings{KeepCopyScope: KeepCopyPerUser, ProtectedPaths: models.StringList{"DCIM/Camera/**", "Music"}},
+			copies: []safetyCopy{
+				{ID: 1, DeviceID: "pixel", SHA256: "aaa"},
+				{ID: 4, DeviceID: "tablet", SHA256: "aaa"},
+				{ID: 2, DeviceID: "pixel", SHA256: "bbb"},
+				{ID: 3, DeviceID: "tablet", SHA256: "bbb"},
+				{ID: 5, DeviceID: "pixel", SHA256: "bbb"},
+			},
+			expected: []models.SafetyViolation{
+				{Rule: RuleProtectedPath, Pattern: "DCIM/Camera/**", FileIDs: []uint{3}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, evaluateSafety(&tt.settings, targets, tt.copies))
+		})
+	}
+}
+
+func TestSafetyError(t *testing.T) {
+	var err error = &SafetyError{Violations: []models.SafetyViolation{{Rule: RuleLastCopy, SHA256: "aaa", FileIDs: []uint{1}}}}
+
+	assert.True(t, errors.Is(err, ErrSafetyViolation))
+	assert.Equal(t, "cleanup would break the safety rules: 1 violation(s)", err.Error())
+}
@@ -0,0 +1,395 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	_ "time/tzdata" // The runtime image has no zoneinfo, and schedules use the user's timezone
+
+	"github.com/google/uuid"
+	"github.com/purespace/backend/internal/models"
+	"github.com/robfig/cron/v3"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrScanScheduleNotFound = errors.New("scan schedule not found")
+	ErrInvalidScanSchedule  = errors.New("invalid scan schedule")
+)
+
+// Scan schedule frequencies
+const (
+	FrequencyDaily   = "daily"
+	FrequencyWeekly  = "weekly"
+	FrequencyMonthly = "monthly"
+	FrequencyCron    = "cron"
+)
+
+// Scan run states
+const (
+	ScanRequested = "requested"
+	ScanCompleted = "completed"
+	ScanFailed    = "failed"
+	ScanMissed    = "missed" // Not acknowledged by the time the next scan was due
+)
+
+const (
+	minScanInterval     = time.Hour
+	maxScheduleBatch    = 100
+	maxMissedScans      = 1000 // Occurrences counted when catching up after downtime
+	defaultScanRunLimit = 50
+	maxScanRunLimit     = 500
+)
+
+// ScanService keeps per-user scan schedules and, when a scan is due, queues a scan action
+// for each of the user's devices. Scans a device has not acknowledged by the next due
+// time are recorded as missed.
+type ScanService struct {
+	db            *gorm.DB
+	actionService *ActionService
+}
+
+func NewScanService(db *gorm.DB, actionService *ActionService) *ScanService {
+	return &ScanService{
+		db:            db,
+		actionService: actionService,
+	}
+}
+
+type ScanScheduleRequest struct {
+	Frequency  string `json:"frequency" binding:"required,oneof=daily weekly monthly cron"`
+	TimeOfDay  string `json:"time_of_day"`
+	Weekday    int    `json:"weekday" binding:"min=0,max=6"`
+	DayOfMonth int    `json:"day_of_month" binding:"omitempty,min=1,max=28"`
+	CronExpr   string `json:"cron_expr"`
+	Timezone   string `json:"timezone" binding:"required"`
+	Enabled    *bool  `json:"enabled"`
+}
+
+// GetSchedule returns the user's scan schedule
+func (s *ScanService) GetSchedule(ctx context.Context, userID uuid.UUID) (*models.ScanSchedule, error) {
+	var schedule models.ScanSchedule
+	err := s.db.WithContext(ctx).Where("user_id = ?", userID).First(&schedule).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrScanScheduleNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get scan schedule: %w", err)
+	}
+
+	return &schedule, nil
+}
+
+// UpdateSchedule sets the user's scan schedule. The next scan is the first occurrence
+// after now in the schedule's timezone.
+func (s *ScanService) UpdateSchedule(ctx context.Context, userID uuid.UUID, req *ScanScheduleRequest) (*models.ScanSchedule, error) {
+	if err := requirePremium(s.db.WithContext(ctx), userID); err != nil {
+		return nil, err
+	}
+
+	schedule := &models.ScanSchedule{
+		UserID:     userID,
+		Frequency:  req.Frequency,
+		TimeOfDay:  strings.TrimSpace(req.TimeOfDay),
+		Weekday:    req.Weekday,
+		DayOfMonth: req.DayOfMonth,
+		CronExpr:   strings.TrimSpace(req.CronExpr),
+		Timezone:   req.Timezone,
+		Enabled:    req.Enabled == nil || *req.Enabled,
+	}
+	sched, loc, err := parseScanSchedule(schedule)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkScanInterval(sched, time.Now().In(loc)); err != nil {
+		return nil, err
+	}
+	schedule.NextRunAt = sched.Next(time.Now().In(loc))
+
+	err = s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{
+			"frequency", "time_of_day", "weekday", "day_of_month", "cron_expr", "timezone", "enabled", "next_run_at", "updated_at",
+		}),
+	}).Create(schedule).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to save scan schedule: %w", err)
+	}
+
+	return s.GetSchedule(ctx, userID)
+}
+
+// DeleteSchedule stops automatic scans for the user
+func (s *ScanService) DeleteSchedule(ctx context.Context, userID uuid.UUID) error {
+	result := s.db.WithContext(ctx).Where("user_id = ?", userID).Delete(&models.ScanSchedule{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete scan schedule: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrScanScheduleNotFound
+	}
+
+	return nil
+}
+
+// ListRuns returns the user's requested scans, newest first
+func (s *ScanService) ListRuns(ctx context.Context, userID uuid.UUID, limit int) ([]models.ScanRun, error) {
+	if limit <= 0 {
+		limit = defaultScanRunLimit
+	}
+	if limit > maxScanRunLimit {
+		limit = maxScanRunLimit
+	}
+
+	runs := []models.ScanRun{}
+	err := s.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("due_at DESC, id DESC").
+		Limit(limit).
+		Find(&runs).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list scan runs: %w", err)
+	}
+
+	return runs, nil
+}
+
+// RunDue requests the scans that are due and returns how many schedules fired. It should
+// run on one replica at a time, under a Lease; due schedules are also claimed with SKIP
+// LOCKED, so an overlapping run does not request a scan twice.
+func (s *ScanService) RunDue(ctx context.Context) (int, error) {
+	var due []models.ScanSchedule
+	requested := make(map[uuid.UUID][]string)
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("enabled = ? AND next_run_at <= ?", true, now).
+			Order("next_run_at ASC").
+			Limit(maxScheduleBatch).
+			Find(&due).Error
+		if err != nil {
+			return err
+		}
+
+		for i := range due {
+			deviceIDs, err := requestScans(tx, &due[i], now)
+			if err != nil {
+				return err
+			}
+			if len(deviceIDs) > 0 {
+				requested[due[i].UserID] = deviceIDs
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to request scans: %w", err)
+	}
+
+	for userID, deviceIDs := range requested {
+		s.actionService.notify(ctx, userID, deviceIDs)
+	}
+
+	return len(due), nil
+}
+
+// requestScans fires a due schedule: scans still unacknowledged are marked missed, a scan
+// action is queued for each device and the schedule moves to its next occurrence.
+// Occurrences that passed while no scheduler was running are counted as missed and
+// replaced by this one scan.
+func requestScans(tx *gorm.DB, schedule *models.ScanSchedule, now time.Time) ([]string, error) {
+	sched, loc, err := parseScanSchedule(schedule)
+	if err != nil {
+		// Saved schedules were valid; turn off one that no longer parses rather than retrying it
+		return nil, tx.Model(&models.ScanSchedule{}).Where("user_id = ?", schedule.UserID).Update("enabled", false).Error
+	}
+
+	due, skipped, next := dueOccurrences(sched, loc, schedule.NextRunAt, now)
+	updates := map[string]interface{}{"next_run_at": next}
+
+	premium, err := isPremium(tx, schedule.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if !premium {
+		// Schedules of lapsed subscriptions are kept but request nothing
+		return nil, tx.Model(&models.ScanSchedule{}).Where("user_id = ?", schedule.UserID).Updates(updates).Error
+	}
+
+	var stale []models.ScanRun
+	if err := tx.Where("user_id = ? AND status = ?", schedule.UserID, ScanRequested).Find(&stale).Error; err != nil {
+		return nil, fmt.Errorf("failed to load scan runs: %w", err)
+	}
+	if len(stale) > 0 {
+		runIDs := make([]uint, len(stale))
+		actionIDs := make([]uuid.UUID, len(stale))
+		for i, run := range stale {
+			runIDs[i] = run.ID
+			actionIDs[i] = run.ActionID
+		}
+		if err := tx.Model(&models.ScanRun{}).Where("id IN ?", runIDs).Update("status", ScanMissed).Error; err != nil {
+			return nil, fmt.Errorf("failed to mark missed scans: %w", err)
+		}
+		err := tx.Model(&models.DeviceAction{}).
+			Where("id IN ? AND status IN ?", actionIDs, []string{ActionPending, ActionDelivered}).
+			Updates(map[string]interface{}{"status": ActionCancelled, "error": "missed"}).Error
+		if err != nil {
+			return nil, fmt.Errorf("failed to cancel missed scans: %w", err)
+		}
+	}
+
+	var deviceIDs []string
+	if err := tx.Model(&models.Device{}).Where("user_id = ?", schedule.UserID).Order("device_id ASC").Pluck("device_id", &deviceIDs).Error; err != nil {
+		return nil, fmt.Errorf("failed to list devices: %w", err)
+	}
+
+	actions := make([]models.DeviceAction, len(deviceIDs))
+	runs := make([]models.ScanRun, len(deviceIDs))
+	for i, deviceID := range deviceIDs {
+		actions[i] = models.DeviceAction{
+			ID:       uuid.New(),
+			UserID:   schedule.UserID,
+			DeviceID: deviceID,
+			Type:     ActionScan,
+			Status:   ActionPending,
+		}
+		runs[i] = models.ScanRun{
+			UserID:   schedule.UserID,
+			DeviceID: deviceID,
+			DueAt:    due,
+			ActionID: actions[i].ID,
+			Status:   ScanRequested,
+		}
+	}
+	if err := enqueueActions(tx, actions); err != nil {
+		return nil, err
+	}
+	if len(runs) > 0 {
+		if err := tx.Create(&runs).Error; err != nil {
+			return nil, fmt.Errorf("failed to record scan runs: %w", err)
+		}
+	}
+
+	updates["last_run_at"] = now
+	updates["missed_scans"] = gorm.Expr("missed_scans + ?", skipped+len(stale))
+	if err := tx.Model(&models.ScanSchedule{}).Where("user_id = ?", schedule.UserID).Updates(updates).Error; err != nil {
+		return nil, fmt.Errorf("failed to advance scan schedule: %w", err)
+	}
+
+	return deviceIDs, nil
+}
+
+// completeScanRun records the device's acknowledgement of a scan action
+func completeScanRun(tx *gorm.DB, action *models.DeviceAction, now time.Time) error {
+	status := ScanCompleted
+	if action.Status == ActionFailed {
+		status = ScanFailed
+	}
+
+	err := tx.Model(&models.ScanRun{}).
+		Where("action_id = ? AND status = ?", action.ID, ScanRequested).
+		Updates(map[string]interface{}{
+			"status":       status,
+			"error":        action.Error,
+			"completed_at": now,
+		}).Error
+	if err != nil {
+		return fmt.Errorf("failed to complete scan run: %w", err)
+	}
+
+	return nil
+}
+
+// dueOccurrences walks a schedule from its due time up to now. It returns the latest
+// occurrence that is due, how many earlier ones were skipped, and the next one after now.
+func dueOccurrences(sched cron.Schedule, loc *time.Location, nextRunAt, now time.Time) (due time.Time, skipped int, next time.Time) {
+	due = nextRunAt
+	next = sched.Next(due.In(loc))
+	for !next.After(now) {
+		if skipped == maxMissedScans {
+			// Too far behind to count every occurrence; jump to the present
+			return now, skipped, sched.Next(now.In(loc))
+		}
+		skipped++
+		due = next
+		next = sched.Next(due.In(loc))
+	}
+
+	return due, skipped, next
+}
+
+func parseScanSchedule(schedule *models.ScanSchedule) (cron.Schedule, *time.Location, error) {
+	loc, err := time.LoadLocation(schedule.Timezone)
+	if err != nil || schedule.Timezone == "" {
+		return nil, nil, fmt.Errorf("%w: unknown timezone %q", ErrInvalidScanSchedule, schedule.Timezone)
+	}
+
+	spec, err := scanSpec(schedule)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sched, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidScanSchedule, err)
+	}
+
+	return sched, loc, nil
+}
+
+// scanSpec returns the five-field cron expression for a schedule
+func scanSpec(schedule *models.ScanSchedule) (string, error) {
+	if schedule.Frequency == FrequencyCron {
+		if schedule.CronExpr == "" {
+			return "", fmt.Errorf("%w: cron_expr is required", ErrInvalidScanSchedule)
+		}
+		if strings.Contains(schedule.CronExpr, "TZ=") {
+			return "", fmt.Errorf("%w: set the timezone with timezone, not in cron_expr", ErrInvalidScanSchedule)
+		}
+		return schedule.CronExpr, nil
+	}
+
+	at, err := time.Parse("15:04", schedule.TimeOfDay)
+	if err != nil {
+		return "", fmt.Errorf("%w: time_of_day must be HH:MM", ErrInvalidScanSchedule)
+	}
+
+	switch schedule.Frequency {
+	case FrequencyDaily:
+		return fmt.Sprintf("%d %d * * *", at.Minute(), at.Hour()), nil
+	case FrequencyWeekly:
+		if schedule.Weekday < 0 || schedule.Weekday > 6 {
+			return "", fmt.Errorf("%w: weekday must be 0 (Sunday) to 6", ErrInvalidScanSchedule)
+		}
+		return fmt.Sprintf("%d %d * * %d", at.Minute(), at.Hour(), schedule.Weekday), nil
+	case FrequencyMonthly:
+		if schedule.DayOfMonth < 1 || schedule.DayOfMonth > 28 {
+			return "", fmt.Errorf("%w: day_of_month must be 1 to 28", ErrInvalidScanSchedule)
+		}
+		return fmt.Sprintf("%d %d %d * *", at.Minute(), at.Hour(), schedule.DayOfMonth), nil
+	default:
+		return "", fmt.Errorf("%w: unknown frequency %q", ErrInvalidScanSchedule, schedule.Frequency)
+	}
+}
+
+// checkScanInterval rejects cron expressions that would scan more than once an hour
+func checkScanInterval(sched cron.Schedule, from time.Time) error {
+	prev := sched.Next(from)
+	if prev.IsZero() {
+		return fmt.Errorf("%w: the schedule never runs", ErrInvalidScanSchedule)
+	}
+	for i := 0; i < 50; i++ {
+		next := sched.Next(prev)
+		if next.Sub(prev) < minScanInterval {
+			return fmt.Errorf("%w: scans may run at most once an hour", ErrInvalidScanSchedule)
+		}
+		prev = next
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/purespace/backend/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScanSpec(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule models.ScanSchedule
+		expected string
+		wantErr  bool
+	}{
+		{"Daily", models.ScanSchedule{Frequency: FrequencyDaily, TimeOfDay: "03:30"}, "30 3 * * *", false},
+		{"Weekly", models.ScanSchedule{Frequency: FrequencyWeekly, TimeOfDay: "21:00", Weekday: 0}, "0 21 * * 0", false},
+		{"Monthly", models.ScanSchedule{Frequency: FrequencyMonthly, TimeOfDay: "08:05", DayOfMonth: 15}, "5 8 15 * *", false},
+		{"Cron", models.ScanSchedule{Frequency: FrequencyCron, CronExpr: "0 2 * * 1-5"}, "0 2 * * 1-5", false},
+		{"Missing time", models.ScanSchedule{Frequency: FrequencyDaily}, "", true},
+		{"Bad time", models.ScanSchedule{Frequency: FrequencyDaily, TimeOfDay: "25:00"}, "", true},
+		{"Day 31", models.ScanSchedule{Frequency: FrequencyMonthly, TimeOfDay: "08:00", DayOfMonth: 31}, "", true},
+		{"Missing cron", models.ScanSchedule{Frequency: FrequencyCron}, "", true},
+		{"Timezone in cron", models.ScanSchedule{Frequency: FrequencyCron, CronExpr: "CRON_TZ=UTC 0 2 * * *"}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec, err := scanSpec(&tt.schedule)
+			if tt.wantErr {
+				assert.True(t, errors.Is(err, ErrInvalidScanSchedule))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, spec)
+		})
+	}
+}
+
+func TestParseScanSchedule_Timezone(t *testing.T) {
+	schedule := &models.ScanSchedule{Frequency: FrequencyDaily, TimeOfDay: "03:00", Timezone: "Europe/Berlin"}
+	sched, loc, err := parseScanSchedule(schedule)
+	require.NoError(t, err)
+
+	// 03:00 in Berlin is 01:00 UTC in summer
+	next := sched.Next(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC).In(loc))
+	assert.Equal(t, time.Date(2024, 6, 2, 1, 0, 0, 0, time.UTC), next.UTC())
+
+	schedule.Timezone = "Mars/Olympus"
+	_, _, err = parseScanSchedule(schedule)
+	assert.True(t, errors.Is(err, ErrInvalidScanSchedule))
+}
+
+func TestDueOccurrences(t *testing.T) {
+	schedule := &models.ScanSchedule{Frequency: FrequencyDaily, TimeOfDay: "03:00", Timezone: "UTC"}
+	sched, loc, err := parseScanSchedule(schedule)
+	require.NoError(t, err)
+
+	dueAt := time.Date(2024, 6, 1, 3, 0, 0, 0, time.UTC)
+
+	t.Run("On time", func(t *testing.T) {
+		due, skipped, next := dueOccurrences(sched, loc, dueAt, dueAt.Add(time.Minute))
+		assert.Equal(t, dueAt, due)
+		assert.Equal(t, 0, skipped)
+		assert.Equal(t, dueAt.AddDate(0, 0, 1), next)
+	})
+
+	t.Run("After downtime", func(t *testing.T) {
+		due, skipped, next := dueOccurrences(sched, loc, dueAt, dueAt.AddDate(0, 0, 3).Add(time.Hour))
+		assert.Equal(t, dueAt.AddDate(0, 0, 3), due)
+		assert.Equal(t, 3, skipped)
+		assert.Equal(t, dueAt.AddDate(0, 0, 4), next)
+	})
+}
+
+func TestCheckScanInterval(t *testing.T) {
+	from := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	for spec, wantErr := range map[string]bool{
+		"0 3 * * *":    false,
+		"0 */2 * * *":  false,
+		"*/10 * * * *": true,
+		"0,30 9 * * *": true,
+	} {
+		schedule := &models.ScanSchedule{Frequency: FrequencyCron, CronExpr: spec, Timezone: "UTC"}
+		sched, _, err := parseScanSchedule(schedule)
+		require.NoError(t, err, spec)
+
+		err = checkScanInterval(sched, from)
+		assert.Equal(t, wantErr, err != nil, spec)
+	}
+}
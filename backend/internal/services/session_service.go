@@ -0,0 +1,264 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/purespace/backend/internal/models"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token was already used; the session has been revoked")
+)
+
+const refreshTokenLen = 32
+
+// SessionService issues access and refresh token pairs, rotates refresh tokens and
+// revokes sessions. Revoked access tokens are kept in a Redis denylist, keyed by their
+// jti, until they expire.
+type SessionService struct {
+	db              *gorm.DB
+	redis           *redis.Client
+	authService     *AuthService
+	refreshTokenTTL time.Duration
+}
+
+func NewSessionService(db *gorm.DB, redis *redis.Client, authService *AuthService, refreshTokenTTL time.Duration) *SessionService {
+	return &SessionService{
+		db:              db,
+		redis:           redis,
+		authService:     authService,
+		refreshTokenTTL: refreshTokenTTL,
+	}
+}
+
+// TokenPair is a short-lived access token and the refresh token that renews it
+type TokenPair struct {
+	AccessToken      string    `json:"token"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	RefreshToken     string    `json:"refresh_token"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+}
+
+// Issue starts a new session for a user who just signed in
+func (s *SessionService) Issue(ctx context.Context, userID uuid.UUID, email string) (*TokenPair, error) {
+	var pair *TokenPair
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		pair, err = s.issue(tx, userID, email, uuid.New(), time.Now())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return pair, nil
+}
+
+// Refresh exchanges a refresh token for a new pair. The presented token is rotated and
+// cannot be used again; if it already was, the token leaked and every token of its
+// session is revoked.
+func (s *SessionService) Refresh(ctx context.Context, refreshToken string) (*TokenPair, error) {
+	var pair *TokenPair
+	var reused bool
+	var revoked []models.RefreshToken
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+
+		var token models.RefreshToken
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ?", hashRefreshToken(refreshToken)).
+			Take(&token).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidRefreshToken
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get refresh token: %w", err)
+		}
+
+		switch {
+		case token.RevokedAt != nil || !now.Before(token.ExpiresAt):
+			return ErrInvalidRefreshToken
+		case token.RotatedAt != nil:
+			// Revoke the family in this transaction but report the reuse after it commits
+			reused = true
+			revoked, err = revokeFamily(tx, token.FamilyID, now)
+			return err
+		}
+
+		err = tx.Model(&models.RefreshToken{}).Where("id = ?", token.ID).Update("rotated_at", now).Error
+		if err != nil {
+			return fmt.Errorf("failed to rotate refresh token: %w", err)
+		}
+
+		var user models.User
+		if err := tx.Select("id", "email").Where("id = ?", token.UserID).Take(&user).Error; err != nil {
+			return fmt.Errorf("failed to get user: %w", err)
+		}
+
+		pair, err = s.issue(tx, user.ID, user.Email, token.FamilyID, now)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if reused {
+		if err := s.denyAccessTokens(ctx, revoked); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	}
+
+	return pair, nil
+}
+
+// Logout revokes the access token it was called with and, if given, the refresh token
+// of the same session along with its whole family
+func (s *SessionService) Logout(ctx context.Context, claims *Claims, refreshToken string) error {
+	if claims.ExpiresAt != nil {
+		if err := s.deny(ctx, claims.ID, claims.ExpiresAt.Time); err != nil {
+			return err
+		}
+	}
+	if refreshToken == "" {
+		return nil
+	}
+
+	var revoked []models.RefreshToken
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var token models.RefreshToken
+		err := tx.Where("token_hash = ? AND user_id = ?", hashRefreshToken(refreshToken), claims.UserID).Take(&token).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidRefreshToken
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get refresh token: %w", err)
+		}
+
+		revoked, err = revokeFamily(tx, token.FamilyID, time.Now())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.denyAccessTokens(ctx, revoked)
+}
+
+// IsRevoked reports whether the access token with the given jti was revoked
+func (s *SessionService) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	n, err := s.redis.Exists(ctx, denylistKey(jti)).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to check token denylist: %w", err)
+	}
+
+	return n > 0, nil
+}
+
+// PurgeExpired deletes refresh tokens that expired and returns how many it deleted
+func (s *SessionService) PurgeExpired(ctx context.Context) (int64, error) {
+	result := s.db.WithContext(ctx).Where("expires_at < ?", time.Now()).Delete(&models.RefreshToken{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to purge refresh tokens: %w", result.Error)
+	}
+
+	return result.RowsAffected, nil
+}
+
+// issue signs an access token and stores a new refresh token in the given family
+func (s *SessionService) issue(tx *gorm.DB, userID uuid.UUID, email string, familyID uuid.UUID, now time.Time) (*TokenPair, error) {
+	accessToken, claims, err := s.authService.issueAccessToken(userID, email, now)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := make([]byte, refreshTokenLen)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+	refreshToken := base64.RawURLEncoding.EncodeToString(raw)
+
+	token := &models.RefreshToken{
+		UserID:          userID,
+		FamilyID:        familyID,
+		TokenHash:       hashRefreshToken(refreshToken),
+		AccessTokenID:   claims.ID,
+		AccessExpiresAt: claims.ExpiresAt.Time,
+		ExpiresAt:       now.Add(s.refreshTokenTTL),
+	}
+	if err := tx.Create(token).Error; err != nil {
+		return nil, fmt.Errorf("failed to store refresh token: %w", err)
+	}
+
+	return &TokenPair{
+		AccessToken:      accessToken,
+		ExpiresAt:        claims.ExpiresAt.Time,
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: token.ExpiresAt,
+	}, nil
+}
+
+// revokeFamily revokes every token of a session and returns those whose access tokens
+// may still be valid
+func revokeFamily(tx *gorm.DB, familyID uuid.UUID, now time.Time) ([]models.RefreshToken, error) {
+	err := tx.Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", now).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to revoke session: %w", err)
+	}
+
+	live := []models.RefreshToken{}
+	err = tx.Select("access_token_id", "access_expires_at").
+		Where("family_id = ? AND access_expires_at > ?", familyID, now).
+		Find(&live).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session access tokens: %w", err)
+	}
+
+	return live, nil
+}
+
+func (s *SessionService) denyAccessTokens(ctx context.Context, tokens []models.RefreshToken) error {
+	for _, token := range tokens {
+		if err := s.deny(ctx, token.AccessTokenID, token.AccessExpiresAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deny adds an access token to the denylist until it expires
+func (s *SessionService) deny(ctx context.Context, jti string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if jti == "" || ttl <= 0 {
+		return nil
+	}
+
+	if err := s.redis.Set(ctx, denylistKey(jti), 1, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to revoke access token: %w", err)
+	}
+	return nil
+}
+
+func denylistKey(jti string) string {
+	return "denylist:jti:" + jti
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
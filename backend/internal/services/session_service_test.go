@@ -0,0 +1,102 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/purespace/backend/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSessionService(t *testing.T) (*SessionService, *models.User) {
+	db := newTestDB(t, &models.User{}, &models.RefreshToken{})
+	authService := NewAuthService("test-secret-key", 15*time.Minute, nil)
+
+	user := &models.User{Email: "test@example.com", Provider: "google"}
+	require.NoError(t, db.Create(user).Error)
+
+	return NewSessionService(db, newTestRedis(t), authService, 30*24*time.Hour), user
+}
+
+func accessTokenID(t *testing.T, s *SessionService, accessToken string) string {
+	claims, err := s.authService.ValidateJWT(accessToken)
+	require.NoError(t, err)
+	require.NotEmpty(t, claims.ID)
+	return claims.ID
+}
+
+func TestSessionService_Refresh_Rotates(t *testing.T) {
+	ctx := context.Background()
+	s, user := newTestSessionService(t)
+
+	first, err := s.Issue(ctx, user.ID, user.Email)
+	require.NoError(t, err)
+
+	second, err := s.Refresh(ctx, first.RefreshToken)
+	require.NoError(t, err)
+	assert.NotEqual(t, first.RefreshToken, second.RefreshToken)
+	assert.NotEqual(t, accessTokenID(t, s, first.AccessToken), accessTokenID(t, s, second.AccessToken))
+
+	third, err := s.Refresh(ctx, second.RefreshToken)
+	require.NoError(t, err)
+	assert.NotEmpty(t, third.AccessToken)
+
+	_, err = s.Refresh(ctx, "not-a-refresh-token")
+	assert.True(t, errors.Is(err, ErrInvalidRefreshToken))
+}
+
+func TestSessionService_Refresh_ReuseRevokesFamily(t *testing.T) {
+	ctx := context.Background()
+	s, user := newTestSessionService(t)
+
+	first, err := s.Issue(ctx, user.ID, user.Email)
+	require.NoError(t, err)
+	second, err := s.Refresh(ctx, first.RefreshToken)
+	require.NoError(t, err)
+
+	// Another login of the same user is a separate family and survives
+	other, err := s.Issue(ctx, user.ID, user.Email)
+	require.NoError(t, err)
+
+	// The rotated token comes back: whoever holds it stole it
+	_, err = s.Refresh(ctx, first.RefreshToken)
+	assert.True(t, errors.Is(err, ErrRefreshTokenReused))
+
+	// Neither the thief's nor the user's tokens of that session work any more
+	_, err = s.Refresh(ctx, second.RefreshToken)
+	assert.True(t, errors.Is(err, ErrInvalidRefreshToken))
+	for _, pair := range []*TokenPair{first, second} {
+		revoked, err := s.IsRevoked(ctx, accessTokenID(t, s, pair.AccessToken))
+		require.NoError(t, err)
+		assert.True(t, revoked)
+	}
+
+	revoked, err := s.IsRevoked(ctx, accessTokenID(t, s, other.AccessToken))
+	require.NoError(t, err)
+	assert.False(t, revoked)
+	_, err = s.Refresh(ctx, other.RefreshToken)
+	assert.NoError(t, err)
+}
+
+func TestSessionService_Refresh_Expired(t *testing.T) {
+	ctx := context.Background()
+	s, user := newTestSessionService(t)
+
+	pair, err := s.Issue(ctx, user.ID, user.Email)
+	require.NoError(t, err)
+
+	err = s.db.Model(&models.RefreshToken{}).
+		Where("token_hash = ?", hashRefreshToken(pair.RefreshToken)).
+		Update("expires_at", time.Now().Add(-time.Second)).Error
+	require.NoError(t, err)
+
+	_, err = s.Refresh(ctx, pair.RefreshToken)
+	assert.True(t, errors.Is(err, ErrInvalidRefreshToken))
+
+	purged, err := s.PurgeExpired(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+}
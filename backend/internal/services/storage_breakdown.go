@@ -0,0 +1,218 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/purespace/backend/internal/models"
+)
+
+const (
+	CategoryImages    = "images"
+	CategoryVideos    = "videos"
+	CategoryAudio     = "audio"
+	CategoryDocuments = "documents"
+	CategoryArchives  = "archives"
+	CategoryAPKs      = "apks"
+	CategoryOther     = "other"
+
+	categoryLargestFiles = 5
+)
+
+// mediaCategories is the order categories are reported in
+var mediaCategories = []string{
+	CategoryImages, CategoryVideos, CategoryAudio, CategoryDocuments, CategoryArchives, CategoryAPKs, CategoryOther,
+}
+
+var categoryMimes = map[string]string{
+	"application/vnd.android.package-archive": CategoryAPKs,
+	"application/pdf":                         CategoryDocuments,
+	"application/msword":                      CategoryDocuments,
+	"application/vnd.ms-excel":                CategoryDocuments,
+	"application/vnd.ms-powerpoint":           CategoryDocuments,
+	"application/rtf":                         CategoryDocuments,
+	"application/epub+zip":                    CategoryDocuments,
+	"application/zip":                         CategoryArchives,
+	"application/gzip":                        CategoryArchives,
+	"application/x-tar":                       CategoryArchives,
+	"application/x-7z-compressed":             CategoryArchives,
+	"application/x-rar-compressed":            CategoryArchives,
+	"application/vnd.rar":                     CategoryArchives,
+	"application/x-bzip2":                     CategoryArchives,
+	"application/x-xz":                        CategoryArchives,
+}
+
+var categoryExtensions = map[string]string{
+	".jpg": CategoryImages, ".jpeg": CategoryImages, ".png": CategoryImages, ".gif": CategoryImages,
+	".webp": CategoryImages, ".heic": CategoryImages, ".heif": CategoryImages, ".bmp": CategoryImages,
+	".dng": CategoryImages, ".raw": CategoryImages,
+	".mp4": CategoryVideos, ".mkv": CategoryVideos, ".webm": CategoryVideos, ".3gp": CategoryVideos,
+	".mov": CategoryVideos, ".avi": CategoryVideos, ".m4v": CategoryVideos,
+	".mp3": CategoryAudio, ".m4a": CategoryAudio, ".aac": CategoryAudio, ".ogg": CategoryAudio,
+	".opus": CategoryAudio, ".flac": CategoryAudio, ".wav": CategoryAudio, ".amr": CategoryAudio,
+	".pdf": CategoryDocuments, ".txt": CategoryDocuments, ".doc": CategoryDocuments, ".docx": CategoryDocuments,
+	".xls": CategoryDocuments, ".xlsx": CategoryDocuments, ".ppt": CategoryDocuments, ".pptx": CategoryDocuments,
+	".odt": CategoryDocuments, ".ods": CategoryDocuments, ".odp": CategoryDocuments, ".rtf": CategoryDocuments,
+	".csv": CategoryDocuments, ".epub": CategoryDocuments,
+	".zip": CategoryArchives, ".rar": CategoryArchives, ".7z": CategoryArchives, ".tar": CategoryArchives,
+	".gz": CategoryArchives, ".tgz": CategoryArchives, ".bz2": CategoryArchives, ".xz": CategoryArchives,
+	".apk": CategoryAPKs, ".apks": CategoryAPKs, ".xapk": CategoryAPKs,
+}
+
+// classifyFile picks a media category from the extension and mime type. APK extensions
+// win because APKs are often reported as application/zip; otherwise a specific mime
+// type wins over the extension.
+func classifyFile(mime, pathTail string) string {
+	ext := strings.ToLower(path.Ext(pathTail))
+	if categoryExtensions[ext] == CategoryAPKs {
+		return CategoryAPKs
+	}
+
+	mime = strings.ToLower(strings.TrimSpace(mime))
+	if i := strings.IndexByte(mime, ';'); i >= 0 {
+		mime = strings.TrimSpace(mime[:i])
+	}
+	switch {
+	case strings.HasPrefix(mime, "image/"):
+		return CategoryImages
+	case strings.HasPrefix(mime, "video/"):
+		return CategoryVideos
+	case strings.HasPrefix(mime, "audio/"):
+		return CategoryAudio
+	case strings.HasPrefix(mime, "text/"),
+		strings.HasPrefix(mime, "application/vnd.openxmlformats-officedocument."),
+		strings.HasPrefix(mime, "application/vnd.oasis.opendocument."):
+		return CategoryDocuments
+	}
+	if category, ok := categoryMimes[mime]; ok {
+		return category
+	}
+
+	if category, ok := categoryExtensions[ext]; ok {
+		return category
+	}
+	return CategoryOther
+}
+
+// breakdownFile is the part of a file the breakdown needs. Duplicate is set on every
+// copy of a content hash except the first one stored.
+type breakdownFile struct {
+	ID        uint
+	DeviceID  string
+	PathTail  string
+	Size      int64
+	Mime      string
+	Duplicate bool
+}
+
+// GetStorageBreakdown reports bytes, counts, duplicate bytes and the largest files per
+// media category, for all devices combined and for each device
+func (s *FileService) GetStorageBreakdown(ctx context.Context, userID uuid.UUID) (*models.StorageBreakdown, error) {
+	cacheKey := fmt.Sprintf("stats:categories:%s", userID.String())
+	cached, err := s.redis.Get(ctx, cacheKey).Result()
+	if err == nil {
+		var breakdown models.StorageBreakdown
+		if json.Unmarshal([]byte(cached), &breakdown) == nil {
+			return &breakdown, nil
+		}
+	}
+
+	files, err := s.loadBreakdownFiles(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	breakdown := buildStorageBreakdown(files)
+
+	// Cache for 5 minutes
+	if breakdownJSON, err := json.Marshal(breakdown); err == nil {
+		s.redis.Set(ctx, cacheKey, breakdownJSON, 5*time.Minute)
+	}
+
+	return breakdown, nil
+}
+
+func (s *FileService) loadBreakdownFiles(ctx context.Context, userID uuid.UUID) ([]breakdownFile, error) {
+	var files []breakdownFile
+	err := s.db.WithContext(ctx).Raw(`SELECT id, device_id, path_tail, size, mime,
+		ROW_NUMBER() OVER (PARTITION BY sha256 ORDER BY id) > 1 AS duplicate
+		FROM files WHERE user_id = ? AND deleted_at IS NULL`, userID).Scan(&files).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load files: %w", err)
+	}
+	return files, nil
+}
+
+func buildStorageBreakdown(files []breakdownFile) *models.StorageBreakdown {
+	combined := newCategoryStats()
+	devices := make(map[string][]models.CategoryStats)
+
+	for _, file := range files {
+		index := categoryIndex(classifyFile(file.Mime, file.PathTail))
+
+		deviceStats, ok := devices[file.DeviceID]
+		if !ok {
+			deviceStats = newCategoryStats()
+			devices[file.DeviceID] = deviceStats
+		}
+
+		addToCategory(&combined[index], file)
+		addToCategory(&deviceStats[index], file)
+	}
+
+	return &models.StorageBreakdown{Combined: combined, Devices: devices}
+}
+
+func newCategoryStats() []models.CategoryStats {
+	stats := make([]models.CategoryStats, len(mediaCategories))
+	for i, category := range mediaCategories {
+		stats[i] = models.CategoryStats{Category: category, LargestFiles: []models.FileSummary{}}
+	}
+	return stats
+}
+
+func categoryIndex(category string) int {
+	for i, c := range mediaCategories {
+		if c == category {
+			return i
+		}
+	}
+	return len(mediaCategories) - 1
+}
+
+func addToCategory(stats *models.CategoryStats, file breakdownFile) {
+	stats.Bytes += file.Size
+	stats.FileCount++
+	if file.Duplicate {
+		stats.DuplicateBytes += file.Size
+	}
+
+	// Keep the largest files sorted, biggest first
+	largest := stats.LargestFiles
+	if len(largest) == categoryLargestFiles && file.Size <= largest[len(largest)-1].Size {
+		return
+	}
+	i := len(largest)
+	for i > 0 && largest[i-1].Size < file.Size {
+		i--
+	}
+	summary := models.FileSummary{
+		ID:       file.ID,
+		DeviceID: file.DeviceID,
+		PathTail: file.PathTail,
+		Size:     file.Size,
+		Mime:     file.Mime,
+	}
+	largest = append(largest, models.FileSummary{})
+	copy(largest[i+1:], largest[i:])
+	largest[i] = summary
+	if len(largest) > categoryLargestFiles {
+		largest = largest[:categoryLargestFiles]
+	}
+	stats.LargestFiles = largest
+}
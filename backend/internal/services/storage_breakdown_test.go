@@ -0,0 +1,84 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		mime     string
+		path     string
+		expected string
+	}{
+		{"JPEG by mime", "image/jpeg", "DCIM/a.jpg", CategoryImages},
+		{"Video by mime", "video/mp4", "Movies/clip", CategoryVideos},
+		{"Audio with parameters", "audio/ogg; codecs=opus", "Voice/note.ogg", CategoryAudio},
+		{"PDF", "application/pdf", "Download/a.pdf", CategoryDocuments},
+		{"Word document", "application/vnd.openxmlformats-officedocument.wordprocessingml.document", "a.docx", CategoryDocuments},
+		{"Plain text", "text/plain", "notes.txt", CategoryDocuments},
+		{"Zip archive", "application/zip", "backup.zip", CategoryArchives},
+		{"APK by mime", "application/vnd.android.package-archive", "Download/app.apk", CategoryAPKs},
+		{"APK reported as zip", "application/zip", "Download/app.APK", CategoryAPKs},
+		{"Missing mime uses extension", "", "DCIM/IMG_1.HEIC", CategoryImages},
+		{"Generic mime uses extension", "application/octet-stream", "Music/song.flac", CategoryAudio},
+		{"Unknown", "application/octet-stream", "data.bin", CategoryOther},
+		{"No extension or mime", "", "README", CategoryOther},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, classifyFile(tt.mime, tt.path))
+		})
+	}
+}
+
+func TestBuildStorageBreakdown(t *testing.T) {
+	files := []breakdownFile{
+		{ID: 1, DeviceID: "pixel", PathTail: "DCIM/a.jpg", Size: 100, Mime: "image/jpeg"},
+		{ID: 2, DeviceID: "pixel", PathTail: "DCIM/b.jpg", Size: 300, Mime: "image/jpeg"},
+		{ID: 3, DeviceID: "tablet", PathTail: "DCIM/a.jpg", Size: 100, Mime: "image/jpeg", Duplicate: true},
+		{ID: 4, DeviceID: "tablet", PathTail: "Download/app.apk", Size: 5000},
+	}
+
+	breakdown := buildStorageBreakdown(files)
+
+	require.Len(t, breakdown.Combined, len(mediaCategories))
+	images := breakdown.Combined[categoryIndex(CategoryImages)]
+	assert.Equal(t, int64(500), images.Bytes)
+	assert.Equal(t, int64(3), images.FileCount)
+	assert.Equal(t, int64(100), images.DuplicateBytes)
+	require.Len(t, images.LargestFiles, 3)
+	assert.Equal(t, uint(2), images.LargestFiles[0].ID)
+
+	apks := breakdown.Combined[categoryIndex(CategoryAPKs)]
+	assert.Equal(t, int64(5000), apks.Bytes)
+
+	videos := breakdown.Combined[categoryIndex(CategoryVideos)]
+	assert.Equal(t, int64(0), videos.Bytes)
+	assert.NotNil(t, videos.LargestFiles)
+
+	require.Contains(t, breakdown.Devices, "tablet")
+	tablet := breakdown.Devices["tablet"]
+	assert.Equal(t, int64(100), tablet[categoryIndex(CategoryImages)].Bytes)
+	assert.Equal(t, int64(100), tablet[categoryIndex(CategoryImages)].DuplicateBytes)
+	assert.Equal(t, int64(0), breakdown.Devices["pixel"][categoryIndex(CategoryImages)].DuplicateBytes)
+}
+
+func TestAddToCategory_KeepsLargestFiles(t *testing.T) {
+	stats := newCategoryStats()[0]
+	for i, size := range []int64{10, 70, 30, 90, 50, 20, 80, 60} {
+		addToCategory(&stats, breakdownFile{ID: uint(i + 1), Size: size})
+	}
+
+	require.Len(t, stats.LargestFiles, categoryLargestFiles)
+	var sizes []int64
+	for _, f := range stats.LargestFiles {
+		sizes = append(sizes, f.Size)
+	}
+	assert.Equal(t, []int64{90, 80, 70, 60, 50}, sizes)
+	assert.Equal(t, int64(8), stats.FileCount)
+}
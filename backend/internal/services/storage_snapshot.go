@@ -0,0 +1,225 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/purespace/backend/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrInvalidGranularity = errors.New("granularity must be day, week or month")
+
+const (
+	GranularityDay   = "day"
+	GranularityWeek  = "week"
+	GranularityMonth = "month"
+)
+
+var snapshotColumns = []string{
+	"file_count", "total_bytes", "duplicate_bytes", "image_bytes", "video_bytes", "audio_bytes",
+	"document_bytes", "archive_bytes", "apk_bytes", "other_bytes", "updated_at",
+}
+
+const (
+	pendingSnapshotsKey = "snapshots:pending"
+	maxSnapshotBatch    = 100
+)
+
+// statsChanged runs after the user's file set changes: it drops cached stats and marks
+// the user's snapshot as due. Both are best effort, like the cache itself; the snapshot
+// is recorded by RecordPendingSnapshots, off the request path.
+func (s *FileService) statsChanged(ctx context.Context, userID uuid.UUID) {
+	s.invalidateUserCache(ctx, userID)
+	s.redis.SAdd(ctx, pendingSnapshotsKey, userID.String())
+}
+
+// RecordPendingSnapshots records today's snapshot for every user whose files changed
+// since the last run and returns how many users it recorded. Users are popped from the
+// pending set, so replicas running at the same time do not record a user twice; a
+// user whose snapshot fails is put back for the next run.
+func (s *FileService) RecordPendingSnapshots(ctx context.Context) (int, error) {
+	recorded := 0
+	for {
+		pending, err := s.redis.SPopN(ctx, pendingSnapshotsKey, maxSnapshotBatch).Result()
+		if err != nil {
+			return recorded, fmt.Errorf("failed to get pending snapshots: %w", err)
+		}
+
+		now := time.Now()
+		for i, id := range pending {
+			userID, err := uuid.Parse(id)
+			if err != nil {
+				continue
+			}
+			if err := s.recordSnapshots(ctx, userID, now); err != nil {
+				s.redis.SAdd(ctx, pendingSnapshotsKey, stringsToInterfaces(pending[i:])...)
+				return recorded, fmt.Errorf("failed to record snapshot of user %s: %w", userID, err)
+			}
+			recorded++
+		}
+
+		if len(pending) < maxSnapshotBatch {
+			return recorded, nil
+		}
+	}
+}
+
+// snapshotGroup is the user's files of one device, mime type and extension, summed in SQL.
+// Duplicate bytes count every copy of a content hash except the first one stored.
+type snapshotGroup struct {
+	DeviceID       string
+	Mime           string
+	Ext            string
+	Bytes          int64
+	FileCount      int64
+	DuplicateBytes int64
+}
+
+// recordSnapshots stores the user's aggregates for the day of now, combined and per device.
+// The duplicates_found notification is queued in the same transaction as the snapshot,
+// since growth is measured between snapshots.
+func (s *FileService) recordSnapshots(ctx context.Context, userID uuid.UUID, now time.Time) error {
+	var groups []snapshotGroup
+	err := s.db.WithContext(ctx).Raw(`SELECT device_id, mime, ext, SUM(size) AS bytes, COUNT(*) AS file_count,
+			COALESCE(SUM(size) FILTER (WHERE duplicate), 0) AS duplicate_bytes
+		FROM (
+			SELECT device_id, COALESCE(mime, '') AS mime, size,
+				COALESCE(lower(substring(path_tail from '\.[^./]*$')), '') AS ext,
+				ROW_NUMBER() OVER (PARTITION BY sha256 ORDER BY id) > 1 AS duplicate
+			FROM files WHERE user_id = ? AND deleted_at IS NULL
+		) f
+		GROUP BY device_id, mime, ext`, userID).Scan(&groups).Error
+	if err != nil {
+		return fmt.Errorf("failed to aggregate files: %w", err)
+	}
+
+	day := startOfDay(now)
+	snapshots := buildSnapshots(userID, day, groups)
+
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}, {Name: "device_id"}, {Name: "day"}},
+			DoUpdates: clause.AssignmentColumns(snapshotColumns),
+		}).Create(&snapshots).Error
+		if err != nil {
+			return fmt.Errorf("failed to record storage snapshot: %w", err)
+		}
+
+		return notifyDuplicateGrowth(tx, userID, day, snapshots[0].DuplicateBytes)
+	})
+}
+
+// buildSnapshots sums the groups into the combined snapshot, which comes first, and one
+// snapshot per device
+func buildSnapshots(userID uuid.UUID, day time.Time, groups []snapshotGroup) []models.StorageSnapshot {
+	snapshots := []models.StorageSnapshot{{UserID: userID, Day: day}}
+	devices := make(map[string]int)
+
+	for _, group := range groups {
+		i, ok := devices[group.DeviceID]
+		if !ok {
+			i = len(snapshots)
+			devices[group.DeviceID] = i
+			snapshots = append(snapshots, models.StorageSnapshot{UserID: userID, DeviceID: group.DeviceID, Day: day})
+		}
+
+		category := classifyFile(group.Mime, group.Ext)
+		addToSnapshot(&snapshots[0], category, group)
+		addToSnapshot(&snapshots[i], category, group)
+	}
+
+	return snapshots
+}
+
+func addToSnapshot(snapshot *models.StorageSnapshot, category string, group snapshotGroup) {
+	snapshot.FileCount += group.FileCount
+	snapshot.TotalBytes += group.Bytes
+	snapshot.DuplicateBytes += group.DuplicateBytes
+
+	switch category {
+	case CategoryImages:
+		snapshot.ImageBytes += group.Bytes
+	case CategoryVideos:
+		snapshot.VideoBytes += group.Bytes
+	case CategoryAudio:
+		snapshot.AudioBytes += group.Bytes
+	case CategoryDocuments:
+		snapshot.DocumentBytes += group.Bytes
+	case CategoryArchives:
+		snapshot.ArchiveBytes += group.Bytes
+	case CategoryAPKs:
+		snapshot.APKBytes += group.Bytes
+	default:
+		snapshot.OtherBytes += group.Bytes
+	}
+}
+
+func stringsToInterfaces(values []string) []interface{} {
+	out := make([]interface{}, len(values))
+	for i, v := range values {
+		out[i] = v
+	}
+	return out
+}
+
+// GetStatsHistory returns the user's storage snapshots between from and to, inclusive.
+// With week or month granularity each period reports its last snapshot, since the
+// values are levels rather than flows. An empty deviceID selects the combined totals.
+func (s *FileService) GetStatsHistory(ctx context.Context, userID uuid.UUID, deviceID string, from, to time.Time, granularity string) ([]models.StorageSnapshot, error) {
+	if granularity == "" {
+		granularity = GranularityDay
+	}
+	if granularity != GranularityDay && granularity != GranularityWeek && granularity != GranularityMonth {
+		return nil, ErrInvalidGranularity
+	}
+
+	var snapshots []models.StorageSnapshot
+	err := s.db.WithContext(ctx).
+		Where("user_id = ? AND device_id = ? AND day BETWEEN ? AND ?", userID, deviceID, startOfDay(from), startOfDay(to)).
+		Order("day ASC").
+		Find(&snapshots).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stats history: %w", err)
+	}
+
+	return bucketSnapshots(snapshots, granularity), nil
+}
+
+// bucketSnapshots keeps the last snapshot of each period, dated at the period start.
+// Snapshots must be in day order.
+func bucketSnapshots(snapshots []models.StorageSnapshot, granularity string) []models.StorageSnapshot {
+	points := []models.StorageSnapshot{}
+	for _, snapshot := range snapshots {
+		snapshot.Day = periodStart(snapshot.Day, granularity)
+		if n := len(points); n > 0 && points[n-1].Day.Equal(snapshot.Day) {
+			points[n-1] = snapshot
+			continue
+		}
+		points = append(points, snapshot)
+	}
+	return points
+}
+
+// periodStart returns the first day of the day, week (Monday) or month containing t
+func periodStart(t time.Time, granularity string) time.Time {
+	day := startOfDay(t)
+	switch granularity {
+	case GranularityWeek:
+		offset := (int(day.Weekday()) + 6) % 7
+		return day.AddDate(0, 0, -offset)
+	case GranularityMonth:
+		return time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return day
+	}
+}
+
+func startOfDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,110 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/purespace/backend/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func day(s string) time.Time {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestBucketSnapshots(t *testing.T) {
+	snapshots := []models.StorageSnapshot{
+		{Day: day("2024-05-30"), TotalBytes: 100}, // Thursday
+		{Day: day("2024-05-31"), TotalBytes: 110},
+		{Day: day("2024-06-02"), TotalBytes: 120}, // Sunday
+		{Day: day("2024-06-03"), TotalBytes: 90},  // Monday
+		{Day: day("2024-06-05"), TotalBytes: 80},
+	}
+
+	tests := []struct {
+		granularity string
+		days        []string
+		totals      []int64
+	}{
+		{
+			granularity: GranularityDay,
+			days:        []string{"2024-05-30", "2024-05-31", "2024-06-02", "2024-06-03", "2024-06-05"},
+			totals:      []int64{100, 110, 120, 90, 80},
+		},
+		{
+			granularity: GranularityWeek,
+			days:        []string{"2024-05-27", "2024-06-03"},
+			totals:      []int64{120, 80},
+		},
+		{
+			granularity: GranularityMonth,
+			days:        []string{"2024-05-01", "2024-06-01"},
+			totals:      []int64{110, 80},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.granularity, func(t *testing.T) {
+			points := bucketSnapshots(snapshots, tt.granularity)
+			require.Len(t, points, len(tt.days))
+			for i := range points {
+				assert.Equal(t, day(tt.days[i]), points[i].Day)
+				assert.Equal(t, tt.totals[i], points[i].TotalBytes)
+			}
+		})
+	}
+}
+
+func TestBucketSnapshots_Empty(t *testing.T) {
+	points := bucketSnapshots(nil, GranularityWeek)
+	assert.NotNil(t, points)
+	assert.Empty(t, points)
+}
+
+func TestBuildSnapshots(t *testing.T) {
+	userID := uuid.New()
+	groups := []snapshotGroup{
+		{DeviceID: "pixel", Mime: "image/jpeg", Ext: ".jpg", Bytes: 300, FileCount: 3, DuplicateBytes: 100},
+		{DeviceID: "pixel", Mime: "application/zip", Ext: ".apk", Bytes: 50, FileCount: 1},
+		{DeviceID: "pixel", Ext: ".bin", Bytes: 7, FileCount: 1},
+		{DeviceID: "tablet", Ext: ".png", Bytes: 20, FileCount: 2, DuplicateBytes: 20},
+	}
+
+	snapshots := buildSnapshots(userID, day("2024-06-01"), groups)
+	require.Len(t, snapshots, 3)
+
+	combined := snapshots[0]
+	assert.Equal(t, userID, combined.UserID)
+	assert.Equal(t, "", combined.DeviceID)
+	assert.Equal(t, int64(7), combined.FileCount)
+	assert.Equal(t, int64(377), combined.TotalBytes)
+	assert.Equal(t, int64(120), combined.DuplicateBytes)
+	assert.Equal(t, int64(320), combined.ImageBytes)
+
+	pixel := snapshots[1]
+	assert.Equal(t, "pixel", pixel.DeviceID)
+	assert.Equal(t, int64(5), pixel.FileCount)
+	assert.Equal(t, int64(357), pixel.TotalBytes)
+	assert.Equal(t, int64(100), pixel.DuplicateBytes)
+	assert.Equal(t, int64(300), pixel.ImageBytes)
+	assert.Equal(t, int64(50), pixel.APKBytes)
+	assert.Equal(t, int64(7), pixel.OtherBytes)
+	assert.Equal(t, int64(0), pixel.VideoBytes)
+
+	assert.Equal(t, "tablet", snapshots[2].DeviceID)
+	assert.Equal(t, int64(20), snapshots[2].ImageBytes)
+}
+
+func TestBuildSnapshots_NoFiles(t *testing.T) {
+	snapshots := buildSnapshots(uuid.New(), day("2024-06-01"), nil)
+
+	// The combined row is always recorded, so a user who deleted everything gets a zero point
+	require.Len(t, snapshots, 1)
+	assert.Equal(t, int64(0), snapshots[0].TotalBytes)
+}
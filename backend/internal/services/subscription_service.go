@@ -0,0 +1,59 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/purespace/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+const (
+	TierFree    = "free"
+	TierPremium = "premium"
+)
+
+type SubscriptionService struct {
+	db *gorm.DB
+}
+
+func NewSubscriptionService(db *gorm.DB) *SubscriptionService {
+	return &SubscriptionService{db: db}
+}
+
+// IsPremium reports whether the user has an active premium subscription
+func (s *SubscriptionService) IsPremium(ctx context.Context, userID uuid.UUID) (bool, error) {
+	return isPremium(s.db.WithContext(ctx), userID)
+}
+
+func isPremium(db *gorm.DB, userID uuid.UUID) (bool, error) {
+	var subscription models.Subscription
+	err := db.Where("user_id = ?", userID).First(&subscription).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get subscription: %w", err)
+	}
+
+	if subscription.Tier != TierPremium {
+		return false, nil
+	}
+	return subscription.ExpiresAt == nil || subscription.ExpiresAt.After(time.Now()), nil
+}
+
+// requirePremium returns ErrPremiumRequired unless the user has an active premium subscription
+func requirePremium(db *gorm.DB, userID uuid.UUID) error {
+	premium, err := isPremium(db, userID)
+	if err != nil {
+		return err
+	}
+	if !premium {
+		return ErrPremiumRequired
+	}
+
+	return nil
+}
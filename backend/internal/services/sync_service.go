@@ -0,0 +1,369 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/purespace/backend/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrResyncRequired is returned when a delta is based on a generation the server no longer holds
+var ErrResyncRequired = errors.New("full resync required")
+
+type SyncService struct {
+	db          *gorm.DB
+	fileService *FileService
+}
+
+func NewSyncService(db *gorm.DB, fileService *FileService) *SyncService {
+	return &SyncService{
+		db:          db,
+		fileService: fileService,
+	}
+}
+
+// SyncRequest carries the changes a device made since BaseGeneration.
+// When Full is set, Adds is the complete manifest and replaces the device's file set.
+type SyncRequest struct {
+	DeviceID       string     `json:"device_id" binding:"required"`
+	BaseGeneration int64      `json:"base_generation"`
+	Full           bool       `json:"full"`
+	Adds           []FileItem `json:"adds"`
+	Updates        []FileItem `json:"updates"`
+	Removes        []string   `json:"removes"`
+	ManifestDigest string     `json:"manifest_digest,omitempty"` // Client digest after applying the delta
+}
+
+type SyncResult struct {
+	DeviceID       string `json:"device_id"`
+	Generation     int64  `json:"generation"`
+	ManifestDigest string `json:"manifest_digest"`
+	FileCount      int64  `json:"file_count"`
+	Added          int    `json:"added"`
+	Updated        int    `json:"updated"`
+	Removed        int    `json:"removed"`
+	Moved          int    `json:"moved"`
+	ResyncRequired bool   `json:"resync_required"`
+}
+
+// Sync applies a manifest delta (or a full manifest) for a device and advances its generation
+func (s *SyncService) Sync(ctx context.Context, userID uuid.UUID, req *SyncRequest) (*SyncResult, error) {
+	if err := validateSyncRequest(req); err != nil {
+		return nil, err
+	}
+
+	var result *SyncResult
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := ensureDevice(tx, userID, req.DeviceID, s.fileService.freeDeviceLimit); err != nil {
+			return err
+		}
+
+		var err error
+		result, err = applySync(tx, userID, req, true)
+		return err
+	})
+
+	if err != nil {
+		if errors.Is(err, ErrResyncRequired) {
+			return result, err
+		}
+		if errors.Is(err, ErrDeviceLimitReached) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to sync manifest: %w", err)
+	}
+
+	s.fileService.statsChanged(ctx, userID)
+
+	return result, nil
+}
+
+func validateSyncRequest(req *SyncRequest) error {
+	if req.Full && (len(req.Updates) > 0 || len(req.Removes) > 0) {
+		return fmt.Errorf("full sync accepts adds only")
+	}
+
+	for _, items := range [][]FileItem{req.Adds, req.Updates} {
+		for i := range items {
+			if reason := validateFileItem(&items[i]); reason != "" {
+				return fmt.Errorf("invalid file %q: %s", items[i].PathTail, reason)
+			}
+		}
+	}
+
+	return nil
+}
+
+// applySync applies req inside tx and advances the device generation. With checkBase
+// unset, a delta is applied on top of whatever generation the server currently holds.
+func applySync(tx *gorm.DB, userID uuid.UUID, req *SyncRequest, checkBase bool) (*SyncResult, error) {
+	result := &SyncResult{DeviceID: req.DeviceID}
+
+	state, err := lockSyncState(tx, userID, req.DeviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	if checkBase && !req.Full && req.BaseGeneration != state.Generation {
+		result.Generation = state.Generation
+		result.ManifestDigest = state.ManifestDigest
+		result.FileCount = state.FileCount
+		result.ResyncRequired = true
+		return result, ErrResyncRequired
+	}
+
+	changes, err := applyManifestChanges(tx, userID, req)
+	if err != nil {
+		return nil, err
+	}
+	result.Added = changes.Inserted
+	result.Updated = changes.Updated
+	result.Removed = changes.Removed
+	result.Moved = changes.Moved
+
+	if err := markPoliciesDue(tx, userID); err != nil {
+		return nil, err
+	}
+
+	// After a sync the server holds the device's full file set, so every remaining row was seen
+	now := time.Now()
+	err = tx.Model(&models.File{}).
+		Where("user_id = ? AND device_id = ?", userID, req.DeviceID).
+		Update("last_seen_at", now).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to update last seen: %w", err)
+	}
+
+	digest, count, err := computeManifestDigest(tx, userID, req.DeviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	state.Generation++
+	state.ManifestDigest = digest
+	state.FileCount = count
+	state.LastSyncedAt = &now
+	if err := tx.Save(state).Error; err != nil {
+		return nil, fmt.Errorf("failed to save sync state: %w", err)
+	}
+
+	result.Generation = state.Generation
+	result.ManifestDigest = digest
+	result.FileCount = count
+	// The delta was applied but the device and server disagree on the result
+	result.ResyncRequired = req.ManifestDigest != "" && req.ManifestDigest != digest
+
+	return result, nil
+}
+
+// GetSyncState returns the last acknowledged sync state for a device
+func (s *SyncService) GetSyncState(ctx context.Context, userID uuid.UUID, deviceID string) (*models.DeviceSyncState, error) {
+	var state models.DeviceSyncState
+	err := s.db.WithContext(ctx).
+		Where("user_id = ? AND device_id = ?", userID, deviceID).
+		First(&state).Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &models.DeviceSyncState{UserID: userID, DeviceID: deviceID}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sync state: %w", err)
+	}
+
+	return &state, nil
+}
+
+func lockSyncState(tx *gorm.DB, userID uuid.UUID, deviceID string) (*models.DeviceSyncState, error) {
+	state := models.DeviceSyncState{UserID: userID, DeviceID: deviceID}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&state).Error; err != nil {
+		return nil, fmt.Errorf("failed to create sync state: %w", err)
+	}
+
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ? AND device_id = ?", userID, deviceID).
+		First(&state).Error; err != nil {
+		return nil, fmt.Errorf("failed to lock sync state: %w", err)
+	}
+
+	return &state, nil
+}
+
+type manifestChanges struct {
+	upsertCounts
+	Removed int
+	Moved   int
+}
+
+// applyManifestChanges removes, moves and upserts the files described by a sync request.
+// A removed row whose content matches an added path is treated as a move, so the row
+// keeps its identity instead of being deleted and re-created.
+func applyManifestChanges(tx *gorm.DB, userID uuid.UUID, req *SyncRequest) (*manifestChanges, error) {
+	incoming := dedupeByPath(append(append([]FileItem{}, req.Adds...), req.Updates...))
+
+	if err := restoreReportedPaths(tx, userID, req.DeviceID, incoming); err != nil {
+		return nil, err
+	}
+
+	var removed []models.File
+	var existing map[string]models.File
+
+	if req.Full {
+		var current []models.File
+		if err := tx.Where("user_id = ? AND device_id = ?", userID, req.DeviceID).Find(&current).Error; err != nil {
+			return nil, fmt.Errorf("failed to load device files: %w", err)
+		}
+
+		inManifest := make(map[string]bool, len(incoming))
+		for _, item := range incoming {
+			inManifest[item.PathTail] = true
+		}
+
+		existing = make(map[string]models.File, len(current))
+		for _, file := range current {
+			existing[file.PathTail] = file
+			if !inManifest[file.PathTail] {
+				removed = append(removed, file)
+			}
+		}
+	} else {
+		paths := make([]string, len(incoming))
+		for i, item := range incoming {
+			paths[i] = item.PathTail
+		}
+
+		var err error
+		existing, err = findFilesByPath(tx, userID, req.DeviceID, paths)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(req.Removes) > 0 {
+			removedByPath, err := findFilesByPath(tx, userID, req.DeviceID, req.Removes)
+			if err != nil {
+				return nil, err
+			}
+			for path, file := range removedByPath {
+				// A path both removed and re-added in one delta is kept
+				if _, readded := existing[path]; !readded {
+					removed = append(removed, file)
+				}
+			}
+		}
+	}
+
+	changes := &manifestChanges{}
+
+	// Pair removed rows with new paths that carry the same content
+	candidates := make(map[string][]models.File)
+	for _, file := range removed {
+		key := contentKey(file.SHA256, file.Size)
+		candidates[key] = append(candidates[key], file)
+	}
+
+	moved := make(map[uint]bool)
+	var events []models.FileEvent
+	upserts := make([]FileItem, 0, len(incoming))
+	for _, item := range incoming {
+		if _, ok := existing[item.PathTail]; !ok {
+			key := contentKey(item.SHA256, item.Size)
+			if rows := candidates[key]; len(rows) > 0 {
+				row := rows[0]
+				candidates[key] = rows[1:]
+
+				err := tx.Model(&row).
+					Select(append([]string{"path_tail"}, fileMetadataColumns...)).
+					Updates(item.toModel(userID, req.DeviceID)).Error
+				if err != nil {
+					return nil, fmt.Errorf("failed to move %s: %w", row.PathTail, err)
+				}
+
+				moved[row.ID] = true
+				events = append(events, movedEvent(row, item.PathTail))
+				changes.Moved++
+				continue
+			}
+		}
+		upserts = append(upserts, item)
+	}
+
+	var removedIDs []uint
+	for _, file := range removed {
+		if !moved[file.ID] {
+			removedIDs = append(removedIDs, file.ID)
+			events = append(events, removedEvent(file))
+		}
+	}
+
+	if err := recordFileEvents(tx, events); err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < len(removedIDs); i += upsertBatchSize {
+		end := i + upsertBatchSize
+		if end > len(removedIDs) {
+			end = len(removedIDs)
+		}
+
+		// Files gone from the device skip the trash
+		result := tx.Unscoped().Where("id IN ?", removedIDs[i:end]).Delete(&models.File{})
+		if result.Error != nil {
+			return nil, fmt.Errorf("failed to remove files: %w", result.Error)
+		}
+		changes.Removed += int(result.RowsAffected)
+	}
+
+	counts, err := upsertFileItems(tx, userID, req.DeviceID, upserts)
+	if err != nil {
+		return nil, err
+	}
+	changes.upsertCounts = *counts
+
+	return changes, nil
+}
+
+func contentKey(sha256 string, size int64) string {
+	return sha256 + ":" + strconv.FormatInt(size, 10)
+}
+
+// computeManifestDigest hashes the device's file set in path order.
+// Each file contributes "path_tail\x00sha256\x00size\n", sorted bytewise by path_tail.
+func computeManifestDigest(tx *gorm.DB, userID uuid.UUID, deviceID string) (string, int64, error) {
+	var entries []struct {
+		PathTail string
+		SHA256   string
+		Size     int64
+	}
+
+	err := tx.Model(&models.File{}).
+		Select("path_tail, sha256, size").
+		Where("user_id = ? AND device_id = ?", userID, deviceID).
+		Scan(&entries).Error
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to load manifest: %w", err)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].PathTail < entries[j].PathTail
+	})
+
+	h := sha256.New()
+	for _, e := range entries {
+		h.Write([]byte(e.PathTail))
+		h.Write([]byte{0})
+		h.Write([]byte(e.SHA256))
+		h.Write([]byte{0})
+		h.Write([]byte(strconv.FormatInt(e.Size, 10)))
+		h.Write([]byte{'\n'})
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), int64(len(entries)), nil
+}
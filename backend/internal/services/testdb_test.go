@@ -0,0 +1,94 @@
+package services
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
+)
+
+// newTestDB opens an in-memory SQLite database with tables for the given models. SQLite
+// cannot run Postgres defaults such as gen_random_uuid(), so they are dropped from the
+// schema and UUID primary keys are filled in on create instead.
+func newTestDB(t *testing.T, tables ...interface{}) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+
+	// Every connection to :memory: opens its own database
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	// Migrating a model also creates the tables it references, so their defaults go too
+	seen := make(map[*schema.Schema]bool)
+	var dropDefaults func(s *schema.Schema)
+	dropDefaults = func(s *schema.Schema) {
+		if seen[s] {
+			return
+		}
+		seen[s] = true
+		for _, field := range s.Fields {
+			if strings.HasSuffix(field.DefaultValue, "()") {
+				field.HasDefaultValue = false
+				field.DefaultValue = ""
+				field.DefaultValueInterface = nil
+			}
+		}
+		for _, rel := range s.Relationships.Relations {
+			dropDefaults(rel.FieldSchema)
+		}
+	}
+	for _, table := range tables {
+		stmt := &gorm.Statement{DB: db}
+		require.NoError(t, stmt.Parse(table))
+		dropDefaults(stmt.Schema)
+	}
+	require.NoError(t, db.AutoMigrate(tables...))
+
+	err = db.Callback().Create().Before("gorm:create").Register("test:uuid_primary_keys", func(tx *gorm.DB) {
+		if tx.Statement.Schema == nil || tx.Statement.Schema.PrioritizedPrimaryField == nil {
+			return
+		}
+		field := tx.Statement.Schema.PrioritizedPrimaryField
+		if field.FieldType != reflect.TypeOf(uuid.UUID{}) {
+			return
+		}
+
+		ctx := tx.Statement.Context
+		setID := func(rv reflect.Value) {
+			if _, zero := field.ValueOf(ctx, rv); zero {
+				tx.AddError(field.Set(ctx, rv, uuid.New()))
+			}
+		}
+		switch rv := tx.Statement.ReflectValue; rv.Kind() {
+		case reflect.Slice, reflect.Array:
+			for i := 0; i < rv.Len(); i++ {
+				setID(reflect.Indirect(rv.Index(i)))
+			}
+		case reflect.Struct:
+			setID(rv)
+		}
+	})
+	require.NoError(t, err)
+
+	return db
+}
+
+// newTestRedis starts an in-memory Redis server for the test
+func newTestRedis(t *testing.T) *redis.Client {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	require.NoError(t, client.Ping(context.Background()).Err())
+	return client
+}
@@ -0,0 +1,311 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/purespace/backend/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrNotInTrash = errors.New("file is not in the trash")
+
+// TrashService manages deleted files during the retention window. Deleting files through
+// a cleanup plan or the duplicates API moves them to the trash; they stay restorable
+// until they are purged. A trashed file is already gone from its device, so restoring
+// it asks the device to put it back.
+type TrashService struct {
+	db            *gorm.DB
+	fileService   *FileService
+	actionService *ActionService
+	retention     time.Duration
+}
+
+func NewTrashService(db *gorm.DB, fileService *FileService, actionService *ActionService, retention time.Duration) *TrashService {
+	return &TrashService{
+		db:            db,
+		fileService:   fileService,
+		actionService: actionService,
+		retention:     retention,
+	}
+}
+
+type TrashedFile struct {
+	models.File
+	PurgeAt time.Time `json:"purge_at"`
+}
+
+type TrashPage struct {
+	Files      []TrashedFile `json:"files"`
+	Total      int64         `json:"total"`
+	TotalBytes int64         `json:"total_bytes"`
+	Page       int           `json:"page"`
+	PageSize   int           `json:"page_size"`
+}
+
+type RestoreRequest struct {
+	FileIDs []uint `json:"file_ids" binding:"required,min=1"`
+}
+
+// ListTrash returns the user's trashed files, most recently deleted first
+func (s *TrashService) ListTrash(ctx context.Context, userID uuid.UUID, page, pageSize int) (*TrashPage, error) {
+	if page < 1 {
+		page = 1
+	}
+	if pageSize <= 0 {
+		pageSize = defaultSearchPageSize
+	}
+	if pageSize > maxSearchPageSize {
+		pageSize = maxSearchPageSize
+	}
+
+	base := s.db.WithContext(ctx).Unscoped().Model(&models.File{}).
+		Where("user_id = ? AND deleted_at IS NOT NULL", userID)
+
+	var totals struct {
+		Total      int64
+		TotalBytes int64
+	}
+	err := base.Session(&gorm.Session{}).
+		Select("COUNT(*) AS total, COALESCE(SUM(size), 0) AS total_bytes").
+		Scan(&totals).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to count trash: %w", err)
+	}
+
+	var files []models.File
+	err = base.Session(&gorm.Session{}).
+		Order("deleted_at DESC, id ASC").
+		Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Find(&files).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list trash: %w", err)
+	}
+
+	result := &TrashPage{
+		Files:      make([]TrashedFile, len(files)),
+		Total:      totals.Total,
+		TotalBytes: totals.TotalBytes,
+		Page:       page,
+		PageSize:   pageSize,
+	}
+	for i, file := range files {
+		result.Files[i] = TrashedFile{File: file, PurgeAt: file.DeletedAt.Time.Add(s.retention)}
+	}
+
+	return result, nil
+}
+
+// Restore queues a restore action for each file on the device that deleted it. The
+// files stay in the trash until the device acknowledges the action; then they no longer
+// count towards the reports that recorded their deletion.
+func (s *TrashService) Restore(ctx context.Context, userID uuid.UUID, fileIDs []uint) ([]models.DeviceAction, error) {
+	fileIDs = uniqueIDs(fileIDs)
+
+	var actions []models.DeviceAction
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var files []models.File
+		err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id IN ? AND user_id = ? AND deleted_at IS NOT NULL", fileIDs, userID).
+			Order("id ASC").
+			Find(&files).Error
+		if err != nil {
+			return err
+		}
+		if len(files) != len(fileIDs) {
+			return ErrNotInTrash
+		}
+
+		var open int64
+		err = tx.Model(&models.DeviceAction{}).
+			Where("file_id IN ? AND status IN ?", fileIDs, []string{ActionPending, ActionDelivered}).
+			Count(&open).Error
+		if err != nil {
+			return err
+		}
+		if open > 0 {
+			return ErrActionPending
+		}
+
+		actions = restoreActions(files)
+		return tx.Create(&actions).Error
+	})
+	if errors.Is(err, ErrNotInTrash) || errors.Is(err, ErrActionPending) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore files: %w", err)
+	}
+
+	deviceIDs := make([]string, len(actions))
+	for i, action := range actions {
+		deviceIDs[i] = action.DeviceID
+	}
+	s.actionService.notify(ctx, userID, deviceIDs)
+
+	return actions, nil
+}
+
+// restoreActions asks the device of each trashed file to put it back at its path
+func restoreActions(files []models.File) []models.DeviceAction {
+	actions := make([]models.DeviceAction, len(files))
+	for i, file := range files {
+		actions[i] = models.DeviceAction{
+			ID:       uuid.New(),
+			UserID:   file.UserID,
+			DeviceID: file.DeviceID,
+			Type:     ActionRestore,
+			FileID:   file.ID,
+			PathTail: file.PathTail,
+			SHA256:   file.SHA256,
+			Size:     file.Size,
+			Status:   ActionPending,
+		}
+	}
+	return actions
+}
+
+// applyRestore takes a file out of the trash once its device restored it. A file the
+// device already reported again, or that was purged meanwhile, is left alone.
+func applyRestore(tx *gorm.DB, action *models.DeviceAction) error {
+	var files []models.File
+	err := tx.Unscoped().
+		Where("id = ? AND user_id = ? AND deleted_at IS NOT NULL", action.FileID, action.UserID).
+		Find(&files).Error
+	if err != nil {
+		return fmt.Errorf("failed to get trashed file: %w", err)
+	}
+
+	return restoreFiles(tx, action.UserID, files)
+}
+
+// EmptyTrash permanently deletes all of the user's trashed files
+func (s *TrashService) EmptyTrash(ctx context.Context, userID uuid.UUID) (int64, error) {
+	result := s.db.WithContext(ctx).Unscoped().
+		Where("user_id = ? AND deleted_at IS NOT NULL", userID).
+		Delete(&models.File{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to empty trash: %w", result.Error)
+	}
+
+	return result.RowsAffected, nil
+}
+
+// PurgeExpired permanently deletes files that have been in the trash longer than the
+// retention window
+func (s *TrashService) PurgeExpired(ctx context.Context) (int64, error) {
+	result := s.db.WithContext(ctx).Unscoped().
+		Where("deleted_at < ?", time.Now().Add(-s.retention)).
+		Delete(&models.File{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to purge trash: %w", result.Error)
+	}
+
+	return result.RowsAffected, nil
+}
+
+// restoreReportedPaths restores trashed files at paths the device reports again, so the
+// upsert that follows updates the original row instead of conflicting with it
+func restoreReportedPaths(tx *gorm.DB, userID uuid.UUID, deviceID string, items []FileItem) error {
+	for i := 0; i < len(items); i += upsertBatchSize {
+		end := i + upsertBatchSize
+		if end > len(items) {
+			end = len(items)
+		}
+
+		paths := make([]string, 0, end-i)
+		for _, item := range items[i:end] {
+			paths = append(paths, item.PathTail)
+		}
+
+		var files []models.File
+		err := tx.Unscoped().
+			Where("user_id = ? AND device_id = ? AND path_tail IN ? AND deleted_at IS NOT NULL", userID, deviceID, paths).
+			Find(&files).Error
+		if err != nil {
+			return fmt.Errorf("failed to find trashed files: %w", err)
+		}
+
+		if err := restoreFiles(tx, userID, files); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// restoreFiles clears the trash flag on files, records the restore in their history and
+// takes them out of the savings of the reports that counted them
+func restoreFiles(tx *gorm.DB, userID uuid.UUID, files []models.File) error {
+	if len(files) == 0 {
+		return nil
+	}
+
+	ids := make([]uint, len(files))
+	events := make([]models.FileEvent, len(files))
+	for i := range files {
+		files[i].DeletedAt = gorm.DeletedAt{}
+		ids[i] = files[i].ID
+		events[i] = restoredEvent(files[i])
+	}
+
+	err := tx.Unscoped().Model(&models.File{}).Where("id IN ?", ids).Update("deleted_at", nil).Error
+	if err != nil {
+		return fmt.Errorf("failed to restore files: %w", err)
+	}
+
+	if err := recordFileEvents(tx, events); err != nil {
+		return err
+	}
+
+	var items []models.CleanupPlanItem
+	err = tx.Select("cleanup_plan_items.*").
+		Joins("JOIN cleanup_plans ON cleanup_plans.id = cleanup_plan_items.plan_id").
+		Where("cleanup_plans.user_id = ? AND cleanup_plan_items.file_id IN ? AND cleanup_plan_items.status = ?",
+			userID, ids, PlanItemDeleted).
+		Find(&items).Error
+	if err != nil {
+		return fmt.Errorf("failed to find plan items: %w", err)
+	}
+	if len(items) == 0 {
+		return nil
+	}
+
+	itemIDs := make([]uint, len(items))
+	for i, item := range items {
+		itemIDs[i] = item.ID
+	}
+	err = tx.Model(&models.CleanupPlanItem{}).Where("id IN ?", itemIDs).Update("status", PlanItemRestored).Error
+	if err != nil {
+		return fmt.Errorf("failed to update plan items: %w", err)
+	}
+
+	for planID, restored := range restoredByPlan(items) {
+		err := tx.Model(&models.Report{}).Where("plan_id = ?", planID).Updates(map[string]interface{}{
+			"bytes_saved":   gorm.Expr("bytes_saved - ?", restored.BytesSaved),
+			"items_deleted": gorm.Expr("items_deleted - ?", restored.ItemsDeleted),
+		}).Error
+		if err != nil {
+			return fmt.Errorf("failed to update report: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// restoredByPlan totals restored plan items per plan
+func restoredByPlan(items []models.CleanupPlanItem) map[uuid.UUID]GroupedSavings {
+	totals := make(map[uuid.UUID]GroupedSavings)
+	for _, item := range items {
+		total := totals[item.PlanID]
+		total.BytesSaved += item.Size
+		total.ItemsDeleted++
+		totals[item.PlanID] = total
+	}
+	return totals
+}
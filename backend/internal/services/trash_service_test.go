@@ -0,0 +1,105 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/purespace/backend/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestRestoredByPlan(t *testing.T) {
+	first, second := uuid.New(), uuid.New()
+	items := []models.CleanupPlanItem{
+		{PlanID: first, Size: 100},
+		{PlanID: second, Size: 50},
+		{PlanID: first, Size: 300},
+	}
+
+	totals := restoredByPlan(items)
+
+	assert.Len(t, totals, 2)
+	assert.Equal(t, int64(400), totals[first].BytesSaved)
+	assert.Equal(t, int64(2), totals[first].ItemsDeleted)
+	assert.Equal(t, int64(50), totals[second].BytesSaved)
+	assert.Equal(t, int64(1), totals[second].ItemsDeleted)
+}
+
+func TestTrashService_Restore(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t, &models.File{}, &models.FileEvent{}, &models.DeviceAction{},
+		&models.CleanupPlan{}, &models.CleanupPlanItem{}, &models.Report{})
+	fileService := NewFileService(db, newTestRedis(t), 0)
+	actionService := NewActionService(db, nil, fileService)
+	trashService := NewTrashService(db, fileService, actionService, 30*24*time.Hour)
+
+	userID := uuid.New()
+	planID := uuid.New()
+	file := models.File{UserID: userID, DeviceID: "pixel", PathTail: "DCIM/a.jpg", SHA256: strings.Repeat("ab", 32), Size: 100}
+	require.NoError(t, db.Create(&file).Error)
+	require.NoError(t, db.Delete(&file).Error)
+	require.NoError(t, db.Create(&models.CleanupPlan{ID: planID, UserID: userID, Status: PlanCompleted}).Error)
+	require.NoError(t, db.Create(&models.CleanupPlanItem{PlanID: planID, FileID: file.ID, DeviceID: "pixel", PathTail: file.PathTail,
+		SHA256: file.SHA256, Size: 100, Status: PlanItemDeleted}).Error)
+	require.NoError(t, db.Create(&models.Report{UserID: userID, PlanID: &planID, BytesSaved: 100, ItemsDeleted: 1,
+		StartedAt: time.Now(), CompletedAt: time.Now()}).Error)
+
+	actions, err := trashService.Restore(ctx, userID, []uint{file.ID})
+	require.NoError(t, err)
+	require.Len(t, actions, 1)
+	assert.Equal(t, ActionRestore, actions[0].Type)
+	assert.Equal(t, "pixel", actions[0].DeviceID)
+	assert.Equal(t, file.PathTail, actions[0].PathTail)
+
+	// The file is gone from the device, so it stays in the trash until the device restores it
+	assert.ErrorIs(t, db.First(&models.File{}, file.ID).Error, gorm.ErrRecordNotFound)
+
+	_, err = trashService.Restore(ctx, userID, []uint{file.ID})
+	assert.ErrorIs(t, err, ErrActionPending)
+
+	_, err = actionService.Acknowledge(ctx, userID, "pixel", &AckRequest{Acks: []ActionAck{
+		{ActionID: actions[0].ID, Status: ActionSucceeded},
+	}})
+	require.NoError(t, err)
+
+	require.NoError(t, db.First(&models.File{}, file.ID).Error)
+	var item models.CleanupPlanItem
+	require.NoError(t, db.Where("file_id = ?", file.ID).First(&item).Error)
+	assert.Equal(t, PlanItemRestored, item.Status)
+	var report models.Report
+	require.NoError(t, db.Where("plan_id = ?", planID).First(&report).Error)
+	assert.Equal(t, int64(0), report.BytesSaved)
+	assert.Equal(t, 0, report.ItemsDeleted)
+}
+
+func TestTrashService_Restore_FailedAck(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t, &models.File{}, &models.FileEvent{}, &models.DeviceAction{},
+		&models.CleanupPlan{}, &models.CleanupPlanItem{}, &models.Report{})
+	fileService := NewFileService(db, newTestRedis(t), 0)
+	actionService := NewActionService(db, nil, fileService)
+	trashService := NewTrashService(db, fileService, actionService, 30*24*time.Hour)
+
+	userID := uuid.New()
+	file := models.File{UserID: userID, DeviceID: "pixel", PathTail: "DCIM/a.jpg", SHA256: strings.Repeat("ab", 32), Size: 100}
+	require.NoError(t, db.Create(&file).Error)
+	require.NoError(t, db.Delete(&file).Error)
+
+	_, err := trashService.Restore(ctx, userID, []uint{file.ID + 1})
+	assert.ErrorIs(t, err, ErrNotInTrash)
+
+	actions, err := trashService.Restore(ctx, userID, []uint{file.ID})
+	require.NoError(t, err)
+
+	_, err = actionService.Acknowledge(ctx, userID, "pixel", &AckRequest{Acks: []ActionAck{
+		{ActionID: actions[0].ID, Status: ActionFailed, Error: "no longer in the device trash"},
+	}})
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, db.First(&models.File{}, file.ID).Error, gorm.ErrRecordNotFound)
+}
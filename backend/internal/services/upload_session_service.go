@@ -0,0 +1,294 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/purespace/backend/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrUploadSessionNotFound   = errors.New("upload session not found")
+	ErrUploadSessionClosed     = errors.New("upload session is no longer open")
+	ErrUploadSessionIncomplete = errors.New("upload session is missing chunks")
+	ErrChunkOutOfRange         = errors.New("chunk index out of range")
+	ErrInvalidUploadSession    = errors.New("invalid upload session request")
+)
+
+const (
+	UploadModeReplace = "replace"
+	UploadModeMerge   = "merge"
+
+	uploadSessionOpen      = "open"
+	uploadSessionCommitted = "committed"
+
+	uploadSessionTTL = 24 * time.Hour
+	maxChunkItems    = 1000
+	maxSessionChunks = 1000
+)
+
+type UploadSessionService struct {
+	db          *gorm.DB
+	fileService *FileService
+}
+
+func NewUploadSessionService(db *gorm.DB, fileService *FileService) *UploadSessionService {
+	return &UploadSessionService{
+		db:          db,
+		fileService: fileService,
+	}
+}
+
+type OpenUploadSessionRequest struct {
+	DeviceID    string `json:"device_id" binding:"required"`
+	Mode        string `json:"mode"` // replace (default) or merge
+	TotalChunks int    `json:"total_chunks" binding:"required,min=1"`
+}
+
+type UploadChunkRequest struct {
+	Files []FileItem `json:"files" binding:"required"`
+}
+
+type UploadSessionStatus struct {
+	Session  models.UploadSession `json:"session"`
+	Received []int                `json:"received"`
+	Missing  []int                `json:"missing"`
+}
+
+// Open starts a new upload session for a device
+func (s *UploadSessionService) Open(ctx context.Context, userID uuid.UUID, req *OpenUploadSessionRequest) (*models.UploadSession, error) {
+	mode := req.Mode
+	if mode == "" {
+		mode = UploadModeReplace
+	}
+	if mode != UploadModeReplace && mode != UploadModeMerge {
+		return nil, fmt.Errorf("%w: invalid mode %q", ErrInvalidUploadSession, req.Mode)
+	}
+	if req.TotalChunks > maxSessionChunks {
+		return nil, fmt.Errorf("%w: too many chunks (max %d)", ErrInvalidUploadSession, maxSessionChunks)
+	}
+
+	// Drop this user's abandoned sessions; their chunks cascade
+	s.db.WithContext(ctx).
+		Where("user_id = ? AND status = ? AND expires_at < ?", userID, uploadSessionOpen, time.Now()).
+		Delete(&models.UploadSession{})
+
+	session := models.UploadSession{
+		ID:          uuid.New(),
+		UserID:      userID,
+		DeviceID:    req.DeviceID,
+		Mode:        mode,
+		Status:      uploadSessionOpen,
+		TotalChunks: req.TotalChunks,
+		ExpiresAt:   time.Now().Add(uploadSessionTTL),
+	}
+
+	if err := s.db.WithContext(ctx).Create(&session).Error; err != nil {
+		return nil, fmt.Errorf("failed to create upload session: %w", err)
+	}
+
+	return &session, nil
+}
+
+// PutChunk stores a chunk. Re-sending a chunk replaces the stored copy, so retries are safe.
+// The session row is share-locked while the chunk is written, so a chunk cannot land after
+// Commit has read the session's chunks.
+func (s *UploadSessionService) PutChunk(ctx context.Context, userID, sessionID uuid.UUID, index int, req *UploadChunkRequest) error {
+	if len(req.Files) > maxChunkItems {
+		return fmt.Errorf("%w: too many files in chunk (max %d)", ErrInvalidUploadSession, maxChunkItems)
+	}
+	if err := validateSyncRequest(&SyncRequest{Adds: req.Files}); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidUploadSession, err)
+	}
+
+	payload, err := json.Marshal(req.Files)
+	if err != nil {
+		return fmt.Errorf("failed to encode chunk: %w", err)
+	}
+
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		session, err := s.openSession(tx.Clauses(clause.Locking{Strength: "SHARE"}), userID, sessionID)
+		if err != nil {
+			return err
+		}
+
+		if index < 0 || index >= session.TotalChunks {
+			return ErrChunkOutOfRange
+		}
+
+		chunk := models.UploadChunk{
+			SessionID:  session.ID,
+			ChunkIndex: index,
+			ItemCount:  len(req.Files),
+			Payload:    payload,
+		}
+
+		err = tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "session_id"}, {Name: "chunk_index"}},
+			DoUpdates: clause.AssignmentColumns([]string{"item_count", "payload", "updated_at"}),
+		}).Create(&chunk).Error
+		if err != nil {
+			return fmt.Errorf("failed to store chunk: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// Status reports which chunks of a session have been received
+func (s *UploadSessionService) Status(ctx context.Context, userID, sessionID uuid.UUID) (*UploadSessionStatus, error) {
+	var session models.UploadSession
+	err := s.db.WithContext(ctx).
+		Where("id = ? AND user_id = ?", sessionID, userID).
+		First(&session).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrUploadSessionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get upload session: %w", err)
+	}
+
+	var received []int
+	err = s.db.WithContext(ctx).Model(&models.UploadChunk{}).
+		Where("session_id = ?", sessionID).
+		Order("chunk_index ASC").
+		Pluck("chunk_index", &received).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list chunks: %w", err)
+	}
+
+	status := &UploadSessionStatus{
+		Session:  session,
+		Received: received,
+		Missing:  missingChunks(received, session.TotalChunks),
+	}
+	if status.Received == nil {
+		status.Received = []int{}
+	}
+
+	return status, nil
+}
+
+// Commit applies every chunk of a session in one transaction. In replace mode the
+// device's file set becomes exactly the uploaded manifest; in merge mode the files
+// are upserted alongside the existing ones.
+func (s *UploadSessionService) Commit(ctx context.Context, userID, sessionID uuid.UUID) (*SyncResult, error) {
+	var result *SyncResult
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		session, err := s.openSession(tx.Clauses(clause.Locking{Strength: "UPDATE"}), userID, sessionID)
+		if err != nil {
+			return err
+		}
+
+		var chunks []models.UploadChunk
+		if err := tx.Where("session_id = ?", session.ID).Order("chunk_index ASC").Find(&chunks).Error; err != nil {
+			return fmt.Errorf("failed to load chunks: %w", err)
+		}
+		if len(chunks) != session.TotalChunks {
+			return ErrUploadSessionIncomplete
+		}
+
+		var files []FileItem
+		for _, chunk := range chunks {
+			var items []FileItem
+			if err := json.Unmarshal(chunk.Payload, &items); err != nil {
+				return fmt.Errorf("failed to decode chunk %d: %w", chunk.ChunkIndex, err)
+			}
+			files = append(files, items...)
+		}
+
+		req := &SyncRequest{
+			DeviceID: session.DeviceID,
+			Full:     session.Mode == UploadModeReplace,
+			Adds:     files,
+		}
+		if err := validateSyncRequest(req); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidUploadSession, err)
+		}
+		if err := ensureDevice(tx, userID, session.DeviceID, s.fileService.freeDeviceLimit); err != nil {
+			return err
+		}
+
+		result, err = applySync(tx, userID, req, false)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		session.Status = uploadSessionCommitted
+		session.CommittedAt = &now
+		if err := tx.Save(session).Error; err != nil {
+			return fmt.Errorf("failed to close upload session: %w", err)
+		}
+
+		return tx.Where("session_id = ?", session.ID).Delete(&models.UploadChunk{}).Error
+	})
+
+	if err != nil {
+		if errors.Is(err, ErrUploadSessionNotFound) || errors.Is(err, ErrUploadSessionClosed) ||
+			errors.Is(err, ErrUploadSessionIncomplete) || errors.Is(err, ErrInvalidUploadSession) ||
+			errors.Is(err, ErrDeviceLimitReached) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to commit upload session: %w", err)
+	}
+
+	s.fileService.statsChanged(ctx, userID)
+
+	return result, nil
+}
+
+// Abort discards a session and its chunks
+func (s *UploadSessionService) Abort(ctx context.Context, userID, sessionID uuid.UUID) error {
+	result := s.db.WithContext(ctx).
+		Where("id = ? AND user_id = ? AND status = ?", sessionID, userID, uploadSessionOpen).
+		Delete(&models.UploadSession{})
+
+	if result.Error != nil {
+		return fmt.Errorf("failed to abort upload session: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrUploadSessionNotFound
+	}
+
+	return nil
+}
+
+func (s *UploadSessionService) openSession(db *gorm.DB, userID, sessionID uuid.UUID) (*models.UploadSession, error) {
+	var session models.UploadSession
+	err := db.Where("id = ? AND user_id = ?", sessionID, userID).First(&session).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrUploadSessionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get upload session: %w", err)
+	}
+
+	if session.Status != uploadSessionOpen || time.Now().After(session.ExpiresAt) {
+		return nil, ErrUploadSessionClosed
+	}
+
+	return &session, nil
+}
+
+func missingChunks(received []int, total int) []int {
+	have := make(map[int]bool, len(received))
+	for _, index := range received {
+		have[index] = true
+	}
+
+	missing := []int{}
+	for i := 0; i < total; i++ {
+		if !have[i] {
+			missing = append(missing, i)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,63 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMissingChunks(t *testing.T) {
+	tests := []struct {
+		name     string
+		received []int
+		total    int
+		expected []int
+	}{
+		{
+			name:     "Nothing received",
+			received: nil,
+			total:    3,
+			expected: []int{0, 1, 2},
+		},
+		{
+			name:     "Gaps in the middle",
+			received: []int{0, 2, 4},
+			total:    5,
+			expected: []int{1, 3},
+		},
+		{
+			name:     "All received",
+			received: []int{0, 1, 2},
+			total:    3,
+			expected: []int{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, missingChunks(tt.received, tt.total))
+		})
+	}
+}
+
+func TestUploadSessionService_Open_InvalidRequest(t *testing.T) {
+	service := NewUploadSessionService(nil, nil)
+
+	tests := []struct {
+		name string
+		req  OpenUploadSessionRequest
+	}{
+		{"Unknown mode", OpenUploadSessionRequest{DeviceID: "phone", Mode: "append", TotalChunks: 1}},
+		{"Too many chunks", OpenUploadSessionRequest{DeviceID: "phone", TotalChunks: maxSessionChunks + 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := service.Open(context.Background(), uuid.New(), &tt.req)
+			assert.True(t, errors.Is(err, ErrInvalidUploadSession), "error: %v", err)
+		})
+	}
+}